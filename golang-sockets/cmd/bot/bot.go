@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+	"golang-sockets/pkg/rudp"
+)
+
+// How long to wait for the server during setup
+const setupTimeout = 5 * time.Second
+
+// What one bot saw during the test
+type BotResult struct {
+	ConnectErr   error // Non-nil if the bot never got to play
+	Disconnected bool  // Lost the connection before the test ended
+
+	Guesses    int
+	Stale      int             // Guesses that arrived after their round was over
+	Latencies  []time.Duration // Round-trip time for each guess
+	Errors     map[string]int  // Error messages from the server, by code
+	RoundsWon  int             // Rounds this bot won
+	WinGuesses int             // Guesses this bot made in the rounds it won
+
+	// Every bot in the room sees every round end, so these count each
+	// round once per bot
+	RoundsEnded      int
+	RoundsWithWinner int
+}
+
+// One simulated player.  Bots play by binary search:  every response
+// cuts the range of possible numbers in half, so a bot never needs
+// more than log2(range) guesses.
+type Bot struct {
+	Name    string
+	Address string
+	Room    string
+	Think   time.Duration // Average time between guesses
+	UDP     bool          // Connect over UDP instead of TCP
+
+	conn     net.Conn
+	version  uint8 // Protocol version the server picked, which we send in
+	stopping int32 // Set (atomically) when the test is over
+	result   *BotResult
+
+	settings     game.Settings
+	round        uint32 // Round we're guessing in
+	lo, hi       int32  // Target is somewhere in [lo, hi]
+	roundGuesses int    // Guesses we've made this round
+	myTurn       bool
+	outOfGuesses bool
+}
+
+// Play until done is closed, or the connection is lost
+func (b *Bot) Run(done chan struct{}) *BotResult {
+	b.result = &BotResult{Errors: make(map[string]int)}
+
+	err := b.setup()
+	if err != nil {
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		b.result.ConnectErr = err
+		return b.result
+	}
+
+	// Closing the socket is the easiest way to interrupt a read
+	closed := make(chan struct{})
+	go func() {
+		<-done
+		atomic.StoreInt32(&b.stopping, 1)
+		b.conn.Close()
+		close(closed)
+	}()
+
+	err = b.play(done)
+	if err != nil && atomic.LoadInt32(&b.stopping) == 0 {
+		b.result.Disconnected = true
+	}
+
+	// Over UDP, Close takes a moment to make sure the server heard
+	// about it, so don't let the program exit until then
+	<-closed
+	return b.result
+}
+
+// Connect, say hello, and join the room
+func (b *Bot) setup() error {
+	var conn net.Conn
+	var err error
+	if b.UDP {
+		conn, err = rudp.DialTimeout("udp4", b.Address, setupTimeout)
+	} else {
+		conn, err = net.DialTimeout("tcp4", b.Address, setupTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+
+	err = protocol.WriteMessage(conn, &protocol.HelloMessage{
+		Name:     b.Name,
+		Versions: protocol.SupportedVersions(),
+	})
+	if err != nil {
+		return err
+	}
+	reply, err := b.read(setupTimeout)
+	if err != nil {
+		return err
+	}
+	switch m := reply.(type) {
+	case *protocol.ErrorMessage:
+		return fmt.Errorf("server said:  %s", m.Text)
+	case *protocol.WelcomeMessage:
+		b.version = m.Version
+	}
+
+	err = b.send(&protocol.JoinRoomMessage{Name: b.Room})
+	if err != nil {
+		return err
+	}
+
+	// Wait until we know the rules
+	for {
+		msg, err := b.read(setupTimeout)
+		if err != nil {
+			return err
+		}
+		if e, ok := msg.(*protocol.ErrorMessage); ok {
+			return fmt.Errorf("server said:  %s", e.Text)
+		}
+		b.handleEvent(msg)
+		if _, ok := msg.(*protocol.RulesMessage); ok {
+			b.newRound()
+			return nil
+		}
+	}
+}
+
+func (b *Bot) play(done chan struct{}) error {
+	for {
+		// Nothing to do but listen until we can guess again
+		if (b.settings.TurnBased && !b.myTurn) || b.outOfGuesses {
+			msg, err := b.read(0)
+			if err != nil {
+				return err
+			}
+			b.handleEvent(msg)
+			continue
+		}
+
+		if !b.sleep(b.thinkTime(), done) {
+			return nil
+		}
+
+		err := b.guess()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Read the next message we understand.  Ones we can't decode (from a
+// newer server, say) are skipped:  they were read in full, so the next
+// one still makes sense.
+func (b *Bot) read(timeout time.Duration) (protocol.Message, error) {
+	for {
+		msg, err := protocol.ReadMessage(b.conn, timeout)
+		if errors.Is(err, protocol.ErrUnknownMessageType) || errors.Is(err, protocol.ErrMalformedMessage) {
+			continue
+		}
+		return msg, err
+	}
+}
+
+// Make one guess, and wait for the answer
+func (b *Bot) guess() error {
+	if b.lo > b.hi {
+		// Our range doesn't make sense any more, probably because
+		// someone else won, and we haven't heard about the new round yet
+		b.newRound()
+	}
+	n := b.lo + (b.hi-b.lo)/2
+
+	start := time.Now()
+	err := b.send(&protocol.GuessMessage{
+		MessageType: protocol.MessageTypeGuess,
+		Number:      n,
+		Round:       b.round,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := b.read(0)
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.(type) {
+		case *protocol.GuessMessage:
+			if m.MessageType != protocol.MessageTypeResponse {
+				b.handleEvent(m)
+				continue
+			}
+			b.result.Latencies = append(b.result.Latencies, time.Since(start))
+			b.result.Guesses++
+
+			// If the round ended while our guess was on the way, the
+			// answer doesn't tell us anything about the new target
+			if m.Number == game.GuessRoundOver || m.Round != b.round {
+				b.result.Stale++
+				return nil
+			}
+			b.roundGuesses++
+
+			// The server passes the turn on after every guess, and
+			// tells us when it's our turn again
+			if b.settings.TurnBased {
+				b.myTurn = false
+			}
+
+			switch m.Number {
+			case game.GuessTooHigh:
+				b.hi = n - 1
+			case game.GuessTooLow:
+				b.lo = n + 1
+			case game.GuessCorrect:
+				b.result.RoundsWon++
+				b.result.WinGuesses += b.roundGuesses
+			}
+			return nil
+
+		case *protocol.ErrorMessage:
+			b.handleError(m)
+			return nil
+
+		default:
+			b.handleEvent(msg)
+		}
+	}
+}
+
+func (b *Bot) handleError(m *protocol.ErrorMessage) {
+	b.result.Errors[protocol.ErrorCodeName(m.Code)]++
+
+	switch m.Code {
+	case protocol.ErrorCodeNotYourTurn:
+		b.myTurn = false
+	case protocol.ErrorCodeNoGuesses:
+		b.outOfGuesses = true
+	case protocol.ErrorCodeOutOfRange:
+		b.newRound()
+	case protocol.ErrorCodeRateLimited:
+		// Give the server's rate limiter a chance to refill
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// Send a message in the version the server picked
+func (b *Bot) send(m protocol.Message) error {
+	if b.version == 0 {
+		return protocol.WriteMessage(b.conn, m)
+	}
+	return protocol.WriteMessageVersion(b.conn, m, b.version)
+}
+
+// Keep track of everything the server tells us that isn't an answer
+// to a guess
+func (b *Bot) handleEvent(msg protocol.Message) {
+	switch m := msg.(type) {
+	case *protocol.PingMessage:
+		if m.MessageType == protocol.MessageTypePing {
+			b.send(&protocol.PingMessage{
+				MessageType: protocol.MessageTypePong,
+				Seq:         m.Seq,
+			})
+		}
+	case *protocol.RoomJoinedMessage:
+		b.round = m.Round
+	case *protocol.RulesMessage:
+		b.settings = game.SettingsFromWire(m.Settings)
+	case *protocol.TurnMessage:
+		b.myTurn = m.Name == b.Name
+	case *protocol.RoundOverMessage:
+		b.result.RoundsEnded++
+		if m.Winner != "" {
+			b.result.RoundsWithWinner++
+		}
+	case *protocol.GuessMessage:
+		if m.MessageType == protocol.MessageTypeNewGame {
+			b.round = m.Round
+			b.newRound()
+		}
+	case *protocol.ErrorMessage:
+		b.handleError(m)
+	}
+}
+
+// Forget everything we learned about the last target
+func (b *Bot) newRound() {
+	b.lo = b.settings.MinNumber
+	b.hi = b.settings.MaxNumber - 1
+	b.roundGuesses = 0
+	b.outOfGuesses = false
+}
+
+// Think for somewhere between half and one and a half times b.Think,
+// so the bots don't all guess in lockstep
+func (b *Bot) thinkTime() time.Duration {
+	if b.Think <= 0 {
+		return 0
+	}
+	return b.Think/2 + time.Duration(rand.Int63n(int64(b.Think)))
+}
+
+// Sleep, unless the test ends first.  Returns false if it did.
+func (b *Bot) sleep(d time.Duration, done chan struct{}) bool {
+	if d <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
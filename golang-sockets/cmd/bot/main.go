@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang-sockets/pkg/game"
+)
+
+// Load generator for the guessing game server:  starts lots of bots
+// that play the game as fast as we tell them to, and reports how the
+// server held up.
+//
+// The server's default rate limit is meant for people, so for load
+// tests, run the server with -rate 0 (and probably a throwaway
+// -stats file).
+func main() {
+	numBots := flag.Int("n", 10, "number of bots (connections)")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run the test")
+	think := flag.Duration("think", 200*time.Millisecond,
+		"average time each bot waits between guesses (0 to guess as fast as possible)")
+	rampUp := flag.Duration("ramp-up", 0, "spread out starting the bots over this long")
+	room := flag.String("room", game.DefaultRoomName, "room for the bots to play in")
+	prefix := flag.String("name", "bot", "prefix for bot names")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON instead of a table")
+	udp := flag.Bool("udp", false, "connect over UDP instead of TCP (the server needs -udp too)")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		log.Fatalf("Usage:  %s [options] <address> <port number>", os.Args[0])
+	}
+	if *numBots <= 0 {
+		log.Fatalln("Need at least one bot")
+	}
+	address := net.JoinHostPort(flag.Arg(0), flag.Arg(1))
+
+	rand.Seed(time.Now().UnixNano())
+
+	// Names need to be unique, even if an earlier run's bots are still
+	// holding on to their sessions
+	runId := rand.Intn(100000)
+
+	results := make([]*BotResult, *numBots)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	log.Printf("Starting %d bot(s) against %s for %v\n", *numBots, address, *duration)
+	start := time.Now()
+	for i := 0; i < *numBots; i++ {
+		bot := &Bot{
+			Name:    fmt.Sprintf("%s-%d-%d", *prefix, runId, i),
+			Address: address,
+			Room:    *room,
+			Think:   *think,
+			UDP:     *udp,
+		}
+
+		// Start bot i at i/n of the way through the ramp up
+		delay := *rampUp * time.Duration(i) / time.Duration(*numBots)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case <-time.After(delay):
+			case <-done:
+				results[i] = &BotResult{ConnectErr: fmt.Errorf("test ended before the bot started")}
+				return
+			}
+			results[i] = bot.Run(done)
+		}(i)
+	}
+
+	time.Sleep(*duration)
+	close(done)
+	elapsed := time.Since(start)
+	wg.Wait()
+
+	report := makeReport(results, elapsed)
+	if *jsonOutput {
+		err := report.WriteJSON(os.Stdout)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		report.WriteTable(os.Stdout)
+	}
+}
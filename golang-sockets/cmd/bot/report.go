@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Upper edges of the latency histogram buckets, in milliseconds.  The
+// last bucket catches everything slower.
+var bucketEdgesMs = []float64{0.1, 0.2, 0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+type LatencyReport struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type Bucket struct {
+	UpToMs float64 `json:"up_to_ms"` // +Inf for the last bucket
+	Count  int     `json:"count"`
+}
+
+// Everything we measured, added up over all the bots
+type Report struct {
+	Bots            int     `json:"bots"`
+	Connected       int     `json:"connected"`
+	ConnectFailures int     `json:"connect_failures"`
+	Disconnects     int     `json:"disconnects"`
+	DurationSec     float64 `json:"duration_sec"`
+
+	Guesses       int            `json:"guesses"`
+	StaleGuesses  int            `json:"stale_guesses"` // Too late for their round
+	GuessesPerSec float64        `json:"guesses_per_sec"`
+	LatencyMs     LatencyReport  `json:"latency_ms"`
+	Histogram     []Bucket       `json:"histogram"`
+	Errors        map[string]int `json:"errors"`
+
+	RoundsWon        int      `json:"rounds_won"`
+	RoundsPerSec     float64  `json:"rounds_per_sec"`
+	GuessesPerWin    float64  `json:"guesses_per_win"`
+	CompletionRate   float64  `json:"completion_rate"` // Fraction of rounds that ended with a winner
+	FirstConnectErrs []string `json:"first_connect_errors,omitempty"`
+}
+
+// Show at most this many connection errors (they're usually all the same)
+const maxConnectErrs = 5
+
+func makeReport(results []*BotResult, duration time.Duration) *Report {
+	r := &Report{
+		Bots:        len(results),
+		DurationSec: duration.Seconds(),
+		Errors:      make(map[string]int),
+	}
+
+	var latencies []time.Duration
+	roundsEnded, roundsWithWinner, winGuesses := 0, 0, 0
+
+	for _, res := range results {
+		if res.ConnectErr != nil {
+			r.ConnectFailures++
+			if len(r.FirstConnectErrs) < maxConnectErrs {
+				r.FirstConnectErrs = append(r.FirstConnectErrs, res.ConnectErr.Error())
+			}
+			continue
+		}
+		r.Connected++
+		if res.Disconnected {
+			r.Disconnects++
+		}
+
+		r.Guesses += res.Guesses
+		r.StaleGuesses += res.Stale
+		latencies = append(latencies, res.Latencies...)
+		for code, n := range res.Errors {
+			r.Errors[code] += n
+		}
+
+		r.RoundsWon += res.RoundsWon
+		winGuesses += res.WinGuesses
+		roundsEnded += res.RoundsEnded
+		roundsWithWinner += res.RoundsWithWinner
+	}
+
+	if duration > 0 {
+		r.GuessesPerSec = float64(r.Guesses) / duration.Seconds()
+		r.RoundsPerSec = float64(r.RoundsWon) / duration.Seconds()
+	}
+	if r.RoundsWon > 0 {
+		r.GuessesPerWin = float64(winGuesses) / float64(r.RoundsWon)
+	}
+	if roundsEnded > 0 {
+		r.CompletionRate = float64(roundsWithWinner) / float64(roundsEnded)
+	}
+
+	r.LatencyMs, r.Histogram = summarizeLatencies(latencies)
+
+	return r
+}
+
+func summarizeLatencies(latencies []time.Duration) (LatencyReport, []Bucket) {
+	buckets := make([]Bucket, len(bucketEdgesMs)+1)
+	for i, edge := range bucketEdgesMs {
+		buckets[i].UpToMs = edge
+	}
+	buckets[len(bucketEdgesMs)].UpToMs = math.Inf(1)
+
+	if len(latencies) == 0 {
+		return LatencyReport{}, buckets
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	total := time.Duration(0)
+	for _, l := range latencies {
+		total += l
+		ms := toMs(l)
+		i := sort.SearchFloat64s(bucketEdgesMs, ms)
+		buckets[i].Count++
+	}
+
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(latencies)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return toMs(latencies[i])
+	}
+
+	return LatencyReport{
+		Mean: toMs(total / time.Duration(len(latencies))),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  toMs(latencies[len(latencies)-1]),
+	}, buckets
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *Report) WriteJSON(out io.Writer) error {
+	// JSON has no infinity, so the last bucket's edge is left out
+	type jsonBucket struct {
+		UpToMs *float64 `json:"up_to_ms,omitempty"`
+		Count  int      `json:"count"`
+	}
+	type jsonReport struct {
+		*Report
+		Histogram []jsonBucket `json:"histogram"`
+	}
+
+	jr := jsonReport{Report: r}
+	for i := range r.Histogram {
+		b := jsonBucket{Count: r.Histogram[i].Count}
+		if !math.IsInf(r.Histogram[i].UpToMs, 1) {
+			b.UpToMs = &r.Histogram[i].UpToMs
+		}
+		jr.Histogram = append(jr.Histogram, b)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jr)
+}
+
+// Width of the longest histogram bar
+const barWidth = 40
+
+func (r *Report) WriteTable(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Bots\t%d (%d connected, %d failed to connect, %d disconnected)\n",
+		r.Bots, r.Connected, r.ConnectFailures, r.Disconnects)
+	fmt.Fprintf(w, "Duration\t%.1fs\n", r.DurationSec)
+	fmt.Fprintf(w, "Guesses\t%d (%.1f/s, %d too late for their round)\n",
+		r.Guesses, r.GuessesPerSec, r.StaleGuesses)
+	fmt.Fprintf(w, "Rounds won\t%d (%.2f/s, %.1f guesses per win)\n",
+		r.RoundsWon, r.RoundsPerSec, r.GuessesPerWin)
+	fmt.Fprintf(w, "Rounds with a winner\t%.1f%%\n", 100*r.CompletionRate)
+	fmt.Fprintf(w, "Latency (ms)\tmean %.3f  p50 %.3f  p90 %.3f  p99 %.3f  max %.3f\n",
+		r.LatencyMs.Mean, r.LatencyMs.P50, r.LatencyMs.P90, r.LatencyMs.P99, r.LatencyMs.Max)
+
+	codes := make([]string, 0, len(r.Errors))
+	for code := range r.Errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "Errors:  %s\t%d\n", code, r.Errors[code])
+	}
+	for _, err := range r.FirstConnectErrs {
+		fmt.Fprintf(w, "Connect error\t%s\n", err)
+	}
+	w.Flush()
+
+	// Histogram, skipping empty buckets at either end
+	first, last := -1, -1
+	maxCount := 0
+	for i, b := range r.Histogram {
+		if b.Count > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+		if b.Count > maxCount {
+			maxCount = b.Count
+		}
+	}
+	if first < 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "\nLatency histogram:")
+	for i := first; i <= last; i++ {
+		b := r.Histogram[i]
+		label := fmt.Sprintf("<= %gms", b.UpToMs)
+		if math.IsInf(b.UpToMs, 1) {
+			label = fmt.Sprintf(">  %gms", bucketEdgesMs[len(bucketEdgesMs)-1])
+		}
+		bar := strings.Repeat("#", b.Count*barWidth/maxCount)
+		fmt.Fprintf(out, "  %-10s  %8d  %s\n", label, b.Count, bar)
+	}
+}
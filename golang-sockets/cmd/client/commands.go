@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+const helpText = `Commands:
+  <number>               Guess a number
+  /rooms                 List rooms
+  /create <name> [rule=value...]
+                         Create a room and join it.  Rules are min, max
+                         (numbers are min to max-1), guesses (per round),
+                         time (eg. time=2m), turns (on or off) and
+                         turntime (eg. turntime=30s)
+  /join <name>           Join a room
+  /leave                 Go back to the lobby
+  /watch [room]          Stop playing, and watch a room (default:  every room)
+  /top [n]               Show the top n players (default 10)
+  /stats [name]          Show statistics for a player (default:  you)
+  /help                  Show this message`
+
+// Handle one line of keyboard input:  either a guess or a command
+func HandleCommand(line string, conn net.Conn, session *Session) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+
+	if !strings.HasPrefix(fields[0], "/") {
+		guess, err := strconv.Atoi(fields[0])
+		if err != nil {
+			fmt.Fprintf(out, "Invalid guess:  %s\n", line)
+			return
+		}
+		SendGuess(guess, session.Round, conn)
+		return
+	}
+
+	var msg protocol.Message
+	switch fields[0] {
+	case "/rooms":
+		msg = &protocol.ListRoomsMessage{}
+
+	case "/create":
+		if len(fields) < 2 {
+			fmt.Fprintln(out, "Usage:  /create <name> [rule=value...]")
+			return
+		}
+		settings, err := game.ParseRules(fields[2:], game.DefaultSettings())
+		if err != nil {
+			fmt.Fprintln(out, "Invalid rules:  ", err)
+			return
+		}
+		msg = &protocol.CreateRoomMessage{Name: fields[1], Settings: settings.Wire()}
+
+	case "/join":
+		if len(fields) != 2 {
+			fmt.Fprintln(out, "Usage:  /join <name>")
+			return
+		}
+		msg = &protocol.JoinRoomMessage{Name: fields[1]}
+
+	case "/leave":
+		msg = &protocol.LeaveRoomMessage{}
+
+	case "/watch":
+		room := "*"
+		if len(fields) > 1 {
+			room = fields[1]
+		}
+		msg = spectateMessage(room)
+
+	case "/top":
+		count := uint64(10)
+		if len(fields) > 1 {
+			var err error
+			count, err = strconv.ParseUint(fields[1], 10, 16)
+			if err != nil {
+				fmt.Fprintf(out, "Invalid count:  %s\n", fields[1])
+				return
+			}
+		}
+		msg = &protocol.GetTopMessage{Count: uint16(count)}
+
+	case "/stats":
+		name := ""
+		if len(fields) > 1 {
+			name = fields[1]
+		}
+		msg = &protocol.GetStatsMessage{Name: name}
+
+	case "/help":
+		fmt.Fprintln(out, helpText)
+		return
+
+	default:
+		fmt.Fprintf(out, "Unknown command %s, try /help\n", fields[0])
+		return
+	}
+
+	err := send(conn, msg)
+	if err != nil {
+		fmt.Fprintln(out, "Write error:  ", err)
+	}
+}
+
+// Ask to watch a room ("*" for every room)
+func spectateMessage(room string) *protocol.SpectateMessage {
+	if room == "*" {
+		room = ""
+	}
+	return &protocol.SpectateMessage{MessageType: protocol.MessageTypeSpectate, Room: room}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+)
+
+// What scripts print for each message from the server:  one JSON object
+// per line.  Which fields are filled in depends on Event.  Results and
+// reasons use the same words as the server's journal (see pkg/journal).
+type Event struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+
+	Kind   string `json:"kind,omitempty"` // game_event:  guess, joined, left or round_over
+	Room   string `json:"room,omitempty"`
+	Round  uint32 `json:"round,omitempty"`
+	Player string `json:"player,omitempty"`
+
+	// response:  too_high, too_low, correct or too_late
+	// round_over, game_event:  why the round ended (won, time_up, ...)
+	Result string `json:"result,omitempty"`
+
+	// round_over:  who won (if anybody), the target and how many
+	// guesses everyone made
+	// game_event:  the number guessed, or the target
+	Winner  string                   `json:"winner,omitempty"`
+	Number  *int32                   `json:"number,omitempty"`
+	Guesses uint32                   `json:"guesses,omitempty"`
+	Players []protocol.PlayerGuesses `json:"players,omitempty"`
+
+	// turn:  how long the player has, and who just ran out of time
+	TurnTimeoutMs uint32 `json:"turn_timeout_ms,omitempty"`
+	Skipped       string `json:"skipped,omitempty"`
+
+	// error (and shutdown, which only has Text)
+	Code string `json:"code,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	Settings *protocol.RoomSettings `json:"settings,omitempty"`
+	Rooms    []protocol.RoomInfo    `json:"rooms,omitempty"`
+	Stats    []protocol.PlayerStats `json:"stats,omitempty"`
+}
+
+// Names for GameEventMessage events
+var gameEventNames = map[uint8]string{
+	protocol.GameEventGuess:     "guess",
+	protocol.GameEventJoined:    "joined",
+	protocol.GameEventLeft:      "left",
+	protocol.GameEventRoundOver: "round_over",
+}
+
+// Turn a message from the server into an Event
+func MessageEvent(m protocol.Message) Event {
+	ev := Event{Time: time.Now()}
+
+	switch msg := m.(type) {
+	case *protocol.GuessMessage:
+		if msg.MessageType == protocol.MessageTypeResponse {
+			ev.Event = "response"
+			ev.Result = game.JournalResult(msg.Number)
+		} else {
+			ev.Event = "new_game"
+			ev.Round = msg.Round
+		}
+	case *protocol.RoomListMessage:
+		ev.Event = "rooms"
+		ev.Rooms = msg.Rooms
+	case *protocol.RoomJoinedMessage:
+		ev.Event = "joined"
+		ev.Room = msg.Name
+		ev.Round = msg.Round
+		ev.Settings = &msg.Settings
+	case *protocol.RulesMessage:
+		ev.Event = "rules"
+		ev.Room = msg.Room
+		ev.Settings = &msg.Settings
+	case *protocol.RoomLeftMessage:
+		ev.Event = "left"
+	case *protocol.PlayerEventMessage:
+		ev.Event = "player_left"
+		if msg.Event == protocol.PlayerEventJoined {
+			ev.Event = "player_joined"
+		}
+		ev.Player = msg.Name
+	case *protocol.RoundOverMessage:
+		ev.Event = "round_over"
+		ev.Round = msg.Round
+		ev.Result = journal.ResetReason(msg.Reason)
+		ev.Winner = msg.Winner
+		ev.Number = journal.Int32(msg.Target)
+		ev.Guesses = msg.TotalGuesses
+		ev.Players = msg.Players
+	case *protocol.TurnMessage:
+		ev.Event = "turn"
+		ev.Player = msg.Name
+		ev.TurnTimeoutMs = msg.TurnTimeoutMs
+		ev.Skipped = msg.Skipped
+	case *protocol.TopMessage:
+		ev.Event = "top"
+		ev.Stats = msg.Players
+	case *protocol.StatsMessage:
+		ev.Event = "stats"
+		ev.Stats = []protocol.PlayerStats{msg.Stats}
+	case *protocol.SpectateMessage:
+		ev.Event = "watching"
+		ev.Room = msg.Room
+	case *protocol.GameEventMessage:
+		ev.Event = "game_event"
+		ev.Time = time.UnixMilli(msg.Time)
+		ev.Room = msg.Room
+		ev.Round = msg.Round
+		ev.Player = msg.Player
+		ev.Guesses = msg.Guesses
+		ev.Number = journal.Int32(msg.Number)
+		ev.Kind = gameEventNames[msg.Event]
+		if msg.Event == protocol.GameEventGuess {
+			ev.Result = game.JournalResult(msg.Result)
+		} else if msg.Event == protocol.GameEventRoundOver {
+			ev.Result = journal.ResetReason(uint8(msg.Result))
+		}
+	case *protocol.ErrorMessage:
+		ev.Event = "error"
+		ev.Code = protocol.ErrorCodeName(msg.Code)
+		ev.Text = msg.Text
+	case *protocol.ShutdownMessage:
+		ev.Event = "shutdown"
+		ev.Text = msg.Reason
+	default:
+		ev.Event = "unknown"
+		ev.Text = protocol.MessageName(m.Type())
+	}
+
+	return ev
+}
+
+// Print a message from the server as one line of JSON
+func PrintEvent(w io.Writer, m protocol.Message) {
+	WriteEvent(w, MessageEvent(m))
+}
+
+func WriteEvent(w io.Writer, ev Event) {
+	line, err := json.Marshal(&ev)
+	if err != nil {
+		log.Println("Can't print event:  ", err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", line)
+}
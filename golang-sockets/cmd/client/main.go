@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+func main() {
+	name := flag.String("name", "", "player name (default:  picked by the server)")
+	reconnect := flag.Bool("reconnect", true, "reconnect automatically if the connection is lost")
+	udp := flag.Bool("udp", false, "connect over UDP instead of TCP (the server needs -udp too)")
+	watch := flag.String("watch", "", "watch a room instead of playing (* for every room)")
+	serverTimeout := flag.Duration("server-timeout", 45*time.Second,
+		"assume the connection is lost if the server is silent for this long (0 to wait forever)")
+	scriptFile := flag.String("script", "",
+		"play the actions in this file (- for stdin) instead of reading the keyboard, printing events as JSON (see script.go)")
+	waitTimeout := flag.Duration("wait-timeout", 10*time.Second, "how long each wait in a script can take")
+	useTUI := flag.Bool("tui", true, "use the full-screen terminal UI (if we're running in a terminal)")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		log.Fatalf("Usage:  %s [options] <address> <port number>",
+			os.Args[0])
+	}
+
+	var script []scriptAction
+	if *scriptFile != "" {
+		var err error
+		script, err = loadScript(*scriptFile)
+		if err != nil {
+			log.Fatalln("Error reading script:  ", err)
+		}
+	}
+
+	// Variables in golang:  if we use :=,
+	// the compiler will automatically determine the type
+	address := flag.Arg(0)
+	portNumber := flag.Arg(1)
+
+	addrToUse := net.JoinHostPort(address, portNumber)
+
+	// Otherwise every client would wait the same "random" time before
+	// reconnecting (see Session.Reconnect)
+	rand.Seed(time.Now().UnixNano())
+
+	// Everything we need to remember across reconnects
+	session := &Session{
+		Address:       addrToUse,
+		UDP:           *udp,
+		Name:          *name,
+		ServerTimeout: *serverTimeout,
+	}
+
+	conn, err := session.Connect()
+	if err != nil {
+		log.Fatalln("Error connecting:  ", err)
+	}
+
+	// Get a net.TCPConn from a net.Conn
+	// (This is called a type assertion)
+	//tcpConn := conn.(*net.TCPConn)
+
+	if script != nil {
+		WriteEvent(os.Stdout, Event{Time: time.Now(), Event: "connected", Player: session.Name})
+	}
+
+	if *watch != "" {
+		send(conn, spectateMessage(*watch))
+	} else {
+		// Start out in the default room, so we can start guessing right away
+		send(conn, &protocol.JoinRoomMessage{Name: game.DefaultRoomName})
+	}
+
+	// Scripts don't reconnect:  losing the connection is one of the
+	// things they're there to find
+	if script != nil {
+		status := RunScript(conn, session, script, *waitTimeout)
+		conn.Close()
+		os.Exit(status)
+	}
+
+	// We would like to be able to read from the socket and take keyboard input
+	// at the same time--this way, the server can send us messages even while
+	// we're waiting for the user to enter a guess
+	// One way to do this is to create separate goroutines to watch each input source,
+	// and then use channels to signal the main loop to act on the data
+	keyboardChan := make(chan string, 1)
+
+	if *useTUI {
+		ui, err = StartTUI(session, keyboardChan)
+		if err != nil {
+			ui = nil
+		}
+	}
+
+	fmt.Fprintf(out, "Connected as %s!  Type /help for a list of commands.\n", session.Name)
+
+	if ui == nil {
+		// Blocking operation:  read from keyboard
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				// Wait for a line of input, send to main loop
+				keyboardChan <- scanner.Text()
+			}
+		}()
+	}
+
+	// The UI has to give the terminal back before we say why we quit
+	for {
+		RunConnection(conn, session, keyboardChan)
+		conn.Close()
+
+		if session.Kicked {
+			ui.Close()
+			fmt.Fprintln(out, "Kicked by the server")
+			return
+		}
+		if !*reconnect {
+			ui.Close()
+			fmt.Fprintln(out, "Server closed connection")
+			return
+		}
+
+		fmt.Fprintln(out, "Lost connection to server")
+		conn = session.Reconnect(keyboardChan)
+	}
+}
+
+// Main loop for one connection to the server.  Returns when the
+// connection is lost.
+func RunConnection(conn net.Conn, session *Session, keyboardChan chan string) {
+	msgChan := make(chan protocol.Message, 1)
+	doneChan := make(chan struct{}, 1)
+
+	// Start a goroutine to wait for a message from the server
+	go HandleResponses(conn, session.ServerTimeout, msgChan, doneChan)
+
+	for {
+		// Watch both channels, do something when an event happens
+		select {
+		case line := <-keyboardChan: // Input from keyboard
+			HandleCommand(line, conn, session)
+		case response := <-msgChan: // Input from socket
+			// Answer pings right away, so the server knows we're still here
+			if ping, ok := response.(*protocol.PingMessage); ok {
+				if ping.MessageType == protocol.MessageTypePing {
+					ping.MessageType = protocol.MessageTypePong
+					send(conn, ping)
+				}
+				continue
+			}
+			session.TrackRoom(response)
+			PrintResponses(response, session.Name)
+			ui.Update(response, session)
+		case <-doneChan:
+			return
+		}
+	}
+}
+
+// How long to wait for the server to answer our Hello
+const handshakeTimeout = 5 * time.Second
+
+// Say hello to the server, and wait for it to accept us
+func DoHandshake(conn net.Conn, name string, resumeToken string) (*protocol.WelcomeMessage, error) {
+	err := protocol.WriteMessage(conn, &protocol.HelloMessage{
+		Name:        name,
+		Versions:    protocol.SupportedVersions(),
+		ResumeToken: resumeToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := protocol.ReadMessage(conn, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg := reply.(type) {
+	case *protocol.WelcomeMessage:
+		if msg.Version != 0 {
+			serverVersion = msg.Version
+		}
+		return msg, nil
+	case *protocol.ErrorMessage:
+		return nil, fmt.Errorf("server said:  %s", msg.Text)
+	default:
+		return nil, fmt.Errorf("unexpected %s message", protocol.MessageName(reply.Type()))
+	}
+}
+
+// Protocol version the server picked in the last handshake, which
+// everything we send after the Hello has to use
+var serverVersion uint8 = protocol.ProtocolVersion
+
+// Send a message in the version the server picked
+func send(conn net.Conn, m protocol.Message) error {
+	return protocol.WriteMessageVersion(conn, m, serverVersion)
+}
+
+// Errors the server answers a guess with instead of a response.  Any
+// other error (like no such room) is about something else, and doesn't
+// answer a guess.  Being rate limited could be about anything we sent,
+// but scripts only send guesses (and pongs, which aren't limited), and
+// people can't type commands fast enough to be.
+var guessErrorCodes = map[uint8]bool{
+	protocol.ErrorCodeNotInRoom:   true,
+	protocol.ErrorCodeOutOfRange:  true,
+	protocol.ErrorCodeNoGuesses:   true,
+	protocol.ErrorCodeNotYourTurn: true,
+	protocol.ErrorCodeRateLimited: true,
+}
+
+// Guess a number in the given round
+func SendGuess(num int, round uint32, conn net.Conn) {
+	guess := &protocol.GuessMessage{MessageType: protocol.MessageTypeGuess,
+		Number: int32(num), Round: round}
+
+	err := send(conn, guess)
+	ui.Guessed(int32(num))
+	if err != nil {
+		// If the connection is gone, HandleResponses will notice too,
+		// and we'll try to reconnect
+		fmt.Fprintln(out, "Write error:  ", err)
+	}
+}
+
+// Read messages from the server and pass them to outChan, until the
+// connection is lost (or we close it), then signal doneChan
+func HandleResponses(conn net.Conn, timeout time.Duration, outChan chan protocol.Message, doneChan chan struct{}) {
+	for {
+		msg, err := protocol.ReadMessage(conn, timeout)
+
+		// (see pkg/protocol/errors.go)
+		switch {
+		case err == nil:
+			outChan <- msg
+			continue
+		case errors.Is(err, protocol.ErrUnknownMessageType), errors.Is(err, protocol.ErrMalformedMessage):
+			// Probably from a newer server.  We read the whole
+			// message, so we can skip it and carry on.
+			fmt.Fprintln(os.Stderr, "Ignoring message:  ", err)
+			continue
+		case errors.Is(err, protocol.ErrPeerClosed), errors.Is(err, net.ErrClosed):
+			// The server hung up, or we closed the connection ourselves
+		case errors.Is(err, protocol.ErrTimeout):
+			fmt.Fprintf(os.Stderr, "Heard nothing from the server for %v\n", timeout)
+		default:
+			// Cut off mid-message, or not our protocol:  either way,
+			// the connection is no use any more
+			fmt.Fprintln(os.Stderr, "Read error:  ", err)
+		}
+		doneChan <- struct{}{}
+		return
+	}
+}
+
+// Print a message from the server.  myName is our own name, so we can
+// tell which messages are about us.
+func PrintResponses(m protocol.Message, myName string) {
+	switch msg := m.(type) {
+	case *protocol.GuessMessage:
+		PrintGuessMessage(msg)
+	case *protocol.RoomListMessage:
+		fmt.Fprintf(out, "%d room(s):\n", len(msg.Rooms))
+		for _, room := range msg.Rooms {
+			fmt.Fprintf(out, "  %-16s  %d player(s), %v\n",
+				room.Name, room.NumPlayers, game.SettingsFromWire(room.Settings))
+		}
+	case *protocol.RoomJoinedMessage:
+		fmt.Fprintf(out, "Joined room %s\n", msg.Name)
+		if len(msg.Players) > 0 {
+			fmt.Fprintf(out, "Players here:  %s\n", strings.Join(msg.Players, ", "))
+		}
+	case *protocol.RulesMessage:
+		fmt.Fprintf(out, "Rules for room %s:  %v\n", msg.Room, game.SettingsFromWire(msg.Settings))
+	case *protocol.RoomLeftMessage:
+		fmt.Fprintln(out, "Back in the lobby")
+	case *protocol.PlayerEventMessage:
+		if msg.Event == protocol.PlayerEventJoined {
+			fmt.Fprintf(out, "%s joined the room\n", msg.Name)
+		} else {
+			fmt.Fprintf(out, "%s left the room\n", msg.Name)
+		}
+	case *protocol.RoundOverMessage:
+		PrintRoundOver(msg)
+	case *protocol.TurnMessage:
+		PrintTurn(msg, myName)
+	case *protocol.TopMessage:
+		fmt.Fprintf(out, "%-4s  %-16s  %6s  %8s  %6s\n", "#", "Player", "Wins", "Avg/win", "Best")
+		for i, p := range msg.Players {
+			fmt.Fprintf(out, "%-4d  %-16s  %6d  %8.2f  %6d\n",
+				i+1, p.Name, p.RoundsWon, p.AverageGuessesPerWin(), p.BestRound)
+		}
+	case *protocol.StatsMessage:
+		p := msg.Stats
+		fmt.Fprintf(out, "%s:  %d round(s) played, %d won, %.2f guesses per win, best round %d guess(es)\n",
+			p.Name, p.RoundsPlayed, p.RoundsWon, p.AverageGuessesPerWin(), p.BestRound)
+	case *protocol.SpectateMessage:
+		if msg.Room == "" {
+			fmt.Fprintln(out, "Watching every room")
+		} else {
+			fmt.Fprintf(out, "Watching room %s\n", msg.Room)
+		}
+	case *protocol.GameEventMessage:
+		PrintGameEvent(msg)
+	case *protocol.ErrorMessage:
+		PrintError(msg)
+	case *protocol.ShutdownMessage:
+		fmt.Fprintf(out, "The server is going away:  %s\n", msg.Reason)
+	default:
+		fmt.Fprintln(out, "Invalid message:  ", m)
+	}
+}
+
+func PrintRoundOver(msg *protocol.RoundOverMessage) {
+	switch {
+	case msg.Winner != "":
+		fmt.Fprintf(out, "Round over!  %s guessed the number %d (%d guesses in total)\n",
+			msg.Winner, msg.Target, msg.TotalGuesses)
+	case msg.Reason == protocol.RoundOverTimeUp:
+		fmt.Fprintf(out, "Time's up!  Nobody guessed the number %d (%d guesses in total)\n",
+			msg.Target, msg.TotalGuesses)
+	case msg.Reason == protocol.RoundOverNoGuesses:
+		fmt.Fprintf(out, "Everyone is out of guesses!  Nobody guessed the number %d (%d guesses in total)\n",
+			msg.Target, msg.TotalGuesses)
+	default:
+		fmt.Fprintf(out, "Round over!  Nobody guessed the number %d (%d guesses in total)\n",
+			msg.Target, msg.TotalGuesses)
+	}
+
+	for _, p := range msg.Players {
+		fmt.Fprintf(out, "  %-16s  %d guess(es)\n", p.Name, p.Guesses)
+	}
+}
+
+// Print what a spectator sees
+func PrintGameEvent(msg *protocol.GameEventMessage) {
+	when := time.UnixMilli(msg.Time).Format("15:04:05.000")
+	fmt.Fprintf(out, "%s  [%s, round %d]  ", when, msg.Room, msg.Round)
+
+	switch msg.Event {
+	case protocol.GameEventGuess:
+		result := "invalid response"
+		switch msg.Result {
+		case game.GuessTooHigh:
+			result = "too high"
+		case game.GuessTooLow:
+			result = "too low"
+		case game.GuessCorrect:
+			result = "correct!"
+		}
+		fmt.Fprintf(out, "%s guessed %d:  %s\n", msg.Player, msg.Number, result)
+	case protocol.GameEventJoined:
+		fmt.Fprintf(out, "%s joined\n", msg.Player)
+	case protocol.GameEventLeft:
+		fmt.Fprintf(out, "%s left\n", msg.Player)
+	case protocol.GameEventRoundOver:
+		// Same as what the players see
+		PrintRoundOver(&protocol.RoundOverMessage{
+			Round:        msg.Round,
+			Reason:       uint8(msg.Result),
+			Winner:       msg.Player,
+			Target:       msg.Number,
+			TotalGuesses: msg.Guesses,
+		})
+	default:
+		fmt.Fprintf(out, "unknown event %d\n", msg.Event)
+	}
+}
+
+func PrintError(msg *protocol.ErrorMessage) {
+	switch msg.Code {
+	case protocol.ErrorCodeNotYourTurn:
+		fmt.Fprintln(out, "Wait for your turn!")
+	case protocol.ErrorCodeRateLimited:
+		fmt.Fprintf(out, "Slow down!  %s\n", msg.Text)
+	default:
+		fmt.Fprintf(out, "Error (%s):  %s\n", protocol.ErrorCodeName(msg.Code), msg.Text)
+	}
+}
+
+func PrintTurn(msg *protocol.TurnMessage, myName string) {
+	if msg.Skipped == myName {
+		fmt.Fprintln(out, "You ran out of time!")
+	} else if msg.Skipped != "" {
+		fmt.Fprintf(out, "%s ran out of time\n", msg.Skipped)
+	}
+
+	if msg.Name != myName {
+		fmt.Fprintf(out, "It's %s's turn\n", msg.Name)
+	} else if msg.TurnTimeoutMs > 0 {
+		timeout := time.Duration(msg.TurnTimeoutMs) * time.Millisecond
+		fmt.Fprintf(out, "Your turn!  You have %v to guess\n", timeout)
+	} else {
+		fmt.Fprintln(out, "Your turn!")
+	}
+}
+
+func PrintGuessMessage(msg *protocol.GuessMessage) {
+	if msg.MessageType == protocol.MessageTypeResponse {
+		switch msg.Number {
+		case game.GuessTooHigh:
+			fmt.Fprintln(out, "Too high!")
+		case game.GuessTooLow:
+			fmt.Fprintln(out, "Too low!")
+		case game.GuessCorrect:
+			fmt.Fprintln(out, "YAY!")
+		case game.GuessRoundOver:
+			fmt.Fprintln(out, "Too late, that round is already over!")
+		default:
+			fmt.Fprintln(out, "Invalid response:  ", msg.Number)
+		}
+	} else if msg.MessageType == protocol.MessageTypeNewGame {
+		fmt.Fprintln(out, "New game!")
+	} else {
+		fmt.Fprintln(out, "Invalid message type:  ", msg.MessageType)
+	}
+}
+
+// Read a script from a file, or from stdin if path is "-"
+func loadScript(path string) ([]scriptAction, error) {
+	if path == "-" {
+		return ReadScript(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadScript(f)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+)
+
+// Scripts let the client play without anybody at the keyboard, for
+// testing the server from the shell:
+//
+//	./client -script - localhost 1234 <<EOF
+//	guess 4096
+//	wait response too_high too_low
+//	wait newgame 1m
+//	EOF
+//
+// Every message from the server is printed as one JSON object per line
+// (see events.go), and the exit status says how it went (see the Exit*
+// constants below).  Scripts have one action per line; blank lines and
+// lines starting with # are ignored:
+//
+//	guess <n>                  Guess a number
+//	wait response [result...]  Wait for the answer to our oldest unanswered
+//	                           guess.  If any results are given (too_high,
+//	                           too_low, correct, too_late or error), it has
+//	                           to be one of them.
+//	wait newgame               Wait for a new round to start (returns right
+//	                           away if one started since the last wait newgame)
+//	sleep <duration>           Do nothing for a while (eg. sleep 500ms)
+//
+// waits give up after -wait-timeout, or after a duration given at the
+// end of the line (eg. wait newgame 1m).
+
+// Exit status of a script
+const (
+	ExitOK           = 0 // Every action worked
+	ExitError        = 1 // Bad script, or couldn't connect
+	ExitMismatch     = 2 // A response wasn't what the script expected
+	ExitTimeout      = 3 // Something we waited for never came
+	ExitDisconnected = 4 // Lost the connection, or got kicked
+)
+
+// Result for a guess the server answered with an error
+const resultError = "error"
+
+// Script actions
+const (
+	actionGuess        = "guess"
+	actionWaitResponse = "wait response"
+	actionWaitNewGame  = "wait newgame"
+	actionSleep        = "sleep"
+)
+
+type scriptAction struct {
+	Line    int // Line number in the script, for errors
+	Kind    string
+	Number  int32         // guess
+	Expect  []string      // wait response:  acceptable results (any if empty)
+	Timeout time.Duration // wait:  0 for -wait-timeout; sleep:  how long
+}
+
+var validResults = map[string]bool{
+	journal.TooHigh: true,
+	journal.TooLow:  true,
+	journal.Correct: true,
+	journal.TooLate: true,
+	resultError:     true,
+}
+
+// Read a whole script, so mistakes in it are found before we connect
+func ReadScript(r io.Reader) ([]scriptAction, error) {
+	var actions []scriptAction
+	guesses := 0 // Guesses nothing has waited for yet
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		action, err := parseAction(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d:  %w", line, err)
+		}
+		action.Line = line
+
+		switch action.Kind {
+		case actionGuess:
+			guesses++
+		case actionWaitResponse:
+			if guesses == 0 {
+				return nil, fmt.Errorf("line %d:  no guess to wait for", line)
+			}
+			guesses--
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, scanner.Err()
+}
+
+func parseAction(fields []string) (scriptAction, error) {
+	switch fields[0] {
+	case "guess":
+		if len(fields) != 2 {
+			return scriptAction{}, fmt.Errorf("usage:  guess <n>")
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			return scriptAction{}, fmt.Errorf("invalid guess %s", fields[1])
+		}
+		return scriptAction{Kind: actionGuess, Number: int32(n)}, nil
+
+	case "sleep":
+		if len(fields) != 2 {
+			return scriptAction{}, fmt.Errorf("usage:  sleep <duration>")
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d < 0 {
+			return scriptAction{}, fmt.Errorf("invalid duration %s", fields[1])
+		}
+		return scriptAction{Kind: actionSleep, Timeout: d}, nil
+
+	case "wait":
+		if len(fields) < 2 {
+			return scriptAction{}, fmt.Errorf("usage:  wait response|newgame")
+		}
+		action := scriptAction{Kind: "wait " + fields[1]}
+		args := fields[2:]
+
+		// A duration at the end is a timeout
+		if len(args) > 0 {
+			d, err := time.ParseDuration(args[len(args)-1])
+			if err == nil {
+				if d <= 0 {
+					return scriptAction{}, fmt.Errorf("invalid timeout %s", args[len(args)-1])
+				}
+				action.Timeout = d
+				args = args[:len(args)-1]
+			}
+		}
+
+		switch action.Kind {
+		case actionWaitResponse:
+			for _, result := range args {
+				if !validResults[result] {
+					return scriptAction{}, fmt.Errorf("unknown result %s", result)
+				}
+			}
+			action.Expect = args
+		case actionWaitNewGame:
+			if len(args) > 0 {
+				return scriptAction{}, fmt.Errorf("usage:  wait newgame [timeout]")
+			}
+		default:
+			return scriptAction{}, fmt.Errorf("can't wait for %s", fields[1])
+		}
+		return action, nil
+	}
+
+	return scriptAction{}, fmt.Errorf("unknown action %s", fields[0])
+}
+
+// Runs a script over one connection
+type ScriptRunner struct {
+	conn        net.Conn
+	session     *Session
+	waitTimeout time.Duration
+
+	msgChan  chan protocol.Message
+	doneChan chan struct{}
+
+	// Things that happened that the script hasn't waited for yet
+	unanswered int      // Guesses sent that haven't been answered
+	responses  []string // Answers to them, oldest first
+	newGames   int      // Rounds that started
+}
+
+// Run a script, and return the exit status.  Everything the server
+// sends us along the way is printed as JSON.
+func RunScript(conn net.Conn, session *Session, actions []scriptAction, waitTimeout time.Duration) int {
+	r := &ScriptRunner{
+		conn:        conn,
+		session:     session,
+		waitTimeout: waitTimeout,
+		msgChan:     make(chan protocol.Message, 1),
+		doneChan:    make(chan struct{}, 1),
+	}
+	go HandleResponses(conn, session.ServerTimeout, r.msgChan, r.doneChan)
+
+	for _, action := range actions {
+		status := r.do(action)
+		if status != ExitOK {
+			return status
+		}
+	}
+	return ExitOK
+}
+
+func (r *ScriptRunner) do(action scriptAction) int {
+	switch action.Kind {
+	case actionGuess:
+		err := send(r.conn, &protocol.GuessMessage{
+			MessageType: protocol.MessageTypeGuess,
+			Number:      action.Number,
+			Round:       r.session.Round,
+		})
+		if err != nil {
+			r.fail(action, "write error:  %v", err)
+			return ExitDisconnected
+		}
+		r.unanswered++
+		return ExitOK
+
+	case actionSleep:
+		// Keep reading while we sleep, so we still answer pings
+		return r.waitUntil(action, time.Now().Add(action.Timeout), func() bool { return false })
+
+	case actionWaitResponse:
+		status := r.waitUntil(action, r.deadline(action), func() bool { return len(r.responses) > 0 })
+		if status != ExitOK {
+			return status
+		}
+
+		result := r.responses[0]
+		r.responses = r.responses[1:]
+		if len(action.Expect) > 0 && !contains(action.Expect, result) {
+			r.fail(action, "got %s, expected %s", result, strings.Join(action.Expect, " or "))
+			return ExitMismatch
+		}
+		return ExitOK
+
+	case actionWaitNewGame:
+		status := r.waitUntil(action, r.deadline(action), func() bool { return r.newGames > 0 })
+		if status != ExitOK {
+			return status
+		}
+		r.newGames--
+		return ExitOK
+	}
+
+	r.fail(action, "unknown action")
+	return ExitError
+}
+
+func (r *ScriptRunner) deadline(action scriptAction) time.Time {
+	timeout := action.Timeout
+	if timeout == 0 {
+		timeout = r.waitTimeout
+	}
+	return time.Now().Add(timeout)
+}
+
+// Handle messages from the server until done returns true or the
+// deadline passes.  For sleep, running out of time is the point, so
+// it's not a timeout.
+func (r *ScriptRunner) waitUntil(action scriptAction, deadline time.Time, done func() bool) int {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for !done() {
+		select {
+		case msg := <-r.msgChan:
+			if status := r.handle(msg); status != ExitOK {
+				r.fail(action, "kicked by the server")
+				return status
+			}
+		case <-r.doneChan:
+			r.fail(action, "lost connection to the server")
+			return ExitDisconnected
+		case <-timer.C:
+			if action.Kind == actionSleep {
+				return ExitOK
+			}
+			r.fail(action, "timed out")
+			return ExitTimeout
+		}
+	}
+	return ExitOK
+}
+
+// Handle one message from the server
+func (r *ScriptRunner) handle(m protocol.Message) int {
+	if ping, ok := m.(*protocol.PingMessage); ok {
+		if ping.MessageType == protocol.MessageTypePing {
+			ping.MessageType = protocol.MessageTypePong
+			send(r.conn, ping)
+		}
+		return ExitOK
+	}
+
+	r.session.TrackRoom(m)
+	PrintEvent(os.Stdout, m)
+
+	switch msg := m.(type) {
+	case *protocol.GuessMessage:
+		if msg.MessageType == protocol.MessageTypeResponse {
+			r.answered(game.JournalResult(msg.Number))
+		} else if msg.MessageType == protocol.MessageTypeNewGame {
+			r.newGames++
+		}
+	case *protocol.ErrorMessage:
+		if r.session.Kicked {
+			return ExitDisconnected
+		}
+		// The server answers bad guesses with an error instead
+		if guessErrorCodes[msg.Code] {
+			r.answered(resultError)
+		}
+	}
+	return ExitOK
+}
+
+func (r *ScriptRunner) answered(result string) {
+	if r.unanswered == 0 {
+		return
+	}
+	r.unanswered--
+	r.responses = append(r.responses, result)
+}
+
+// Explain why the script stopped (on stderr, so stdout is only events)
+func (r *ScriptRunner) fail(action scriptAction, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Script line %d (%s):  %s\n",
+		action.Line, action.Kind, fmt.Sprintf(format, args...))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
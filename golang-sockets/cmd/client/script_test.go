@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+// Run a script against a server that answers each guess with replies
+func runScript(t *testing.T, script string, replies func(guess *protocol.GuessMessage) []protocol.Message) int {
+	actions, err := ReadScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		for {
+			msg, err := protocol.ReadMessage(serverConn, 0)
+			if err != nil {
+				return
+			}
+			guess, ok := msg.(*protocol.GuessMessage)
+			if !ok {
+				continue
+			}
+			for _, reply := range replies(guess) {
+				if protocol.WriteMessage(serverConn, reply) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return RunScript(clientConn, &Session{}, actions, time.Second)
+}
+
+func response(result int32) *protocol.GuessMessage {
+	return &protocol.GuessMessage{MessageType: protocol.MessageTypeResponse, Number: result}
+}
+
+// A throttled guess is only ever answered with an error
+func TestScriptRateLimited(t *testing.T) {
+	status := runScript(t, "guess 5\nwait response error\n", func(*protocol.GuessMessage) []protocol.Message {
+		return []protocol.Message{&protocol.ErrorMessage{
+			Code: protocol.ErrorCodeRateLimited,
+			Text: "sending too fast (strike 1 of 5)",
+		}}
+	})
+	if status != ExitOK {
+		t.Errorf("exited with %d, want %d", status, ExitOK)
+	}
+}
+
+// Errors that aren't about a guess don't answer it
+func TestScriptUnrelatedError(t *testing.T) {
+	status := runScript(t, "guess 5\nwait response too_low\n", func(*protocol.GuessMessage) []protocol.Message {
+		return []protocol.Message{
+			&protocol.ErrorMessage{Code: protocol.ErrorCodeNoSuchRoom, Text: "no such room"},
+			response(game.GuessTooLow),
+		}
+	})
+	if status != ExitOK {
+		t.Errorf("exited with %d, want %d", status, ExitOK)
+	}
+}
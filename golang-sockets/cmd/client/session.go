@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+	"golang-sockets/pkg/rudp"
+)
+
+// Limits for how long we wait between reconnect attempts
+const (
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// Everything the client needs to remember across reconnects
+type Session struct {
+	Address       string
+	UDP           bool   // Connect over UDP (see pkg/rudp) instead of TCP
+	Name          string // Empty until the server picks one for us
+	ServerTimeout time.Duration
+
+	ResumeToken string // From the server's last Welcome
+	Room        string // Room we're in, or empty for the lobby
+	Round       uint32 // Round going on in our room, so our guesses count toward it
+	Watching    string // Room we're watching as a spectator ("*" for every room), or empty
+
+	Kicked bool // The server kicked us out, so don't come back
+}
+
+// Connect to the server and say hello.  If we've been connected
+// before, we ask the server to resume our old session, and tell the
+// user what came back.
+func (s *Session) Connect() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if s.UDP {
+		conn, err = rudp.Dial("udp4", s.Address)
+	} else {
+		conn, err = net.Dial("tcp4", s.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	welcome, err := DoHandshake(conn, s.Name, s.ResumeToken)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reconnecting := s.ResumeToken != ""
+	s.Name = welcome.Name
+	s.ResumeToken = welcome.ResumeToken
+
+	if welcome.Resumed {
+		s.Room = welcome.Room
+		if s.Room != "" {
+			fmt.Fprintf(out, "Resumed session as %s:  back in room %s, with %d guess(es) so far this round\n",
+				s.Name, s.Room, welcome.RoundGuesses)
+		} else {
+			fmt.Fprintf(out, "Resumed session as %s, in the lobby\n", s.Name)
+		}
+	} else if reconnecting {
+		// The server didn't know our session (probably because it
+		// restarted), so all we can do is try to get back to our room
+		fmt.Fprintf(out, "Could not resume session, reconnected as new player %s\n", s.Name)
+		if s.Room != "" {
+			fmt.Fprintf(out, "Trying to rejoin room %s\n", s.Room)
+			send(conn, &protocol.JoinRoomMessage{Name: s.Room})
+		}
+	}
+
+	// Sessions don't remember spectating, so ask again
+	if reconnecting && s.Watching != "" {
+		send(conn, spectateMessage(s.Watching))
+	}
+
+	return conn, nil
+}
+
+// Keep trying to connect until it works, waiting longer after each
+// failure (exponential backoff).  Keyboard input is ignored while
+// we're disconnected.
+func (s *Session) Reconnect(keyboardChan chan string) net.Conn {
+	backoff := initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		// Add some randomness ("jitter") to the wait:  if the server
+		// restarts, this keeps all of its clients from reconnecting at
+		// exactly the same time
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		fmt.Fprintf(out, "Reconnecting in %v (attempt %d)...\n", wait.Round(time.Millisecond), attempt)
+
+		timer := time.NewTimer(wait)
+	waitLoop:
+		for {
+			select {
+			case <-timer.C:
+				break waitLoop
+			case line := <-keyboardChan:
+				fmt.Fprintf(out, "Not connected, ignoring:  %s\n", line)
+			}
+		}
+
+		conn, err := s.Connect()
+		if err == nil {
+			fmt.Fprintln(out, "Reconnected!")
+			return conn
+		}
+		fmt.Fprintln(out, "Reconnect failed:  ", err)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Keep track of which room (and round) we're in, or which one we're
+// watching, so we know where to go back to, and whether we've been
+// kicked out
+func (s *Session) TrackRoom(m protocol.Message) {
+	switch msg := m.(type) {
+	case *protocol.RoomJoinedMessage:
+		s.Room = msg.Name
+		s.Round = msg.Round
+		s.Watching = ""
+	case *protocol.SpectateMessage:
+		s.Room = ""
+		s.Round = 0
+		s.Watching = msg.Room
+		if s.Watching == "" {
+			s.Watching = "*"
+		}
+	case *protocol.GuessMessage:
+		if msg.MessageType == protocol.MessageTypeNewGame {
+			s.Round = msg.Round
+		}
+	case *protocol.RoomLeftMessage:
+		s.Room = ""
+		s.Round = 0
+		s.Watching = ""
+	case *protocol.ErrorMessage:
+		if msg.Code == protocol.ErrorCodeKicked {
+			s.Kicked = true
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+// ****************** TERMINAL UI **************************
+// A full-screen view of the game, for when we're talking to a terminal:
+//
+//	 bob  |  room main, round 3                            <- title
+//	 Range             | Connected as bob!  Type /help...
+//	   2049 - 4095     | Joined room main
+//	                   | alice joined the room
+//	 Your guesses      | Too high!                       <- messages:
+//	   4096  too high  | ...                                everything the
+//	   2048  too low   |                                    plain client
+//	                   |                                    would print
+//	 Players           |
+//	 > alice           |
+//	   bob (you)       |
+//	 It's alice's turn                                     <- status
+//	 > 3072                                                <- input
+//
+// The input line belongs to readline (like the server's admin REPL),
+// and the terminal's scrolling region is just that line, so pressing
+// Enter doesn't scroll the rest of the screen.  Everything else is
+// redrawn whenever something changes.  Keyboard input still goes to the
+// main loop over keyboardChan, and messages from the server still come
+// in over msgChan; the main loop just tells the UI about them.
+
+// The UI, or nil if we're not using it (everything that uses it checks,
+// like with the server's journal)
+var ui *TUI
+
+// Where the plain client's messages go:  the terminal, or the UI's
+// message pane
+var out io.Writer = os.Stdout
+
+const (
+	sidebarWidth = 24
+	maxMessages  = 500 // Lines of messages we remember
+	minWidth     = 50  // Smaller than this, we only show messages
+	minHeight    = 12
+)
+
+type TUI struct {
+	lock sync.Mutex
+	rl   *readline.Instance
+	term io.Writer // Writes through readline, so it puts the input line back afterwards
+
+	width, height int
+
+	// What we know about the game.  We keep our own copy of the parts
+	// of the Session we show, since we redraw from other goroutines
+	// (whenever something is logged).
+	name     string
+	room     string
+	round    uint32
+	watching string
+	turn     string // Whose turn it is (if the room takes turns)
+	status   string // How the last round went
+	problem  string // Last error the server sent (until things move on)
+
+	settings game.Settings
+	low      int32   // Lowest number the target could still be
+	high     int32   // Highest
+	pending  []int32 // Guesses we're waiting to hear about, oldest first
+	history  []guessResult
+	players  []string // Players we know are in the room (see notePlayer)
+
+	messages []string
+	partial  string // Message text without a newline yet
+}
+
+// One of our guesses this round, and what the server said
+type guessResult struct {
+	Number int32
+	Result string
+}
+
+// Take over the terminal.  Returns an error if it isn't one.
+func StartTUI(session *Session, keyboardChan chan string) (*TUI, error) {
+	if !readline.DefaultIsTerminal() {
+		return nil, fmt.Errorf("not a terminal")
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:            "> ",
+		HistoryFile:       "/tmp/readline-guessing-game-client.tmp",
+		InterruptPrompt:   "^C",
+		HistorySearchFold: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TUI{rl: rl, term: rl.Stdout(), name: session.Name}
+	t.resize()
+
+	out = &messageWriter{t}
+	log.SetOutput(out)
+	readline.DefaultOnWidthChanged(func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.resize()
+	})
+
+	// Blocking operation:  read from keyboard (through readline)
+	go func() {
+		for {
+			line, err := rl.Readline()
+			if err == readline.ErrInterrupt || err == io.EOF {
+				t.Close()
+				os.Exit(0)
+			}
+			// Wait for a line of input, send to main loop
+			keyboardChan <- line
+		}
+	}()
+
+	return t, nil
+}
+
+// Give the terminal back
+func (t *TUI) Close() {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.rl == nil {
+		return
+	}
+	// Whole screen scrolls again, and back to what was there before
+	fmt.Fprint(os.Stdout, "\x1b[r\x1b[?1049l")
+	t.rl.Close()
+	t.rl = nil
+
+	out = os.Stdout
+	log.SetOutput(os.Stderr)
+}
+
+// Note a guess we just sent, so we can match it with the response
+func (t *TUI) Guessed(n int32) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.pending = append(t.pending, n)
+}
+
+// Update what we show for a message from the server (after the
+// session has seen it)
+func (t *TUI) Update(m protocol.Message, session *Session) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch msg := m.(type) {
+	case *protocol.GuessMessage:
+		t.problem = ""
+		if msg.MessageType == protocol.MessageTypeResponse {
+			t.answered(game.JournalResult(msg.Number))
+		} else if msg.MessageType == protocol.MessageTypeNewGame {
+			t.newRound()
+		}
+	case *protocol.RoomJoinedMessage:
+		t.settings = game.SettingsFromWire(msg.Settings)
+		t.players = append([]string{}, msg.Players...)
+		if len(t.players) == 0 {
+			// A server older than version 5 doesn't say who's here
+			t.players = []string{session.Name}
+		}
+		t.turn = ""
+		t.status = ""
+		t.newRound()
+	case *protocol.RulesMessage:
+		if msg.Room == session.Room {
+			t.settings = game.SettingsFromWire(msg.Settings)
+			t.newRound()
+		}
+	case *protocol.RoomLeftMessage, *protocol.SpectateMessage:
+		t.players = nil
+		t.turn = ""
+		t.status = ""
+		t.newRound()
+	case *protocol.PlayerEventMessage:
+		if msg.Event == protocol.PlayerEventJoined {
+			t.notePlayer(msg.Name)
+		} else {
+			t.removePlayer(msg.Name)
+		}
+	case *protocol.TurnMessage:
+		t.notePlayer(msg.Name)
+		t.turn = msg.Name
+		t.problem = ""
+	case *protocol.RoundOverMessage:
+		for _, p := range msg.Players {
+			t.notePlayer(p.Name)
+		}
+		if msg.Winner != "" {
+			t.status = fmt.Sprintf("%s won round %d (the number was %d)", msg.Winner, msg.Round, msg.Target)
+		} else {
+			t.status = fmt.Sprintf("Nobody won round %d (the number was %d)", msg.Round, msg.Target)
+		}
+	case *protocol.ErrorMessage:
+		// The server answers bad guesses with an error instead
+		if guessErrorCodes[msg.Code] {
+			t.answered("error")
+		}
+		t.problem = msg.Text
+	case *protocol.ShutdownMessage:
+		t.problem = "The server is going away:  " + msg.Reason
+	}
+
+	t.name = session.Name
+	t.room = session.Room
+	t.round = session.Round
+	t.watching = session.Watching
+	t.redraw()
+}
+
+// A response to our oldest guess
+func (t *TUI) answered(result string) {
+	if len(t.pending) == 0 {
+		return
+	}
+	n := t.pending[0]
+	t.pending = t.pending[1:]
+	if result == "too_late" {
+		// It was for a round we're not showing any more
+		return
+	}
+	t.history = append(t.history, guessResult{Number: n, Result: result})
+
+	// Narrow down where the target can be
+	switch result {
+	case "too_high":
+		if n-1 < t.high {
+			t.high = n - 1
+		}
+	case "too_low":
+		if n+1 > t.low {
+			t.low = n + 1
+		}
+	case "correct":
+		t.low, t.high = n, n
+	}
+}
+
+// Start over:  anything is possible again.  Guesses we haven't heard
+// about yet are still pending, since the server will still answer them
+// (probably with too_late).
+func (t *TUI) newRound() {
+	t.low = t.settings.MinNumber
+	t.high = t.settings.MaxNumber - 1
+	t.history = nil
+}
+
+// Add someone to the player list, if they're not on it.  The list
+// starts out as whoever the server said was in the room when we joined,
+// and we keep it up to date from there.
+func (t *TUI) notePlayer(name string) {
+	for _, p := range t.players {
+		if p == name {
+			return
+		}
+	}
+	t.players = append(t.players, name)
+}
+
+func (t *TUI) removePlayer(name string) {
+	for i, p := range t.players {
+		if p == name {
+			t.players = append(t.players[:i], t.players[i+1:]...)
+			return
+		}
+	}
+}
+
+// Find out how big the terminal is, and set it up for that.  Should
+// only be called when lock is held.
+func (t *TUI) resize() {
+	width, height, err := readline.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	t.width, t.height = width, height
+
+	// Alternate screen, scroll only the bottom line, and put the
+	// cursor there for readline
+	fmt.Fprintf(t.term, "\x1b[?1049h\x1b[2J\x1b[%d;%dr\x1b[%d;1H", height, height, height)
+	t.redraw()
+}
+
+// Draw everything but the input line.  Should only be called when lock
+// is held.
+func (t *TUI) redraw() {
+	if t.rl == nil {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b7") // Save the cursor (it's on the input line)
+
+	// Title
+	title := t.name
+	switch {
+	case t.watching == "*":
+		title += "  |  watching every room"
+	case t.watching != "":
+		title += "  |  watching room " + t.watching
+	case t.room != "":
+		title += fmt.Sprintf("  |  room %s, round %d", t.room, t.round)
+	default:
+		title += "  |  in the lobby"
+	}
+	t.drawLine(&b, 1, "\x1b[7m", " "+title)
+
+	// Sidebar and messages
+	rows := t.height - 3
+	messages := t.messageLines(rows)
+	if t.width >= minWidth && t.height >= minHeight {
+		sidebar := t.sidebarLines(rows)
+		for i := 0; i < rows; i++ {
+			t.drawLine(&b, i+2, "", " "+fit(sidebar[i], sidebarWidth-1)+"| "+messages[i])
+		}
+	} else {
+		for i := 0; i < rows; i++ {
+			t.drawLine(&b, i+2, "", messages[i])
+		}
+	}
+
+	// Status
+	var parts []string
+	if t.turn == t.name && t.turn != "" {
+		parts = append(parts, "Your turn!")
+	} else if t.turn != "" {
+		parts = append(parts, "It's "+t.turn+"'s turn")
+	}
+	if t.problem != "" {
+		parts = append(parts, t.problem)
+	} else if t.status != "" {
+		parts = append(parts, t.status)
+	}
+	status := strings.Join(parts, "  |  ")
+	if status == "" {
+		status = "Type a number to guess, or /help for commands"
+	}
+	t.drawLine(&b, t.height-1, "\x1b[7m", " "+status)
+
+	b.WriteString("\x1b8") // Back to the input line
+	t.term.Write([]byte(b.String()))
+}
+
+// Draw one line of the screen, padded to the full width
+func (t *TUI) drawLine(b *strings.Builder, row int, style string, text string) {
+	fmt.Fprintf(b, "\x1b[%d;1H%s%s\x1b[0m", row, style, fit(text, t.width))
+}
+
+// The sidebar, exactly rows lines long
+func (t *TUI) sidebarLines(rows int) []string {
+	var top, bottom []string
+
+	if t.room != "" {
+		top = append(top, "Range")
+		if t.low > t.high {
+			top = append(top, "  ???")
+		} else if t.low == t.high {
+			top = append(top, fmt.Sprintf("  %d", t.low))
+		} else {
+			top = append(top, fmt.Sprintf("  %d - %d", t.low, t.high))
+		}
+		top = append(top, "", "Your guesses")
+	}
+
+	if len(t.players) > 0 {
+		bottom = append(bottom, "", "Players")
+		for _, p := range t.players {
+			line := "  " + p
+			if p == t.turn {
+				line = "> " + p
+			}
+			if p == t.name {
+				line += " (you)"
+			}
+			bottom = append(bottom, line)
+		}
+	}
+	if len(top)+len(bottom) > rows {
+		bottom = bottom[:rows-len(top)]
+	}
+
+	// The guesses get whatever's left, newest at the bottom
+	var guesses []string
+	if t.room != "" {
+		for _, g := range t.history {
+			guesses = append(guesses, fmt.Sprintf("  %-6d %s", g.Number, strings.ReplaceAll(g.Result, "_", " ")))
+		}
+		for _, n := range t.pending {
+			guesses = append(guesses, fmt.Sprintf("  %-6d ...", n))
+		}
+	}
+	space := rows - len(top) - len(bottom)
+	if space < 0 {
+		space = 0
+	}
+	if len(guesses) > space {
+		guesses = guesses[len(guesses)-space:]
+	}
+
+	lines := append(top, guesses...)
+	for len(lines)+len(bottom) < rows {
+		lines = append(lines, "")
+	}
+	lines = append(lines, bottom...)
+	return lines[:rows]
+}
+
+// The last messages that fit in rows lines, exactly rows lines long
+// (blank at the top if there aren't enough yet)
+func (t *TUI) messageLines(rows int) []string {
+	width := t.width
+	if t.width >= minWidth && t.height >= minHeight {
+		width -= sidebarWidth + 2
+	}
+
+	// Wrap long messages, working back from the newest
+	var lines []string
+	for i := len(t.messages) - 1; i >= 0 && len(lines) < rows; i-- {
+		wrapped := wrap(t.messages[i], width)
+		for j := len(wrapped) - 1; j >= 0 && len(lines) < rows; j-- {
+			lines = append(lines, wrapped[j])
+		}
+	}
+	for len(lines) < rows {
+		lines = append(lines, "")
+	}
+
+	// Oldest first
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// Add text to the message pane
+func (t *TUI) write(text string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	text = t.partial + text
+	lines := strings.Split(text, "\n")
+	t.partial = lines[len(lines)-1]
+
+	for _, line := range lines[:len(lines)-1] {
+		t.messages = append(t.messages, strings.ReplaceAll(line, "\t", "    "))
+	}
+	if len(t.messages) > maxMessages {
+		t.messages = t.messages[len(t.messages)-maxMessages:]
+	}
+	t.redraw()
+}
+
+// Lets everything the plain client prints (and logs) go to the message
+// pane instead
+type messageWriter struct {
+	t *TUI
+}
+
+func (w *messageWriter) Write(b []byte) (int, error) {
+	w.t.write(string(b))
+	return len(b), nil
+}
+
+// Pad or cut text to exactly width characters
+func fit(text string, width int) string {
+	n := utf8.RuneCountInString(text)
+	if n > width {
+		return string([]rune(text)[:width])
+	}
+	return text + strings.Repeat(" ", width-n)
+}
+
+// Split text into lines no wider than width
+func wrap(text string, width int) []string {
+	runes := []rune(text)
+	if width <= 0 || len(runes) <= width {
+		return []string{text}
+	}
+
+	var lines []string
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(lines, string(runes))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A stand-in for a bad network, for testing the UDP transport (see
+// pkg/rudp):  relays datagrams between clients and a server, but
+// drops, duplicates, delays and reorders some of them on the way.
+//
+// Point clients at the relay's port instead of the server's, eg.:
+//
+//	./server -udp 9000
+//	go run ./cmd/lossyrelay -drop 0.2 9001 localhost 9000
+//	./client -udp localhost 9001
+//
+// Each client gets its own socket toward the server, so the server
+// still sees a different address for each client.
+func main() {
+	dropRate := flag.Float64("drop", 0.1, "fraction of datagrams to drop")
+	dupRate := flag.Float64("dup", 0.05, "fraction of datagrams to send twice")
+	reorderRate := flag.Float64("reorder", 0.1, "fraction of datagrams to hold back, so later ones pass them")
+	delay := flag.Duration("delay", 0, "delay every datagram by this long")
+	jitter := flag.Duration("jitter", 50*time.Millisecond, "hold reordered datagrams back for up to this long")
+	seed := flag.Int64("seed", 0, "random seed (0 to pick one)")
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		log.Fatalf("Usage:  %s [options] <listen port> <server address> <server port>", os.Args[0])
+	}
+	if *jitter <= 0 {
+		log.Fatalln("The jitter must be positive")
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("Random seed %d\n", *seed)
+
+	listenAddr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf(":%s", flag.Arg(0)))
+	if err != nil {
+		log.Fatalln("Error translating address:  ", err)
+	}
+	serverAddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(flag.Arg(1), flag.Arg(2)))
+	if err != nil {
+		log.Fatalln("Error translating address:  ", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", listenAddr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	r := &Relay{
+		DropRate:    *dropRate,
+		DupRate:     *dupRate,
+		ReorderRate: *reorderRate,
+		Delay:       *delay,
+		Jitter:      *jitter,
+		conn:        conn,
+		server:      serverAddr,
+		upstreams:   make(map[string]*net.UDPConn),
+		rand:        rand.New(rand.NewSource(*seed)),
+	}
+	go r.reportLoop()
+
+	log.Printf("Relaying %s -> %s\n", conn.LocalAddr(), serverAddr)
+	r.Run()
+}
+
+type Relay struct {
+	DropRate, DupRate, ReorderRate float64
+	Delay, Jitter                  time.Duration
+
+	conn   *net.UDPConn // Where clients send to us
+	server *net.UDPAddr
+
+	lock      sync.Mutex
+	upstreams map[string]*net.UDPConn // Our socket toward the server, by client address
+	rand      *rand.Rand              // Protected by lock, since rand.Rand isn't safe to share
+
+	// Counters (atomic)
+	relayed, dropped, duplicated, reordered int64
+}
+
+// Read from clients forever
+func (r *Relay) Run() {
+	buf := make([]byte, 65536)
+
+	for {
+		n, clientAddr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Fatalln("read:  ", err)
+		}
+
+		upstream, err := r.upstream(clientAddr)
+		if err != nil {
+			log.Printf("%s:  %v\n", clientAddr, err)
+			continue
+		}
+
+		r.forward(buf[:n], func(data []byte) {
+			upstream.Write(data)
+		})
+	}
+}
+
+// Get (or make) the socket we use to talk to the server for a client
+func (r *Relay) upstream(clientAddr *net.UDPAddr) (*net.UDPConn, error) {
+	key := clientAddr.String()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if up, ok := r.upstreams[key]; ok {
+		return up, nil
+	}
+
+	up, err := net.DialUDP("udp4", nil, r.server)
+	if err != nil {
+		return nil, err
+	}
+	r.upstreams[key] = up
+	log.Printf("New client %s (relaying from %s)\n", clientAddr, up.LocalAddr())
+
+	// Relay the server's replies back to the client
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := up.Read(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				// Probably nobody listening on the server's port (yet),
+				// which is the same as a lost datagram
+				continue
+			}
+			r.forward(buf[:n], func(data []byte) {
+				r.conn.WriteToUDP(data, clientAddr)
+			})
+		}
+	}()
+
+	return up, nil
+}
+
+// Pass a datagram on (using send), unless we decide to lose it
+func (r *Relay) forward(datagram []byte, send func([]byte)) {
+	r.lock.Lock()
+	drop := r.rand.Float64() < r.DropRate
+	copies := 1
+	if r.rand.Float64() < r.DupRate {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = r.Delay
+		if r.rand.Float64() < r.ReorderRate {
+			delays[i] += time.Duration(1 + r.rand.Int63n(int64(r.Jitter)))
+		}
+	}
+	r.lock.Unlock()
+
+	atomic.AddInt64(&r.relayed, 1)
+	if drop {
+		atomic.AddInt64(&r.dropped, 1)
+		return
+	}
+	if copies > 1 {
+		atomic.AddInt64(&r.duplicated, 1)
+	}
+
+	// The caller reuses its buffer
+	data := append([]byte{}, datagram...)
+	for _, d := range delays {
+		if d == 0 {
+			send(data)
+			continue
+		}
+		if d > r.Delay {
+			atomic.AddInt64(&r.reordered, 1)
+		}
+		time.AfterFunc(d, func() { send(data) })
+	}
+}
+
+// Every so often, say what we've done to the traffic
+func (r *Relay) reportLoop() {
+	last := int64(0)
+	for range time.Tick(10 * time.Second) {
+		relayed := atomic.LoadInt64(&r.relayed)
+		if relayed == last {
+			continue
+		}
+		last = relayed
+		log.Printf("%d datagram(s):  %d dropped, %d duplicated, %d held back\n",
+			relayed, atomic.LoadInt64(&r.dropped), atomic.LoadInt64(&r.duplicated),
+			atomic.LoadInt64(&r.reordered))
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"golang-sockets/pkg/journal"
+)
+
+// Play a journal (see pkg/journal, and the server's -journal option)
+// back against fresh rooms, and check that everything comes out the
+// same:  the targets (from each room's recorded seed), every response,
+// whose turn it was, and how every round ended.
+//
+//	./replay journal.jsonl
+//	./replay -v -room main journal.jsonl
+//
+// Things that happened because time ran out (turns and rounds) are
+// applied when the journal says they happened, so the replay's rooms
+// don't keep time themselves.  Exits with status 1 if anything didn't
+// match.
+func main() {
+	verbose := flag.Bool("v", false, "print every event as it's replayed")
+	onlyRoom := flag.String("room", "", "only replay this room")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage:  %s [options] <journal file>", os.Args[0])
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	// The rooms log what they're doing, like they do on the server, but
+	// we have our own way of saying it (-v)
+	log.SetOutput(io.Discard)
+
+	r := NewReplayer(*verbose, *onlyRoom)
+	err = r.ReplayAll(journal.NewReader(f))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading journal:  ", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Replayed %d event(s), %d round(s):  ", r.events, r.rounds)
+	if r.mismatches > 0 {
+		fmt.Printf("%d mismatch(es)\n", r.mismatches)
+		os.Exit(1)
+	}
+	fmt.Println("everything matched")
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+)
+
+type Replayer struct {
+	Verbose bool
+	Room    string // Only replay this room (empty for every room)
+
+	rooms map[string]*replayRoom
+	line  int // Journal line we're on
+
+	events, rounds, mismatches int
+}
+
+func NewReplayer(verbose bool, room string) *Replayer {
+	return &Replayer{
+		Verbose: verbose,
+		Room:    room,
+		rooms:   make(map[string]*replayRoom),
+	}
+}
+
+// A room being replayed
+type replayRoom struct {
+	g       *game.GameInfo
+	clients map[int]*game.ClientInfo // Players in the room, by connection ID
+
+	// What the room told each player about their last guess
+	responses map[int]string
+}
+
+// Replay a whole journal.  Mismatches are reported as they're found
+// (and counted); the only error is not being able to read the journal.
+func (r *Replayer) ReplayAll(reader *journal.Reader) error {
+	for {
+		ev, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		r.line = reader.Line()
+		r.Replay(ev)
+	}
+}
+
+func (r *Replayer) Replay(ev journal.Event) {
+	if ev.Room != "" && r.Room != "" && ev.Room != r.Room {
+		return
+	}
+	r.events++
+	if r.Verbose {
+		fmt.Printf("%5d  %s\n", r.line, describe(ev))
+	}
+
+	switch ev.Type {
+	case journal.Start:
+		// Nothing survives a restart
+		for _, room := range r.rooms {
+			room.g.Close()
+		}
+		r.rooms = make(map[string]*replayRoom)
+
+	case journal.Room:
+		r.openRoom(ev)
+
+	case journal.Close:
+		if room := r.room(ev); room != nil {
+			room.g.Close()
+			delete(r.rooms, ev.Room)
+		}
+
+	case journal.Join:
+		room := r.room(ev)
+		if room == nil {
+			return
+		}
+		ci := newReplayClient(ev.Client, ev.Player)
+		room.clients[ev.Client] = ci
+		room.g.AddClient(ci)
+
+	case journal.Leave:
+		room, ci := r.client(ev)
+		if ci == nil {
+			return
+		}
+		room.g.RemoveClient(ci)
+		delete(room.clients, ev.Client)
+
+	case journal.Guess:
+		room, ci := r.client(ev)
+		if ci == nil {
+			return
+		}
+		r.checkRound(room, ev)
+		result, err := room.g.DoGuess(ci, int32Value(ev.Number), ev.GuessRound)
+		if err != nil {
+			room.responses[ev.Client] = "error:  " + err.Error()
+		} else {
+			room.responses[ev.Client] = game.JournalResult(result)
+		}
+
+	case journal.Response:
+		room, ci := r.client(ev)
+		if ci == nil {
+			return
+		}
+		want := ev.Result
+		if ev.Error != "" {
+			want = "error:  " + ev.Error
+		}
+		if got := room.responses[ev.Client]; got != want {
+			r.mismatch("%s's guess got %q, but the journal says %q", ev.Player, got, want)
+		}
+		delete(room.responses, ev.Client)
+
+	case journal.Skip:
+		room := r.room(ev)
+		if room == nil {
+			return
+		}
+		r.checkRound(room, ev)
+		skipped, ok := room.g.SkipTurn()
+		if !ok {
+			r.mismatch("nobody had the turn, but the journal says %s was skipped", ev.Player)
+		} else if skipped != ev.Player {
+			r.mismatch("it was %s's turn, but the journal says %s was skipped", skipped, ev.Player)
+		}
+
+	case journal.Reset:
+		r.reset(ev)
+
+	case journal.SetTarget:
+		room := r.room(ev)
+		if room == nil {
+			return
+		}
+		err := room.g.SetTarget(int32Value(ev.Number))
+		if err != nil {
+			r.mismatch("could not set target:  %v", err)
+		}
+
+	case journal.SetRules:
+		room := r.room(ev)
+		if room == nil || ev.Settings == nil {
+			return
+		}
+		err := room.g.SetSettings(untimed(game.SettingsFromWire(*ev.Settings)))
+		if err != nil {
+			r.mismatch("could not change rules:  %v", err)
+		}
+	}
+}
+
+func (r *Replayer) openRoom(ev journal.Event) {
+	if ev.Seed == nil || ev.Settings == nil {
+		r.mismatch("room %s has no seed or settings, so it can't be replayed", ev.Room)
+		return
+	}
+
+	settings := game.SettingsFromWire(*ev.Settings)
+	g := game.InitializeGameWithSeed(ev.Room, untimed(settings), *ev.Seed)
+	r.rooms[ev.Room] = &replayRoom{
+		g:         g,
+		clients:   make(map[int]*game.ClientInfo),
+		responses: make(map[int]string),
+	}
+
+	if target := g.Target(); target != int32Value(ev.Number) {
+		r.mismatch("room %s started with target %d, but the journal says %d",
+			ev.Room, target, int32Value(ev.Number))
+	}
+}
+
+// The end of a round.  If a guess ended it, the replayed room should
+// already have moved on; if time ran out (or an admin reset it), we
+// end it now.
+func (r *Replayer) reset(ev journal.Event) {
+	room := r.room(ev)
+	if room == nil {
+		return
+	}
+	r.rounds++
+
+	reason, ok := journal.ParseResetReason(ev.Result)
+	if !ok {
+		r.mismatch("unknown reason %q for the end of the round", ev.Result)
+		return
+	}
+
+	if room.g.Round() == ev.Round {
+		if reason == protocol.RoundOverWon || reason == protocol.RoundOverNoGuesses {
+			r.mismatch("round %d ended (%s), but not in the replay", ev.Round, ev.Result)
+		}
+		room.g.EndRound(nil, reason)
+	}
+	if round := room.g.Round(); round != ev.Round+1 {
+		r.mismatch("replay is on round %d after round %d ended", round, ev.Round)
+		return
+	}
+
+	if target := room.g.Target(); target != int32Value(ev.NextTarget) {
+		r.mismatch("round %d has target %d, but the journal says %d",
+			ev.Round+1, target, int32Value(ev.NextTarget))
+	}
+}
+
+// Check that the replayed room is on the same round as the journal
+func (r *Replayer) checkRound(room *replayRoom, ev journal.Event) {
+	if round := room.g.Round(); round != ev.Round {
+		r.mismatch("replay is on round %d, but the journal is on round %d", round, ev.Round)
+	}
+}
+
+// The room an event happened in (nil if we don't know it)
+func (r *Replayer) room(ev journal.Event) *replayRoom {
+	room, ok := r.rooms[ev.Room]
+	if !ok {
+		r.mismatch("no room named %s", ev.Room)
+		return nil
+	}
+	return room
+}
+
+// The room and player an event is about (nil if we don't know them)
+func (r *Replayer) client(ev journal.Event) (*replayRoom, *game.ClientInfo) {
+	room := r.room(ev)
+	if room == nil {
+		return nil, nil
+	}
+	ci, ok := room.clients[ev.Client]
+	if !ok {
+		r.mismatch("%s (client %d) isn't in room %s", ev.Player, ev.Client, ev.Room)
+		return room, nil
+	}
+	return room, ci
+}
+
+func (r *Replayer) mismatch(format string, args ...interface{}) {
+	r.mismatches++
+	fmt.Printf("%5d  MISMATCH:  %s\n", r.line, fmt.Sprintf(format, args...))
+}
+
+// A stand-in for a connected player (with no connection).  Whatever
+// the room sends it just piles up in its outbox (which drops the oldest
+// messages when it's full), since nobody's listening.
+func newReplayClient(id int, name string) *game.ClientInfo {
+	return &game.ClientInfo{
+		Id:              id,
+		Outbox:          game.NewOutbox(game.DefaultQueueSize, game.DropOldest),
+		ServerCloseChan: make(chan bool, 1),
+		Name:            name,
+	}
+}
+
+// Timers are replaced by the events in the journal
+func untimed(s game.Settings) game.Settings {
+	s.TimeLimit = 0
+	s.TurnTimeout = 0
+	return s
+}
+
+func int32Value(n *int32) int32 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// One line describing an event, for -v
+func describe(ev journal.Event) string {
+	str := ev.Time.Format("15:04:05.000") + "  "
+	if ev.Room != "" {
+		str += fmt.Sprintf("[%s, round %d]  ", ev.Room, ev.Round)
+	}
+
+	switch ev.Type {
+	case journal.Start:
+		return str + "server started"
+	case journal.Connect:
+		return str + fmt.Sprintf("%s connected from %s (client %d)", ev.Player, ev.Address, ev.Client)
+	case journal.Disconnect:
+		return str + fmt.Sprintf("%s disconnected (client %d)", ev.Player, ev.Client)
+	case journal.Room:
+		return str + fmt.Sprintf("room opened, target %d", int32Value(ev.Number))
+	case journal.Close:
+		return str + "room closed"
+	case journal.Join:
+		return str + fmt.Sprintf("%s joined", ev.Player)
+	case journal.Leave:
+		return str + fmt.Sprintf("%s left", ev.Player)
+	case journal.Guess:
+		return str + fmt.Sprintf("%s guessed %d", ev.Player, int32Value(ev.Number))
+	case journal.Response:
+		if ev.Error != "" {
+			return str + fmt.Sprintf("  -> %s:  error:  %s", ev.Player, ev.Error)
+		}
+		return str + fmt.Sprintf("  -> %s:  %s", ev.Player, ev.Result)
+	case journal.Skip:
+		return str + fmt.Sprintf("%s ran out of time", ev.Player)
+	case journal.Reset:
+		winner := ev.Winner
+		if winner == "" {
+			winner = "nobody"
+		}
+		return str + fmt.Sprintf("round over (%s):  %s won, target %d, %d guess(es); next target %d",
+			ev.Result, winner, int32Value(ev.Number), ev.Guesses, int32Value(ev.NextTarget))
+	case journal.SetTarget:
+		return str + fmt.Sprintf("target set to %d", int32Value(ev.Number))
+	case journal.SetRules:
+		return str + fmt.Sprintf("rules changed to %v", game.SettingsFromWire(*ev.Settings))
+	}
+	return str + ev.Type
+}
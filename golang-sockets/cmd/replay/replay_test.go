@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/journal"
+)
+
+// A stand-in for the server's rooms:  a room with a fixed seed, whose
+// events go in j the way they would on the server
+func journaledRoom(j *journal.Journal, name string, settings game.Settings, seed int64) *game.GameInfo {
+	g := game.InitializeGameWithSeed(name, settings, seed)
+	g.Journal = j
+
+	wire := settings.Wire()
+	j.Record(journal.Event{
+		Type:     journal.Room,
+		Room:     name,
+		Round:    g.Round(),
+		Number:   journal.Int32(g.Target()),
+		Seed:     journal.Int64(seed),
+		Settings: &wire,
+	})
+	return g
+}
+
+// Play a room through everything the journal records:  turns (and a
+// skipped one), errors, new rules, a reset, a guess that's too late,
+// an admin's target, and a win
+func playRoom(t *testing.T, j *journal.Journal) {
+	j.Record(journal.Event{Type: journal.Start})
+	g := journaledRoom(j, "main", game.Settings{MinNumber: 0, MaxNumber: 100, TurnBased: true}, 42)
+	defer g.Close()
+
+	al := newReplayClient(1, "al")
+	bob := newReplayClient(2, "bob")
+	g.AddClient(al)
+	g.AddClient(bob)
+
+	wrong := g.Target() + 1
+	if wrong >= 100 {
+		wrong = g.Target() - 1
+	}
+	if _, err := g.DoGuess(al, wrong, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.DoGuess(al, wrong, 0); err == nil {
+		t.Fatalf("al guessed when it was bob's turn")
+	}
+	if skipped, ok := g.SkipTurn(); !ok || skipped != "bob" {
+		t.Fatalf("skipped %q, want bob", skipped)
+	}
+
+	if err := g.SetSettings(game.Settings{MinNumber: 0, MaxNumber: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.DoGuess(al, 1000, 0); err == nil {
+		t.Fatalf("guess out of range:  no error")
+	}
+	g.ResetGame(nil)
+
+	if result, _ := g.DoGuess(bob, g.Target(), 1); result != game.GuessRoundOver {
+		t.Fatalf("a guess for round 1 got %d, want GuessRoundOver", result)
+	}
+	if err := g.SetTarget(7); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := g.DoGuess(bob, 3, 0); result != game.GuessTooLow {
+		t.Fatalf("guess 3 got %d, want GuessTooLow", result)
+	}
+	if result, _ := g.DoGuess(al, 7, 0); result != game.GuessCorrect {
+		t.Fatalf("guess 7 got %d, want GuessCorrect", result)
+	}
+
+	g.RemoveClient(bob)
+	if _, err := g.DoGuess(al, g.Target(), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Replay a journal, returning the Replayer (with its counts)
+func replayFile(t *testing.T, path string) *Replayer {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := NewReplayer(false, "")
+	if err := r.ReplayAll(journal.NewReader(f)); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := journal.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playRoom(t, j)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := replayFile(t, path)
+	if r.mismatches != 0 {
+		t.Errorf("%d mismatch(es) replaying a journal of the same room", r.mismatches)
+	}
+	if r.rounds != 3 {
+		t.Errorf("replayed %d round(s), want 3", r.rounds)
+	}
+
+	// With some other seed, the targets aren't the same, and the replay
+	// has to notice
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(`"seed":42`), []byte(`"seed":43`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatalf("no seed in the journal")
+	}
+	if err := os.WriteFile(path, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := replayFile(t, path); r.mismatches == 0 {
+		t.Errorf("replaying with the wrong seed didn't find any mismatches")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chzyer/readline"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+// ****************** ADMIN REPL **************************
+// Lets whoever is running the server steer the game without
+// restarting it.  Like the REPL in the channels demo, this uses
+// readline so we get line editing and history.
+
+const adminHelpText = `Commands:
+  clients                     List connected clients
+  rooms                       List rooms
+  kick <player>               Disconnect a player
+  reset [room]                Start a new round
+  target [room] [number]      Show (or set) the target number
+  rules [room]                Show a room's rules
+  range <room> <min> <max>    Change the number range (min to max-1)
+  guesses <room> <n>          Change the guesses per player per round (0 for no limit)
+  timelimit <room> <time>     Change the round time limit (0 for no limit)
+  turns <room> <on|off>       Make players take turns (or not)
+  turntime <room> <time>      Change the time limit for each turn (0 for no limit)
+  stats                       Show totals for the whole server
+  quit                        Shut down the server
+  help                        Show this message
+
+Changing the rules starts a new round in that room.`
+
+// When the server started, for the stats command
+var startTime = time.Now()
+
+// Run the admin REPL until the admin asks to quit (or hits Ctrl+C), then
+// call quit.  If stdin closes, the REPL stops, but the server keeps
+// running.
+func RunAdminRepl(quit func()) {
+	repl, err := readline.NewEx(&readline.Config{
+		Prompt:            "admin> ",
+		HistoryFile:       "/tmp/readline-guessing-game-admin.tmp",
+		InterruptPrompt:   "^C",
+		HistorySearchFold: true,
+	})
+	if err != nil {
+		log.Println("Could not start admin REPL:  ", err)
+		return
+	}
+	defer repl.Close()
+
+	// Send log messages through readline, so they don't clobber the prompt
+	log.SetOutput(repl.Stderr())
+
+	for {
+		line, err := repl.Readline()
+		if err == readline.ErrInterrupt {
+			quit()
+			return
+		} else if err == io.EOF {
+			log.SetOutput(repl.Config.Stderr)
+			log.Println("Admin REPL closed, press Ctrl+C to stop the server")
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "quit" || fields[0] == "exit" {
+			quit()
+			return
+		}
+
+		err = doAdminCommand(repl.Stdout(), fields)
+		if err != nil {
+			fmt.Fprintln(repl.Stdout(), "Error:  ", err)
+		}
+	}
+}
+
+func doAdminCommand(out io.Writer, fields []string) error {
+	switch fields[0] {
+	case "clients":
+		listClients(out)
+
+	case "rooms":
+		for _, room := range Lobby.ListRooms() {
+			fmt.Fprintf(out, "%-16s  %d player(s), %v\n",
+				room.Name, room.NumPlayers, room.Settings)
+		}
+
+	case "kick":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage:  kick <player>")
+		}
+		ci := Lobby.FindClient(fields[1])
+		if ci == nil {
+			return fmt.Errorf("no player named %s", fields[1])
+		}
+		log.Printf("Admin kicked %s\n", ci)
+
+		// Tell the client why it's going away
+		ci.Send(&protocol.ErrorMessage{
+			Code: protocol.ErrorCodeKicked,
+			Text: "you were kicked from the server",
+		})
+		Lobby.Kick(ci)
+
+	case "reset":
+		g, err := adminRoom(fields, 1)
+		if err != nil {
+			return err
+		}
+		log.Printf("Admin reset room %s\n", g.Name)
+		g.ResetGame(nil)
+
+	case "target":
+		g, err := adminRoom(fields, 1)
+		if err != nil {
+			return err
+		}
+		if len(fields) < 3 {
+			fmt.Fprintf(out, "Room %s:  target is %d\n", g.Name, g.Target())
+			return nil
+		}
+		n, err := strconv.ParseInt(fields[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		err = g.SetTarget(int32(n))
+		if err != nil {
+			return err
+		}
+		log.Printf("Admin set target in room %s to %d\n", g.Name, n)
+
+	case "rules":
+		g, err := adminRoom(fields, 1)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Room %s:  %v\n", g.Name, g.CurrentSettings())
+
+	case "range", "guesses", "timelimit", "turns", "turntime":
+		return changeRules(fields)
+
+	case "stats":
+		showStats(out)
+
+	case "help":
+		fmt.Fprintln(out, adminHelpText)
+
+	default:
+		return fmt.Errorf("unknown command %s, try help", fields[0])
+	}
+
+	return nil
+}
+
+// Change one of a room's rules, and start a new round with them
+func changeRules(fields []string) error {
+	usage := map[string]string{
+		"range":     "range <room> <min> <max>",
+		"guesses":   "guesses <room> <n>",
+		"timelimit": "timelimit <room> <time>",
+		"turns":     "turns <room> <on|off>",
+		"turntime":  "turntime <room> <time>",
+	}
+	wantFields := 3
+	if fields[0] == "range" {
+		wantFields = 4
+	}
+	if len(fields) != wantFields {
+		return fmt.Errorf("usage:  %s", usage[fields[0]])
+	}
+
+	g, err := adminRoom(fields, 1)
+	if err != nil {
+		return err
+	}
+
+	settings := g.CurrentSettings()
+	switch fields[0] {
+	case "range":
+		min, err := strconv.ParseInt(fields[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		max, err := strconv.ParseInt(fields[3], 10, 32)
+		if err != nil {
+			return err
+		}
+		settings.MinNumber, settings.MaxNumber = int32(min), int32(max)
+
+	case "guesses":
+		settings.MaxGuesses, err = strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+
+	case "timelimit":
+		settings.TimeLimit, err = time.ParseDuration(fields[2])
+		if err != nil {
+			return err
+		}
+
+	case "turns":
+		switch fields[2] {
+		case "on":
+			settings.TurnBased = true
+		case "off":
+			settings.TurnBased = false
+		default:
+			return fmt.Errorf("usage:  %s", usage[fields[0]])
+		}
+
+	case "turntime":
+		settings.TurnTimeout, err = time.ParseDuration(fields[2])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = g.SetSettings(settings)
+	if err != nil {
+		return err
+	}
+	log.Printf("Admin changed rules in room %s:  %v\n", g.Name, settings)
+	g.ResetGame(nil)
+
+	return nil
+}
+
+// Get the room named in fields[idx], or the default room if there
+// aren't that many fields
+func adminRoom(fields []string, idx int) (*game.GameInfo, error) {
+	name := game.DefaultRoomName
+	if len(fields) > idx {
+		name = fields[idx]
+	}
+
+	g := Lobby.Room(name)
+	if g == nil {
+		return nil, fmt.Errorf("no room named %s", name)
+	}
+	return g, nil
+}
+
+func listClients(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tName\tAddress\tRoom\tIdle")
+	for _, cr := range Lobby.ClientRooms() {
+		ci := cr.Client
+		room := cr.Room
+		if room == "" {
+			room = "(lobby)"
+			if watching, ok := Lobby.Spectators.Watching(ci); ok {
+				if watching == "" {
+					watching = "every room"
+				}
+				room = fmt.Sprintf("(watching %s)", watching)
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%v\n", ci.Id, cr.Name, ci.Conn.RemoteAddr(),
+			room, ci.IdleTime().Round(time.Second))
+	}
+	w.Flush()
+}
+
+func showStats(out io.Writer) {
+	rooms := Lobby.ListRooms()
+	rounds := atomic.LoadInt64(&Lobby.Totals.RoundsPlayed)
+	guesses := atomic.LoadInt64(&Lobby.Totals.Guesses)
+
+	fmt.Fprintf(out, "Uptime:             %v\n", time.Since(startTime).Round(time.Second))
+	fmt.Fprintf(out, "Connected clients:  %d\n", len(Lobby.ListClients()))
+	fmt.Fprintf(out, "Rooms:              %d\n", len(rooms))
+	fmt.Fprintf(out, "Rounds played:      %d\n", rounds)
+	fmt.Fprintf(out, "Guesses:            %d\n", guesses)
+
+	top := Lobby.Stats.Top(5)
+	if len(top) > 0 {
+		fmt.Fprintln(out, "Top players:")
+		for i, p := range top {
+			fmt.Fprintf(out, "  %d. %-16s  %d win(s), %.2f guesses per win\n",
+				i+1, p.Name, p.RoundsWon, p.AverageGuessesPerWin())
+		}
+	}
+}
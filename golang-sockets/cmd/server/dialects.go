@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+// ****************** DIALECTS **************************
+// Clients can speak the framed protocol (see pkg/protocol/framing.go),
+// the text protocol (see text.go), or one of the 5-byte dialects older
+// versions of the game spoke (see pkg/protocol/legacy.go).  On the main
+// port, we work out which from the first byte a client sends.  All the
+// legacy dialects look the same on the wire, though, so clients there
+// are taken to speak -legacy.  To have clients with different legacy
+// dialects at once, give each dialect its own port with -dialect (eg.
+// -dialect c=9001):  every client on that port is taken to speak it,
+// and nothing is detected.
+
+// Names for -dialect, besides the legacy ones
+const (
+	dialectFramed = "framed"
+	dialectText   = "text"
+)
+
+// Dialect of legacy clients on the main port
+var LegacyDialect *protocol.Dialect
+
+// Rules the server was started with (see -rules), which text clients'
+// CREATE rules are applied on top of
+var RoomDefaults = game.DefaultSettings()
+
+// A port where every client speaks the same dialect
+type dialectPort struct {
+	Dialect string
+	Port    string
+}
+
+// Every -dialect flag
+type dialectPorts []dialectPort
+
+func (p *dialectPorts) String() string {
+	var list []string
+	for _, dp := range *p {
+		list = append(list, dp.Dialect+"="+dp.Port)
+	}
+	return strings.Join(list, ",")
+}
+
+func (p *dialectPorts) Set(value string) error {
+	name, port, ok := strings.Cut(value, "=")
+	if !ok || port == "" {
+		return fmt.Errorf("expected <dialect>=<port>")
+	}
+	if err := checkDialect(name); err != nil {
+		return err
+	}
+
+	*p = append(*p, dialectPort{Dialect: name, Port: port})
+	return nil
+}
+
+func checkDialect(name string) error {
+	if name == dialectFramed || name == dialectText {
+		return nil
+	}
+	_, err := protocol.LookupDialect(name)
+	return err
+}
+
+// Figure out which protocol a new client speaks, from the first byte
+// it sends.  Framed clients send a Hello right away, but the others are
+// usually people, who might think for a while before their first
+// guess--so we only give up after timeout (if it's nonzero).
+func detectCodec(conn net.Conn, timeout time.Duration) (protocol.Codec, error) {
+	reader := bufio.NewReaderSize(conn, maxTextLine)
+
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := &protocol.BufferedConn{Conn: conn, Reader: reader}
+	switch {
+	case first[0] == protocol.MessageTypeGuess:
+		// Never a protocol version
+		return &protocol.LegacyCodec{Conn: buffered, Dialect: LegacyDialect}, nil
+	case looksLikeText(first[0]):
+		return &textCodec{conn: buffered, reader: reader, defaults: RoomDefaults}, nil
+	}
+	return &protocol.FrameCodec{Conn: buffered}, nil
+}
+
+// Codec for a client on a -dialect port
+func dialectCodec(conn net.Conn, name string) protocol.Codec {
+	switch name {
+	case dialectFramed:
+		return &protocol.FrameCodec{Conn: conn}
+	case dialectText:
+		reader := bufio.NewReaderSize(conn, maxTextLine)
+		return &textCodec{conn: &protocol.BufferedConn{Conn: conn, Reader: reader}, reader: reader, defaults: RoomDefaults}
+	}
+
+	d, _ := protocol.LookupDialect(name) // Already checked by dialectPorts.Set
+	return &protocol.LegacyCodec{Conn: conn, Dialect: d}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// What a client sent first, as the server sees it once detectCodec has
+// looked at it.  The client goes on writing in the background, and
+// hangs up when the test is over.
+func detect(t *testing.T, first []byte) (protocol.Codec, error) {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	go clientConn.Write(first)
+
+	return detectCodec(serverConn, time.Second)
+}
+
+// The first message that isn't the Hello the codec makes up for
+// clients that don't send one
+func firstMessage(t *testing.T, c protocol.Codec) protocol.Message {
+	for i := 0; i < 2; i++ {
+		msg, err := c.ReadMessage(time.Second)
+		if err != nil {
+			t.Fatalf("read:  %v", err)
+		}
+		if _, ok := msg.(*protocol.HelloMessage); !ok {
+			return msg
+		}
+	}
+	t.Fatalf("only got Hellos")
+	return nil
+}
+
+func TestDetectText(t *testing.T) {
+	c, err := detect(t, []byte("guess 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isText(c) {
+		t.Fatalf("got %T, want a textCodec", c)
+	}
+	if guess, ok := firstMessage(t, c).(*protocol.GuessMessage); !ok || guess.Number != 5 {
+		t.Errorf("got %#v, want a guess of 5", guess)
+	}
+}
+
+func TestDetectFramed(t *testing.T) {
+	frame, err := protocol.MarshalFrame(&protocol.HelloMessage{
+		Name:     "al",
+		Versions: protocol.SupportedVersions(),
+	}, protocol.ProtocolVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := detect(t, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isFramed(c) {
+		t.Fatalf("got %T, want a FrameCodec", c)
+	}
+	msg, err := c.ReadMessage(time.Second)
+	if hello, ok := msg.(*protocol.HelloMessage); err != nil || !ok || hello.Name != "al" {
+		t.Errorf("got %#v (%v), want al's Hello", msg, err)
+	}
+}
+
+// Every legacy dialect sends the same 5 bytes, so on the main port
+// they're all taken to speak -legacy
+func TestDetectLegacy(t *testing.T) {
+	defer func(d *protocol.Dialect) { LegacyDialect = d }(LegacyDialect)
+
+	for _, name := range protocol.DialectNames() {
+		d, err := protocol.LookupDialect(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		LegacyDialect = d
+
+		c, err := detect(t, []byte{protocol.MessageTypeGuess, 0, 0, 0x01, 0x2c})
+		if err != nil {
+			t.Fatalf("%s:  %v", name, err)
+		}
+		legacy, ok := c.(*protocol.LegacyCodec)
+		if !ok || legacy.Dialect != d {
+			t.Errorf("%s:  got %#v, want a LegacyCodec for the dialect", name, c)
+			continue
+		}
+		if guess, ok := firstMessage(t, c).(*protocol.GuessMessage); !ok || guess.Number != 300 {
+			t.Errorf("%s:  got %#v, want a guess of 300", name, guess)
+		}
+	}
+}
+
+// People might not type anything for a while, but not forever
+func TestDetectTimeout(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	_, err := detectCodec(serverConn, 20*time.Millisecond)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
+
+// On a -dialect port, nothing is detected:  every client gets the
+// port's codec
+func TestDialectCodec(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	if c := dialectCodec(serverConn, dialectFramed); !isFramed(c) {
+		t.Errorf("framed:  got %T", c)
+	}
+	if c := dialectCodec(serverConn, dialectText); !isText(c) {
+		t.Errorf("text:  got %T", c)
+	}
+	for _, name := range protocol.DialectNames() {
+		c := dialectCodec(serverConn, name)
+		if legacy, ok := c.(*protocol.LegacyCodec); !ok || legacy.Dialect.Name != name {
+			t.Errorf("%s:  got %#v", name, c)
+		}
+	}
+}
+
+func isFramed(c protocol.Codec) bool {
+	_, ok := c.(*protocol.FrameCodec)
+	return ok
+}
+
+func isText(c protocol.Codec) bool {
+	_, ok := c.(*textCodec)
+	return ok
+}
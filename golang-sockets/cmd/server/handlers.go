@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+	"golang-sockets/pkg/stats"
+)
+
+// Handle one message from a client.  Every request gets exactly
+// one reply:  either the normal response, or an ErrorMessage.
+func handleMessage(ci *game.ClientInfo, msg protocol.Message) {
+	switch m := msg.(type) {
+	case *protocol.GuessMessage:
+		if m.MessageType != protocol.MessageTypeGuess {
+			sendStrike(ci, protocol.ErrorCodeBadRequest,
+				fmt.Sprintf("clients can't send %s messages", protocol.MessageName(m.Type())))
+			return
+		}
+		handleGuess(ci, m)
+
+	case *protocol.ListRoomsMessage:
+		handleListRooms(ci)
+
+	case *protocol.CreateRoomMessage:
+		g, err := Lobby.CreateRoom(ci, m.Name, game.SettingsFromWire(m.Settings))
+		if err != nil {
+			sendLobbyError(ci, err)
+			return
+		}
+		log.Printf("%s created room %s\n", ci, g.Name)
+
+	case *protocol.JoinRoomMessage:
+		g, err := Lobby.JoinRoom(ci, m.Name)
+		if err != nil {
+			sendLobbyError(ci, err)
+			return
+		}
+		log.Printf("%s joined room %s\n", ci, g.Name)
+
+	case *protocol.GetTopMessage:
+		handleGetTop(ci, m)
+
+	case *protocol.GetStatsMessage:
+		name := m.Name
+		if name == "" {
+			name = ci.Name
+		}
+		p := Lobby.Stats.Get(name)
+		ci.Send(&protocol.StatsMessage{Stats: playerStats(p)})
+
+	case *protocol.PingMessage:
+		// Just reading a pong is enough to keep the client alive
+		if m.MessageType == protocol.MessageTypePing {
+			ci.Send(&protocol.PingMessage{
+				MessageType: protocol.MessageTypePong,
+				Seq:         m.Seq,
+			})
+		}
+
+	case *protocol.SpectateMessage:
+		if m.MessageType != protocol.MessageTypeSpectate {
+			sendStrike(ci, protocol.ErrorCodeBadRequest,
+				fmt.Sprintf("clients can't send %s messages", protocol.MessageName(m.Type())))
+			return
+		}
+		handleSpectate(ci, m)
+
+	case *protocol.LeaveRoomMessage:
+		Lobby.LeaveRoom(ci)
+		log.Printf("%s returned to the lobby\n", ci)
+		ci.Send(&protocol.RoomLeftMessage{})
+
+	default:
+		sendStrike(ci, protocol.ErrorCodeBadRequest,
+			fmt.Sprintf("clients can't send %s messages", protocol.MessageName(m.Type())))
+	}
+}
+
+func handleSpectate(ci *game.ClientInfo, m *protocol.SpectateMessage) {
+	err := Lobby.Spectate(ci, m.Room)
+	if err != nil {
+		sendLobbyError(ci, err)
+		return
+	}
+
+	if m.Room == "" {
+		log.Printf("%s is watching every room\n", ci)
+	} else {
+		log.Printf("%s is watching room %s\n", ci, m.Room)
+	}
+	ci.Send(&protocol.SpectateMessage{MessageType: protocol.MessageTypeSpectating, Room: m.Room})
+}
+
+func handleGuess(ci *game.ClientInfo, guess *protocol.GuessMessage) {
+	g := ci.Game
+	if g == nil {
+		sendError(ci, protocol.ErrorCodeNotInRoom, "join a room before guessing")
+		return
+	}
+
+	log.Printf("Room %s:  %s guessed %d\n", g.Name, ci, guess.Number)
+
+	// DoGuess sends the response (and ends the round, if need be)
+	result, err := g.DoGuess(ci, guess.Number, guess.Round)
+	if err != nil {
+		code := uint8(protocol.ErrorCodeBadRequest)
+		if errors.Is(err, game.ErrOutOfRange) {
+			code = protocol.ErrorCodeOutOfRange
+		} else if errors.Is(err, game.ErrNoGuessesLeft) {
+			code = protocol.ErrorCodeNoGuesses
+		} else if errors.Is(err, game.ErrNotYourTurn) {
+			code = protocol.ErrorCodeNotYourTurn
+		}
+		sendError(ci, code, err.Error())
+		return
+	}
+
+	if result == game.GuessRoundOver {
+		log.Printf("Room %s:  round %d is already over, ignoring %s's guess\n",
+			g.Name, guess.Round, ci)
+	}
+}
+
+func handleListRooms(ci *game.ClientInfo) {
+	rooms := Lobby.ListRooms()
+
+	response := &protocol.RoomListMessage{}
+	for _, room := range rooms {
+		response.Rooms = append(response.Rooms, protocol.RoomInfo{
+			Name:       room.Name,
+			Settings:   room.Settings.Wire(),
+			NumPlayers: uint16(room.NumPlayers),
+		})
+	}
+	ci.Send(response)
+}
+
+// Largest leaderboard we'll send in one message
+const maxTopCount = 100
+
+func handleGetTop(ci *game.ClientInfo, m *protocol.GetTopMessage) {
+	count := int(m.Count)
+	if count > maxTopCount {
+		count = maxTopCount
+	}
+
+	response := &protocol.TopMessage{}
+	for _, p := range Lobby.Stats.Top(count) {
+		response.Players = append(response.Players, playerStats(p))
+	}
+	ci.Send(response)
+}
+
+// Convert stats from the store into their wire format
+func playerStats(p stats.PlayerStats) protocol.PlayerStats {
+	return protocol.PlayerStats{
+		Name:           p.Name,
+		RoundsPlayed:   uint32(p.RoundsPlayed),
+		RoundsWon:      uint32(p.RoundsWon),
+		WinningGuesses: uint32(p.WinningGuesses),
+		BestRound:      uint32(p.BestRound),
+	}
+}
+
+// Translate an error from the lobby into the matching error code
+func sendLobbyError(ci *game.ClientInfo, err error) {
+	code := uint8(protocol.ErrorCodeBadRequest)
+	if errors.Is(err, game.ErrNoSuchRoom) {
+		code = protocol.ErrorCodeNoSuchRoom
+	} else if errors.Is(err, game.ErrRoomExists) {
+		code = protocol.ErrorCodeRoomExists
+	}
+
+	sendError(ci, code, err.Error())
+}
+
+// Like sendError, but for things a well-behaved client would never do
+// (like sending messages only the server sends, or flooding us).  Each
+// one is a strike against the client, and after MaxStrikes, we hang up.
+func sendStrike(ci *game.ClientInfo, code uint8, text string) {
+	ci.Strikes++
+	if MaxStrikes > 0 {
+		text = fmt.Sprintf("%s (strike %d of %d)", text, ci.Strikes, MaxStrikes)
+	}
+	sendError(ci, code, text)
+}
+
+// Check if a client has had too many strikes, and should be disconnected
+func tooManyStrikes(ci *game.ClientInfo) bool {
+	return MaxStrikes > 0 && ci.Strikes >= MaxStrikes
+}
+
+func sendError(ci *game.ClientInfo, code uint8, text string) {
+	log.Printf("%s:  error:  %s\n", ci, text)
+	ci.Send(&protocol.ErrorMessage{
+		Code: code,
+		Text: text,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+// How long a new client has to send its Hello
+const handshakeTimeout = 5 * time.Second
+
+// The first message on every connection must be a Hello, which tells
+// us the client's name and which protocol versions it speaks.  We
+// pick a version, and reply with a Welcome (or an error, after which
+// we hang up).  Returns true if the client can start playing.
+func doHandshake(ci *game.ClientInfo) bool {
+	// Don't let a client that never says hello hang around forever
+	msg, err := ci.Codec.ReadMessage(handshakeTimeout)
+	if err != nil {
+		readErrors.Add(err)
+		switch {
+		case errors.Is(err, protocol.ErrUnsupportedVersion):
+			sendError(ci, protocol.ErrorCodeBadVersion, err.Error())
+		case errors.Is(err, protocol.ErrUnknownMessageType), errors.Is(err, protocol.ErrMalformedMessage):
+			// Whatever it was, it wasn't a hello
+			sendError(ci, protocol.ErrorCodeBadHello, err.Error())
+		case errors.Is(err, protocol.ErrProtocolViolation):
+			sendError(ci, protocol.ErrorCodeBadRequest, err.Error())
+		default:
+			// Gone, or never said anything
+			log.Printf("%s:  handshake failed:  %v\n", ci, err)
+		}
+		return false
+	}
+
+	hello, ok := msg.(*protocol.HelloMessage)
+	if !ok {
+		sendError(ci, protocol.ErrorCodeBadHello,
+			fmt.Sprintf("expected hello, got %s", protocol.MessageName(msg.Type())))
+		return false
+	}
+
+	version, ok := protocol.NegotiateVersion(hello.Versions)
+	if !ok {
+		sendError(ci, protocol.ErrorCodeBadVersion,
+			fmt.Sprintf("no common protocol version:  client speaks %v, server speaks %d-%d",
+				hello.Versions, protocol.MinProtocolVersion, protocol.ProtocolVersion))
+		return false
+	}
+
+	// From now on (starting with our answer to the hello), frames go
+	// out in the version we picked
+	if frames, ok := ci.Codec.(*protocol.FrameCodec); ok {
+		frames.SetVersion(version)
+	}
+
+	resumed, room, err := Lobby.RegisterClient(ci, hello.Name, version, hello.ResumeToken)
+	if err != nil {
+		code := uint8(protocol.ErrorCodeBadHello)
+		if errors.Is(err, game.ErrNameTaken) {
+			code = protocol.ErrorCodeNameTaken
+		}
+		sendError(ci, code, err.Error())
+		return false
+	}
+
+	welcome := &protocol.WelcomeMessage{
+		Version:     ci.Version,
+		SessionId:   ci.Session.Id,
+		Name:        ci.Name,
+		ResumeToken: ci.Session.Token,
+		Resumed:     resumed,
+	}
+
+	if resumed {
+		log.Printf("%s reconnected from %s (session %08x, protocol version %d)\n",
+			ci, ci.Conn.RemoteAddr(), ci.Session.Id, ci.Version)
+
+		// Tell the client where it's going back to (if the room is still
+		// there) before it starts hearing from the room
+		if g := Lobby.Room(room); g != nil {
+			welcome.Room = g.Name
+			welcome.RoundGuesses = uint32(g.GuessesThisRound(ci.Name))
+		}
+		ci.Send(welcome)
+		restoreRoom(ci, room)
+
+		return true
+	}
+
+	log.Printf("%s connected from %s (session %08x, protocol version %d)\n",
+		ci, ci.Conn.RemoteAddr(), ci.Session.Id, ci.Version)
+
+	ci.Send(welcome)
+
+	return true
+}
+
+// Put a resumed client back in its old room, if it was in one
+func restoreRoom(ci *game.ClientInfo, room string) {
+	if room == "" {
+		return
+	}
+
+	g, err := Lobby.RestoreRoom(ci, room)
+	if err != nil {
+		log.Printf("%s:  could not rejoin room %s:  %v\n", ci, room, err)
+		return
+	}
+
+	log.Printf("%s is back in room %s\n", ci, g.Name)
+}
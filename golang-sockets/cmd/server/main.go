@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+	"golang-sockets/pkg/rudp"
+	"golang-sockets/pkg/stats"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var Lobby *game.Lobby
+
+var (
+	// Clients that send nothing (not even a pong) for this long are removed
+	IdleTimeout time.Duration
+
+	// How often we ping each client, so well-behaved clients are never idle
+	PingInterval time.Duration
+
+	// Clients are disconnected after this many strikes (see sendStrike)
+	MaxStrikes int
+
+	// Give up on clients that take longer than this to accept a write
+	WriteTimeout time.Duration
+)
+
+// When a client leaves, how long we wait for the rest of its outbox to
+// be written before closing the socket anyway
+const drainTimeout = 2 * time.Second
+
+// Longest we wait between retries when Accept keeps failing (eg. when
+// we're out of file descriptors)
+const maxAcceptDelay = time.Second
+
+func main() {
+	statsPath := flag.String("stats", "stats.json", "file to keep player statistics in")
+	journalPath := flag.String("journal", "", "file to record every game event in, for cmd/replay (empty for none)")
+	metricsAddr := flag.String("metrics", "",
+		"address to serve Prometheus metrics on, eg. localhost:9100 (empty for none)")
+	flag.DurationVar(&IdleTimeout, "idle-timeout", 30*time.Second,
+		"remove clients that are silent for this long (0 to never remove them)")
+	flag.DurationVar(&PingInterval, "ping-interval", 10*time.Second,
+		"how often to ping clients (must be less than the idle timeout)")
+	resumeWindow := flag.Duration("resume-window", 2*time.Minute,
+		"how long disconnected clients can resume their session")
+	admin := flag.Bool("admin", true, "run an admin REPL on stdin")
+	udp := flag.Bool("udp", false, "also accept players over UDP, on the same port number (see pkg/rudp)")
+	rateLimit := flag.Float64("rate", 10, "messages per second each client can send (0 for no limit)")
+	rateBurst := flag.Int("burst", 20, "messages each client can send in a burst, above the rate")
+	flag.IntVar(&MaxStrikes, "max-strikes", 5,
+		"disconnect clients after this many bad or rate-limited messages (0 to never disconnect them)")
+	queueSize := flag.Int("queue-size", game.DefaultQueueSize,
+		"messages that can be waiting to be sent to each client")
+	overflow := flag.String("overflow", game.DropOldest.String(),
+		"what to do when a client's queue is full:  drop-oldest or disconnect")
+	flag.DurationVar(&WriteTimeout, "write-timeout", 10*time.Second,
+		"disconnect clients that take this long to accept a message (0 to wait forever)")
+	legacyDialect := flag.String("legacy", "c",
+		fmt.Sprintf("dialect of 5-byte clients on the main port (one of %v)", protocol.DialectNames()))
+	var dialectPortFlags dialectPorts
+	flag.Var(&dialectPortFlags, "dialect",
+		"also listen on a port where every client speaks one dialect, eg. c=9001 (framed, text, or a -legacy dialect; repeatable)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second,
+		"when shutting down, how long to wait for clients to leave before hanging up on them")
+
+	// Game rules can come from a file, or from flags (which win)
+	defaults := game.DefaultSettings()
+	rulesPath := flag.String("rules", "", "JSON file with the game rules (see pkg/game/rules.go)")
+	minNumber := flag.Int("min", int(defaults.MinNumber), "smallest possible target number")
+	maxNumber := flag.Int("max", int(defaults.MaxNumber), "targets are less than this number")
+	maxGuesses := flag.Int("max-guesses", defaults.MaxGuesses,
+		"guesses each player gets per round (0 for no limit)")
+	timeLimit := flag.Duration("time-limit", defaults.TimeLimit,
+		"length of each round (0 for no limit)")
+	turnBased := flag.Bool("turns", defaults.TurnBased, "make players take turns guessing")
+	turnTimeout := flag.Duration("turn-timeout", defaults.TurnTimeout,
+		"skip players who take longer than this to guess (0 for no limit)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage:  %s [options] <port number>", os.Args[0])
+	}
+	if PingInterval <= 0 || (IdleTimeout > 0 && PingInterval >= IdleTimeout) {
+		log.Fatalln("The ping interval must be positive, and less than the idle timeout")
+	}
+	overflowPolicy, err := game.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	LegacyDialect, err = protocol.LookupDialect(*legacyDialect)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	//log.Default().SetOutput(io.Discard) //Equivalent of writing logs to /dev/null
+
+	settings := defaults
+	if *rulesPath != "" {
+		settings, err = game.LoadSettings(*rulesPath, settings)
+		if err != nil {
+			log.Fatalln("Error loading rules:  ", err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min":
+			settings.MinNumber = int32(*minNumber)
+		case "max":
+			settings.MaxNumber = int32(*maxNumber)
+		case "max-guesses":
+			settings.MaxGuesses = *maxGuesses
+		case "time-limit":
+			settings.TimeLimit = *timeLimit
+		case "turns":
+			settings.TurnBased = *turnBased
+		case "turn-timeout":
+			settings.TurnTimeout = *turnTimeout
+		}
+	})
+	if err = settings.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Rules:  %v\n", settings)
+	RoomDefaults = settings
+
+	portNumber := flag.Arg(0)
+
+	// Get a TCPAddr and listen on the port number we specified on the command line
+	addr, err := net.ResolveTCPAddr("tcp4", fmt.Sprintf(":%s", portNumber))
+	if err != nil {
+		log.Fatalln("Error translating address:  ", err)
+	}
+
+	conn, err := net.ListenTCP("tcp4", addr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer conn.Close()
+
+	// Another way to do this:
+	// conn, err := net.Listen("tcp", fmt.Sprintf(":%s", portNumber))
+
+	// UDP and TCP have separate port numbers, so we can use the same one
+	var udpListener *rudp.Listener
+	if *udp {
+		udpListener, err = rudp.Listen("udp4", fmt.Sprintf(":%s", portNumber))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer udpListener.Close()
+	}
+
+	var dialectListeners []net.Listener
+	for _, dp := range dialectPortFlags {
+		l, err := net.Listen("tcp4", fmt.Sprintf(":%s", dp.Port))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer l.Close()
+		log.Printf("Clients on port %s speak %s\n", dp.Port, dp.Dialect)
+		dialectListeners = append(dialectListeners, l)
+	}
+
+	statsStore, err := stats.Open(*statsPath)
+	if err != nil {
+		log.Fatalln("Error loading stats:  ", err)
+	}
+	defer statsStore.Close()
+
+	var j *journal.Journal
+	if *journalPath != "" {
+		j, err = journal.Open(*journalPath)
+		if err != nil {
+			log.Fatalln("Error opening journal:  ", err)
+		}
+		defer j.Close()
+		log.Printf("Recording events in %s\n", *journalPath)
+	}
+	wire := settings.Wire()
+	j.Record(journal.Event{Type: journal.Start, Settings: &wire})
+
+	// Initialize the lobby, which starts out with one room
+	rand.Seed(time.Now().Unix())
+	Lobby = game.NewLobby(statsStore, j, settings, *resumeWindow)
+	Lobby.RateLimit = *rateLimit
+	Lobby.RateBurst = *rateBurst
+	Lobby.QueueSize = *queueSize
+	Lobby.Overflow = overflowPolicy
+
+	if *metricsAddr != "" {
+		err = ServeMetrics(*metricsAddr)
+		if err != nil {
+			log.Fatalln("Error starting metrics server:  ", err)
+		}
+	}
+
+	// Ctrl+C, SIGTERM or the admin REPL (see admin.go) shut us down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, quit := context.WithCancel(ctx)
+	defer quit()
+	if *admin {
+		go RunAdminRepl(quit)
+	}
+
+	listeners := []net.Listener{conn}
+	dialects := []string{""}
+	if udpListener != nil {
+		listeners = append(listeners, udpListener)
+		dialects = append(dialects, "")
+	}
+	for i, l := range dialectListeners {
+		listeners = append(listeners, l)
+		dialects = append(dialects, dialectPortFlags[i].Dialect)
+	}
+	for i, l := range listeners {
+		go waitForConnections(ctx, l, dialects[i])
+	}
+
+	<-ctx.Done()
+	stop() // A second Ctrl+C kills us the usual way
+	fmt.Println("Shutting down, closing clients...")
+
+	// No new clients, then ask the ones we have to leave
+	for _, l := range listeners {
+		l.Close()
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	err = Lobby.Shutdown(drainCtx, "server shutting down")
+	if err != nil {
+		log.Println(err)
+	}
+	fmt.Println("All clients closed!")
+}
+
+// Accept clients on a listener until ctx is done.  They speak dialect
+// (see dialects.go), or if it's empty, whatever we detect.
+func waitForConnections(ctx context.Context, listenConn net.Listener, dialect string) {
+	delay := time.Duration(0)
+	for {
+		// Wait for new connections (returns a new conn object for each client)
+		conn, err := listenConn.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// Probably temporary, so back off and try again
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			log.Printf("accept:  %v (retrying in %v)\n", err, delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			continue
+		}
+		delay = 0
+
+		// Create new per-client state, and start a goroutine for this client
+		ci := Lobby.NewClient(&countingConn{conn})
+		go handleClient(ctx, ci, dialect)
+	}
+}
+
+func handleClient(ctx context.Context, ci *game.ClientInfo, dialect string) {
+	// Only once everything below is done (including sending what's left
+	// in the outbox), so Lobby.Shutdown doesn't return too early
+	defer Lobby.ClientWaitGroup.Done()
+
+	conn := ci.Conn
+	defer conn.Close()
+
+	// Lobby.Shutdown might have missed a client that arrived just as we
+	// started shutting down
+	if ctx.Err() != nil {
+		Lobby.RemoveClient(ci)
+		return
+	}
+
+	log.Printf("New connection:  %s (%s)\n", conn.RemoteAddr(), conn.RemoteAddr().Network())
+
+	// Binary, text or legacy client?  (see dialects.go)
+	var codec protocol.Codec
+	if dialect != "" {
+		codec = dialectCodec(conn, dialect)
+	} else {
+		var err error
+		codec, err = detectCodec(conn, IdleTimeout)
+		if err != nil {
+			log.Printf("%s:  handshake failed:  %v\n", ci, err)
+			readErrors.Add(err)
+			Lobby.RemoveClient(ci)
+			return
+		}
+	}
+	ci.Codec = codec
+	_, text := codec.(*textCodec)
+	legacy, isLegacy := codec.(*protocol.LegacyCodec)
+
+	// Everything we send the client goes through its outbox, so the only
+	// goroutine that writes to the socket is this one
+	go func() {
+		err := ci.Outbox.Run(conn, codec, WriteTimeout)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("%s:  write error:  %v\n", ci, err)
+			writeErrors.Add(err)
+			conn.Close() // Make sure the reader notices too
+		}
+	}()
+
+	defer drainOutbox(ci)
+	defer Lobby.RemoveClient(ci)
+
+	if !doHandshake(ci) {
+		return
+	}
+
+	// Text clients are usually people typing into netcat, who shouldn't
+	// have to join a room before they can play, or answer pings (or be
+	// thrown out for thinking too long).  Legacy clients can't do any of
+	// that at all.  TCP keepalives still notice if they go away.
+	idleTimeout := IdleTimeout
+	if text {
+		log.Printf("%s is using the text protocol\n", ci)
+	} else if isLegacy {
+		log.Printf("%s is using the %s dialect (%s)\n", ci, legacy.Dialect.Name, legacy.Dialect.Client)
+	}
+	simple := text || isLegacy
+	if simple {
+		idleTimeout = 0
+		if ci.Game == nil {
+			_, err := Lobby.JoinRoom(ci, game.DefaultRoomName)
+			if err != nil {
+				log.Printf("%s:  could not join room %s:  %v\n", ci, game.DefaultRoomName, err)
+			}
+		}
+	}
+
+	// Our client handler reads messages from the client (in another
+	// goroutine, so we can also send pings) and responds to them (see
+	// handlers.go).  Broadcasts from the client's room go straight to
+	// its outbox.
+
+	socketChan := make(chan protocol.Message, 1)
+	badFrameChan := make(chan error, 1)
+	var closeReason string // Set before socketChan is closed
+
+	// Closed when the handler returns, so the reader never blocks
+	// handing it a message nobody will take
+	handlerDone := make(chan struct{})
+	defer close(handlerDone)
+
+	go func() {
+		// The socket stays open when we stop reading, until the handler
+		// has drained the outbox (so the client hears why we're hanging
+		// up, or the answers to what it sent before QUIT)
+		for {
+			// Every message (including pongs) resets the idle timer
+			msg, err := codec.ReadMessage(idleTimeout)
+			if errors.Is(err, protocol.ErrUnknownMessageType) || errors.Is(err, protocol.ErrMalformedMessage) {
+				// We couldn't understand the message, but we can keep
+				// reading after it
+				ci.Touch()
+				readErrors.Add(err)
+				select {
+				case badFrameChan <- err:
+				case <-handlerDone:
+					return
+				}
+			} else if err != nil {
+				// Hanging up (or us hanging up on them) isn't an error
+				if !errors.Is(err, protocol.ErrPeerClosed) && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+					readErrors.Add(err)
+				}
+
+				// (see pkg/protocol/errors.go)
+				switch {
+				case ctx.Err() != nil:
+					closeReason = "server shutting down" // See Lobby.Shutdown
+				case errors.Is(err, protocol.ErrPeerClosed):
+					closeReason = "client closed connection"
+				case errors.Is(err, protocol.ErrTimeout):
+					closeReason = fmt.Sprintf("idle for %v", idleTimeout)
+				case errors.Is(err, protocol.ErrShortRead):
+					closeReason = "client hung up partway through a message"
+				case errors.Is(err, protocol.ErrProtocolViolation):
+					// There's no telling where the next message starts,
+					// but the client might still understand why we're
+					// hanging up
+					code := uint8(protocol.ErrorCodeBadRequest)
+					if errors.Is(err, protocol.ErrUnsupportedVersion) {
+						code = protocol.ErrorCodeBadVersion
+					}
+					sendError(ci, code, err.Error())
+					closeReason = err.Error()
+				default:
+					closeReason = fmt.Sprintf("read error:  %v", err)
+				}
+				close(socketChan)
+				return
+			} else {
+				ci.Touch()
+				select {
+				case socketChan <- msg:
+				case <-handlerDone:
+					return
+				}
+			}
+		}
+	}()
+
+	pingTicker := time.NewTicker(PingInterval)
+	defer pingTicker.Stop()
+	pingChan := pingTicker.C
+	if simple {
+		pingChan = nil
+	}
+	pingSeq := uint32(0)
+
+	for {
+		select {
+		case msg, ok := <-socketChan:
+			if !ok {
+				log.Printf("Removing %s (%s):  %s", ci, conn.RemoteAddr(), closeReason)
+				return
+			}
+
+			// Pongs are on our schedule, not the client's, so they don't count
+			if msg.Type() != protocol.MessageTypePong && !ci.Limiter.Allow() {
+				sendStrike(ci, protocol.ErrorCodeRateLimited, "sending too fast")
+			} else {
+				handleMessage(ci, msg)
+			}
+
+		case err := <-badFrameChan:
+			code := uint8(protocol.ErrorCodeBadRequest)
+			if errors.Is(err, protocol.ErrUnknownMessageType) {
+				code = protocol.ErrorCodeUnknownType
+			}
+			sendStrike(ci, code, err.Error())
+
+		case <-pingChan:
+			pingSeq++
+			ci.Send(&protocol.PingMessage{
+				MessageType: protocol.MessageTypePing,
+				Seq:         pingSeq,
+			})
+
+		case <-ci.ServerCloseChan:
+			if ci.Outbox.Overflowed() {
+				log.Printf("Removing %s (%s):  too slow to keep up", ci, conn.RemoteAddr())
+				writeErrors.AddKind("overflow")
+			} else {
+				log.Printf("Closing connection to %s", ci)
+			}
+			return
+		}
+
+		if tooManyStrikes(ci) {
+			log.Printf("Removing %s (%s):  too many strikes", ci, conn.RemoteAddr())
+			sendError(ci, protocol.ErrorCodeKicked, "too many bad messages, goodbye")
+			Lobby.Kick(ci)
+			return
+		}
+	}
+}
+
+// Stop queueing messages for a client that's leaving, and give its
+// writer a little while to send the ones already queued (like why
+// we're closing the connection).  A client that won't read them doesn't
+// get to hold us up, though.
+func drainOutbox(ci *game.ClientInfo) {
+	ci.Outbox.Close()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ci.Outbox.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// ****************** METRICS **************************
+// With -metrics, the server answers HTTP requests for /metrics with
+// its numbers in the Prometheus text format, so they can be scraped
+// (or just read with curl).  Most of the numbers come from the lobby and
+// its rooms; the rest are counted here, by the client handlers.
+
+// Bytes read from and written to every client's socket (atomic)
+var bytesIn, bytesOut int64
+
+// Read and write errors, by kind (see errorKind)
+var (
+	readErrors  = newErrorCounter()
+	writeErrors = newErrorCounter()
+)
+
+// Guesses per second, over the last minute
+var guessRate = &rateMeter{}
+
+const (
+	guessRateInterval = 5 * time.Second // How often guessRate takes a sample
+	guessRateWindow   = time.Minute     // How far back it looks
+)
+
+// Start serving metrics on addr (eg. localhost:9100)
+func ServeMetrics(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go guessRate.Run(&Lobby.Totals.Guesses, guessRateInterval, guessRateWindow)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	go func() {
+		err := http.Serve(listener, mux)
+		log.Println("Metrics server stopped:  ", err)
+	}()
+
+	log.Printf("Serving metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+func writeMetrics(w io.Writer) {
+	clients := Lobby.ListClients()
+	rooms := Lobby.ListRooms()
+
+	// How far behind the clients are
+	queued, maxQueued := 0, 0
+	for _, ci := range clients {
+		n := ci.Outbox.Len()
+		queued += n
+		if n > maxQueued {
+			maxQueued = n
+		}
+	}
+
+	rounds := atomic.LoadInt64(&Lobby.Totals.RoundsPlayed)
+	roundGuesses := atomic.LoadInt64(&Lobby.Totals.RoundGuesses)
+	averageGuesses := 0.0
+	if rounds > 0 {
+		averageGuesses = float64(roundGuesses) / float64(rounds)
+	}
+
+	metric(w, "guessing_game_start_time_seconds", "gauge", "When the server started, in seconds since the epoch.")
+	sample(w, "guessing_game_start_time_seconds", "", startTime.Unix())
+
+	metric(w, "guessing_game_clients", "gauge", "Connected clients.")
+	sample(w, "guessing_game_clients", "", len(clients))
+	metric(w, "guessing_game_spectators", "gauge", "Clients watching rooms instead of playing.")
+	sample(w, "guessing_game_spectators", "", Lobby.Spectators.Count())
+
+	metric(w, "guessing_game_rooms", "gauge", "Open rooms.")
+	sample(w, "guessing_game_rooms", "", len(rooms))
+	metric(w, "guessing_game_room_players", "gauge", "Players in each room.")
+	for _, room := range rooms {
+		sample(w, "guessing_game_room_players", label("room", room.Name), room.NumPlayers)
+	}
+
+	metric(w, "guessing_game_guesses_total", "counter", "Guesses made.")
+	sample(w, "guessing_game_guesses_total", "", atomic.LoadInt64(&Lobby.Totals.Guesses))
+	metric(w, "guessing_game_guesses_per_second", "gauge", "Guesses per second, over the last minute.")
+	sample(w, "guessing_game_guesses_per_second", "", guessRate.Rate())
+
+	metric(w, "guessing_game_rounds_total", "counter", "Rounds completed (won, reset, or out of time or guesses).")
+	sample(w, "guessing_game_rounds_total", "", rounds)
+	metric(w, "guessing_game_rounds_won_total", "counter", "Rounds somebody won.")
+	sample(w, "guessing_game_rounds_won_total", "", atomic.LoadInt64(&Lobby.Totals.RoundsWon))
+	metric(w, "guessing_game_round_guesses_total", "counter", "Guesses made in completed rounds.")
+	sample(w, "guessing_game_round_guesses_total", "", roundGuesses)
+	metric(w, "guessing_game_average_guesses_per_round", "gauge", "Guesses per completed round.")
+	sample(w, "guessing_game_average_guesses_per_round", "", averageGuesses)
+
+	metric(w, "guessing_game_received_bytes_total", "counter", "Bytes read from clients.")
+	sample(w, "guessing_game_received_bytes_total", "", atomic.LoadInt64(&bytesIn))
+	metric(w, "guessing_game_sent_bytes_total", "counter", "Bytes written to clients.")
+	sample(w, "guessing_game_sent_bytes_total", "", atomic.LoadInt64(&bytesOut))
+
+	metric(w, "guessing_game_read_errors_total", "counter", "Errors reading from clients, by kind.")
+	readErrors.write(w, "guessing_game_read_errors_total")
+	metric(w, "guessing_game_write_errors_total", "counter", "Errors writing to clients, by kind.")
+	writeErrors.write(w, "guessing_game_write_errors_total")
+
+	metric(w, "guessing_game_outbox_messages", "gauge", "Messages waiting to be sent, over all clients.")
+	sample(w, "guessing_game_outbox_messages", "", queued)
+	metric(w, "guessing_game_outbox_max_messages", "gauge", "Messages waiting to be sent to the client furthest behind.")
+	sample(w, "guessing_game_outbox_max_messages", "", maxQueued)
+}
+
+// Start a metric
+func metric(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// One value of a metric (labels from label, or empty for none)
+func sample(w io.Writer, name string, labels string, value interface{}) {
+	fmt.Fprintf(w, "%s%s %v\n", name, labels, value)
+}
+
+// {name="value"}, escaped the way Prometheus wants
+func label(name string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return fmt.Sprintf(`{%s="%s"}`, name, value)
+}
+
+// A socket that counts the bytes going through it
+type countingConn struct {
+	net.Conn
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&bytesIn, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&bytesOut, int64(n))
+	return n, err
+}
+
+// Counts errors by kind
+type errorCounter struct {
+	lock   sync.Mutex
+	counts map[string]int64
+}
+
+func newErrorCounter() *errorCounter {
+	return &errorCounter{counts: make(map[string]int64)}
+}
+
+func (c *errorCounter) Add(err error) {
+	c.AddKind(errorKind(err))
+}
+
+func (c *errorCounter) AddKind(kind string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.counts[kind]++
+}
+
+func (c *errorCounter) write(w io.Writer, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	kinds := make([]string, 0, len(c.counts))
+	for kind := range c.counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		sample(w, name, label("kind", kind), c.counts[kind])
+	}
+}
+
+// What kind of error this is, for the error metrics
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, io.EOF):
+		return "eof"
+	case os.IsTimeout(err):
+		return "timeout"
+	case errors.Is(err, net.ErrClosed):
+		return "closed"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.EPIPE):
+		return "broken_pipe"
+	case errors.Is(err, protocol.ErrMalformedMessage):
+		return "malformed"
+	case errors.Is(err, protocol.ErrUnknownMessageType):
+		return "unknown_type"
+	case errors.Is(err, protocol.ErrUnsupportedVersion):
+		return "bad_version"
+	case errors.Is(err, protocol.ErrShortRead):
+		return "short_read"
+	case errors.Is(err, protocol.ErrProtocolViolation):
+		return "protocol_violation"
+	}
+	return "other"
+}
+
+// Keeps track of how fast a counter is going up
+type rateMeter struct {
+	lock    sync.Mutex
+	samples []rateSample // Oldest first
+}
+
+type rateSample struct {
+	when  time.Time
+	count int64
+}
+
+// Sample counter every interval, remembering window's worth of samples
+func (m *rateMeter) Run(counter *int64, interval time.Duration, window time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		m.lock.Lock()
+		m.samples = append(m.samples, rateSample{when: now, count: atomic.LoadInt64(counter)})
+		for len(m.samples) > 2 && now.Sub(m.samples[0].when) > window {
+			m.samples = m.samples[1:]
+		}
+		m.lock.Unlock()
+
+		<-ticker.C
+	}
+}
+
+// How much the counter went up per second, between the oldest sample
+// and the newest
+func (m *rateMeter) Rate() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.samples) < 2 {
+		return 0
+	}
+	first, last := m.samples[0], m.samples[len(m.samples)-1]
+	return float64(last.count-first.count) / last.when.Sub(first.when).Seconds()
+}
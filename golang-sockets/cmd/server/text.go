@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+// Text protocol
+//
+// Besides the framed protocol, we speak a simple line-based protocol,
+// so people can play with netcat or telnet:
+//
+//	$ nc localhost 9000
+//	WELCOME player-3
+//	...
+//	guess 4000
+//	TOO_HIGH
+//	2000
+//	TOO_LOW
+//
+// Every line from the client is one command (in any case).  Every line
+// we send starts with an upper-case keyword, followed by its values
+// (as key=value pairs, where there's more than one).  Commands turn
+// into the same messages binary clients send, and everything we send
+// a text client (including broadcasts from its room) is turned into
+// lines on the way out, so text and binary players can share a room.
+//
+// We tell the two apart by the first byte a client sends:  a binary
+// client starts with a frame header, whose first byte is the protocol
+// version (a small number), while text starts with something printable
+// (see detectCodec in dialects.go).
+
+// Longest line we accept from a text client
+const maxTextLine = 1024
+
+const textCommands = "GUESS <n> (or just <n>), ROOMS, JOIN <room>, " +
+	"CREATE <room> [rule=value...], LEAVE, WATCH [room], TOP [n], STATS [name], PING, QUIT"
+
+// Time format for spectators' events
+const textTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+var roundOverKeywords = map[uint8]string{
+	protocol.RoundOverWon:       "won",
+	protocol.RoundOverReset:     "reset",
+	protocol.RoundOverTimeUp:    "time_up",
+	protocol.RoundOverNoGuesses: "no_guesses",
+}
+
+func looksLikeText(b byte) bool {
+	return b == '\t' || b == '\r' || b == '\n' || (b >= ' ' && b < 0x7f)
+}
+
+type textCodec struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	defaults game.Settings // What CREATE's rules are applied on top of
+
+	// Only used by the reader
+	greeted bool   // Whether we've returned a Hello yet
+	pending string // Line to handle after the Hello we made up
+}
+
+func (c *textCodec) ReadMessage(timeout time.Duration) (protocol.Message, error) {
+	line := c.pending
+	c.pending = ""
+	for line == "" {
+		var err error
+		line, err = c.readLine(timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := parseTextCommand(line, c.defaults)
+
+	// Every client has to start with a Hello, but people shouldn't have
+	// to type one.  If they didn't, say it for them (which picks a name),
+	// and handle what they did type next time.
+	if !c.greeted {
+		c.greeted = true
+		if _, ok := msg.(*protocol.HelloMessage); !ok {
+			c.pending = line
+			return &protocol.HelloMessage{Versions: protocol.SupportedVersions()}, nil
+		}
+	}
+
+	return msg, err
+}
+
+// Read one line, without the line ending or surrounding spaces
+func (c *textCodec) readLine(timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		defer c.conn.SetReadDeadline(time.Time{})
+	}
+
+	line, err := c.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// Skip the rest of the line, so we can carry on with the next one
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = c.reader.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", protocol.ConnError(err, true)
+		}
+		return "", fmt.Errorf("%w:  line longer than %d bytes", protocol.ErrMalformedMessage, maxTextLine)
+	}
+	if err != nil {
+		return "", protocol.ConnError(err, len(line) > 0)
+	}
+
+	return strings.TrimSpace(string(line)), nil
+}
+
+func (c *textCodec) WriteMessage(m protocol.Message) error {
+	text := formatText(m)
+	if text == "" {
+		return nil // Nothing text clients need to see
+	}
+
+	_, err := c.conn.Write([]byte(text))
+	return protocol.ConnError(err, false)
+}
+
+func textUsage(usage string) error {
+	return fmt.Errorf("%w:  usage:  %s", protocol.ErrMalformedMessage, usage)
+}
+
+// Turn one line from a text client into the message a binary client
+// would have sent.  QUIT comes back as ErrPeerClosed, like a closed
+// connection.  A CREATE's rules change defaults, so anything they leave
+// out is as the server was started with.
+func parseTextCommand(line string, defaults game.Settings) (protocol.Message, error) {
+	fields := strings.Fields(line)
+	command, args := strings.ToUpper(fields[0]), fields[1:]
+
+	// A bare number is a guess
+	if _, err := strconv.ParseInt(command, 10, 32); err == nil {
+		command, args = "GUESS", fields
+	}
+
+	switch command {
+	case "HELLO":
+		if len(args) > 1 {
+			return nil, textUsage("HELLO [name]")
+		}
+		hello := &protocol.HelloMessage{Versions: protocol.SupportedVersions()}
+		if len(args) == 1 {
+			hello.Name = args[0]
+		}
+		return hello, nil
+
+	case "GUESS":
+		if len(args) != 1 {
+			return nil, textUsage("GUESS <n>")
+		}
+		n, err := strconv.ParseInt(args[0], 10, 32)
+		if err != nil {
+			return nil, textUsage("GUESS <n>")
+		}
+		// Round 0 means whatever round is going on when we get to it
+		return &protocol.GuessMessage{MessageType: protocol.MessageTypeGuess, Number: int32(n)}, nil
+
+	case "ROOMS":
+		return &protocol.ListRoomsMessage{}, nil
+
+	case "JOIN":
+		if len(args) != 1 {
+			return nil, textUsage("JOIN <room>")
+		}
+		return &protocol.JoinRoomMessage{Name: args[0]}, nil
+
+	case "CREATE":
+		if len(args) < 1 {
+			return nil, textUsage("CREATE <room> [rule=value...]")
+		}
+		settings, err := game.ParseRules(args[1:], defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%w:  bad rules:  %v", protocol.ErrMalformedMessage, err)
+		}
+		return &protocol.CreateRoomMessage{Name: args[0], Settings: settings.Wire()}, nil
+
+	case "LEAVE":
+		return &protocol.LeaveRoomMessage{}, nil
+
+	case "WATCH":
+		if len(args) > 1 {
+			return nil, textUsage("WATCH [room]")
+		}
+		// Every room, unless they pick one
+		m := &protocol.SpectateMessage{MessageType: protocol.MessageTypeSpectate}
+		if len(args) == 1 && args[0] != "*" {
+			m.Room = args[0]
+		}
+		return m, nil
+
+	case "TOP":
+		count := uint64(10)
+		if len(args) > 0 {
+			var err error
+			count, err = strconv.ParseUint(args[0], 10, 16)
+			if err != nil {
+				return nil, textUsage("TOP [n]")
+			}
+		}
+		return &protocol.GetTopMessage{Count: uint16(count)}, nil
+
+	case "STATS":
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		}
+		return &protocol.GetStatsMessage{Name: name}, nil
+
+	case "PING", "PONG":
+		msgType := uint8(protocol.MessageTypePing)
+		if command == "PONG" {
+			msgType = protocol.MessageTypePong
+		}
+		seq := uint64(0)
+		if len(args) > 0 {
+			var err error
+			seq, err = strconv.ParseUint(args[0], 10, 32)
+			if err != nil {
+				return nil, textUsage(command + " [seq]")
+			}
+		}
+		return &protocol.PingMessage{MessageType: msgType, Seq: uint32(seq)}, nil
+
+	case "QUIT":
+		return nil, &protocol.Error{Kind: protocol.ErrPeerClosed, Err: io.EOF}
+	}
+
+	return nil, fmt.Errorf("%w %q (commands are %s)", protocol.ErrUnknownMessageType, fields[0], textCommands)
+}
+
+// Turn a message into the line(s) we send a text client.  Returns ""
+// for messages text clients don't get.
+func formatText(m protocol.Message) string {
+	var b strings.Builder
+
+	switch m := m.(type) {
+	case *protocol.WelcomeMessage:
+		fmt.Fprintf(&b, "WELCOME %s\n", m.Name)
+		fmt.Fprintf(&b, "INFO commands are %s\n", textCommands)
+
+	case *protocol.GuessMessage:
+		switch {
+		case m.MessageType == protocol.MessageTypeNewGame:
+			fmt.Fprintf(&b, "NEW_GAME round=%d\n", m.Round)
+		case m.MessageType == protocol.MessageTypeResponse:
+			fmt.Fprintf(&b, "%s\n", responseKeyword(m.Number))
+		}
+
+	case *protocol.RoomListMessage:
+		fmt.Fprintf(&b, "ROOMS %d\n", len(m.Rooms))
+		for _, r := range m.Rooms {
+			fmt.Fprintf(&b, "ROOM %s players=%d %s\n", r.Name, r.NumPlayers, rulesText(r.Settings))
+		}
+
+	case *protocol.RoomJoinedMessage:
+		fmt.Fprintf(&b, "JOINED %s round=%d\n", m.Name, m.Round)
+
+	case *protocol.RulesMessage:
+		fmt.Fprintf(&b, "RULES %s %s\n", m.Room, rulesText(m.Settings))
+
+	case *protocol.RoomLeftMessage:
+		b.WriteString("LEFT\n")
+
+	case *protocol.TurnMessage:
+		fmt.Fprintf(&b, "TURN %s", m.Name)
+		if m.TurnTimeoutMs > 0 {
+			fmt.Fprintf(&b, " timeout=%v", time.Duration(m.TurnTimeoutMs)*time.Millisecond)
+		}
+		if m.Skipped != "" {
+			fmt.Fprintf(&b, " skipped=%s", m.Skipped)
+		}
+		b.WriteString("\n")
+
+	case *protocol.PlayerEventMessage:
+		if m.Event == protocol.PlayerEventJoined {
+			fmt.Fprintf(&b, "PLAYER_JOINED %s\n", m.Name)
+		} else {
+			fmt.Fprintf(&b, "PLAYER_LEFT %s\n", m.Name)
+		}
+
+	case *protocol.RoundOverMessage:
+		fmt.Fprintf(&b, "ROUND_OVER round=%d reason=%s", m.Round, roundOverKeyword(m.Reason))
+		if m.Winner != "" {
+			fmt.Fprintf(&b, " winner=%s", m.Winner)
+		}
+		fmt.Fprintf(&b, " target=%d guesses=%d\n", m.Target, m.TotalGuesses)
+		for _, p := range m.Players {
+			fmt.Fprintf(&b, "GUESSES %s %d\n", p.Name, p.Guesses)
+		}
+
+	case *protocol.SpectateMessage:
+		room := m.Room
+		if room == "" {
+			room = "*"
+		}
+		fmt.Fprintf(&b, "WATCHING %s\n", room)
+
+	case *protocol.GameEventMessage:
+		fmt.Fprintf(&b, "EVENT %s room=%s round=%d ",
+			time.UnixMilli(m.Time).Format(textTimeFormat), m.Room, m.Round)
+		switch m.Event {
+		case protocol.GameEventGuess:
+			fmt.Fprintf(&b, "GUESS %s %d %s\n", m.Player, m.Number, responseKeyword(m.Result))
+		case protocol.GameEventJoined:
+			fmt.Fprintf(&b, "PLAYER_JOINED %s\n", m.Player)
+		case protocol.GameEventLeft:
+			fmt.Fprintf(&b, "PLAYER_LEFT %s\n", m.Player)
+		case protocol.GameEventRoundOver:
+			fmt.Fprintf(&b, "ROUND_OVER reason=%s", roundOverKeyword(uint8(m.Result)))
+			if m.Player != "" {
+				fmt.Fprintf(&b, " winner=%s", m.Player)
+			}
+			fmt.Fprintf(&b, " target=%d guesses=%d\n", m.Number, m.Guesses)
+		default:
+			fmt.Fprintf(&b, "UNKNOWN %d\n", m.Event)
+		}
+
+	case *protocol.TopMessage:
+		fmt.Fprintf(&b, "TOP %d\n", len(m.Players))
+		for i := range m.Players {
+			fmt.Fprintf(&b, "RANK %d %s\n", i+1, statsText(&m.Players[i]))
+		}
+
+	case *protocol.StatsMessage:
+		fmt.Fprintf(&b, "STATS %s\n", statsText(&m.Stats))
+
+	case *protocol.PingMessage:
+		if m.MessageType == protocol.MessageTypePing {
+			fmt.Fprintf(&b, "PING %d\n", m.Seq)
+		} else {
+			fmt.Fprintf(&b, "PONG %d\n", m.Seq)
+		}
+
+	case *protocol.ErrorMessage:
+		// "not in a room" -> NOT_IN_A_ROOM
+		code := strings.ToUpper(strings.ReplaceAll(protocol.ErrorCodeName(m.Code), " ", "_"))
+		fmt.Fprintf(&b, "ERROR %s %s\n", code, m.Text)
+
+	case *protocol.ShutdownMessage:
+		fmt.Fprintf(&b, "SHUTDOWN %s\n", m.Reason)
+	}
+
+	return b.String()
+}
+
+func responseKeyword(result int32) string {
+	switch result {
+	case game.GuessTooHigh:
+		return "TOO_HIGH"
+	case game.GuessTooLow:
+		return "TOO_LOW"
+	case game.GuessCorrect:
+		return "CORRECT"
+	case game.GuessRoundOver:
+		return "TOO_LATE"
+	}
+	return strconv.Itoa(int(result))
+}
+
+func roundOverKeyword(reason uint8) string {
+	keyword, ok := roundOverKeywords[reason]
+	if !ok {
+		return strconv.Itoa(int(reason))
+	}
+	return keyword
+}
+
+// Same rule names as CREATE takes
+func rulesText(s protocol.RoomSettings) string {
+	turns := "off"
+	if s.TurnBased {
+		turns = "on"
+	}
+	return fmt.Sprintf("min=%d max=%d guesses=%d time=%v turns=%s turntime=%v",
+		s.MinNumber, s.MaxNumber, s.MaxGuesses,
+		time.Duration(s.TimeLimitMs)*time.Millisecond, turns,
+		time.Duration(s.TurnTimeoutMs)*time.Millisecond)
+}
+
+func statsText(p *protocol.PlayerStats) string {
+	return fmt.Sprintf("%s played=%d won=%d best=%d average=%.1f",
+		p.Name, p.RoundsPlayed, p.RoundsWon, p.BestRound, p.AverageGuessesPerWin())
+}
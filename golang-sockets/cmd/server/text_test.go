@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-sockets/pkg/game"
+	"golang-sockets/pkg/protocol"
+)
+
+func TestParseTextCommand(t *testing.T) {
+	cases := []struct {
+		line string
+		want protocol.Message // nil if it should fail
+		err  error
+	}{
+		{"guess 5", &protocol.GuessMessage{MessageType: protocol.MessageTypeGuess, Number: 5}, nil},
+		{"GuEsS -3", &protocol.GuessMessage{MessageType: protocol.MessageTypeGuess, Number: -3}, nil},
+		{"42", &protocol.GuessMessage{MessageType: protocol.MessageTypeGuess, Number: 42}, nil},
+		{"hello al", &protocol.HelloMessage{Name: "al", Versions: protocol.SupportedVersions()}, nil},
+		{"rooms", &protocol.ListRoomsMessage{}, nil},
+		{"join side", &protocol.JoinRoomMessage{Name: "side"}, nil},
+		{"leave", &protocol.LeaveRoomMessage{}, nil},
+		{"watch *", &protocol.SpectateMessage{MessageType: protocol.MessageTypeSpectate}, nil},
+		{"watch side", &protocol.SpectateMessage{MessageType: protocol.MessageTypeSpectate, Room: "side"}, nil},
+		{"top", &protocol.GetTopMessage{Count: 10}, nil},
+		{"top 3", &protocol.GetTopMessage{Count: 3}, nil},
+		{"stats bob", &protocol.GetStatsMessage{Name: "bob"}, nil},
+		{"pong 7", &protocol.PingMessage{MessageType: protocol.MessageTypePong, Seq: 7}, nil},
+
+		{"guess", nil, protocol.ErrMalformedMessage},
+		{"guess five", nil, protocol.ErrMalformedMessage},
+		{"guess 1 2", nil, protocol.ErrMalformedMessage},
+		{"guess 99999999999", nil, protocol.ErrMalformedMessage},
+		{"hello al bob", nil, protocol.ErrMalformedMessage},
+		{"join", nil, protocol.ErrMalformedMessage},
+		{"top -1", nil, protocol.ErrMalformedMessage},
+		{"ping x", nil, protocol.ErrMalformedMessage},
+		{"create", nil, protocol.ErrMalformedMessage},
+		{"create side max=banana", nil, protocol.ErrMalformedMessage},
+		{"dance", nil, protocol.ErrUnknownMessageType},
+		{"quit", nil, protocol.ErrPeerClosed},
+
+		// Room names are one word, so a name with a space in it is too
+		// many words (and the second one isn't a rule)
+		{"join my room", nil, protocol.ErrMalformedMessage},
+		{"create my room", nil, protocol.ErrMalformedMessage},
+	}
+
+	for _, tc := range cases {
+		msg, err := parseTextCommand(tc.line, game.DefaultSettings())
+		if tc.want == nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%q:  got %#v (%v), want %v", tc.line, msg, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(msg, tc.want) {
+			t.Errorf("%q:  got %#v (%v), want %#v", tc.line, msg, err, tc.want)
+		}
+	}
+
+	// Rules come after the room name, and change the server's rules (not
+	// the built-in ones)
+	defaults := game.DefaultSettings()
+	defaults.MaxGuesses = 7
+	defaults.TurnBased = true
+	msg, err := parseTextCommand("create side min=1 max=10", defaults)
+	create, ok := msg.(*protocol.CreateRoomMessage)
+	if err != nil || !ok || create.Name != "side" {
+		t.Fatalf("got %#v (%v), want to create side", msg, err)
+	}
+	want := defaults
+	want.MinNumber, want.MaxNumber = 1, 10
+	if got := game.SettingsFromWire(create.Settings); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// A text client on the other end of a pipe, which sends lines and
+// hangs up when the test is over
+func textClient(t *testing.T, sent string) *textCodec {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	go clientConn.Write([]byte(sent))
+
+	reader := bufio.NewReaderSize(serverConn, maxTextLine)
+	return &textCodec{conn: &protocol.BufferedConn{Conn: serverConn, Reader: reader}, reader: reader, defaults: RoomDefaults}
+}
+
+func TestTextCodecRead(t *testing.T) {
+	long := strings.Repeat("x", maxTextLine+10)
+	c := textClient(t, "\r\n  guess 5  \r\n"+long+"\njoin a\nb\n")
+
+	// A Hello first, since they didn't send one
+	msg, err := c.ReadMessage(time.Second)
+	if _, ok := msg.(*protocol.HelloMessage); err != nil || !ok {
+		t.Fatalf("got %#v (%v), want a Hello", msg, err)
+	}
+
+	// Then what they did send, without the blank line or spaces
+	msg, err = c.ReadMessage(time.Second)
+	if guess, ok := msg.(*protocol.GuessMessage); err != nil || !ok || guess.Number != 5 {
+		t.Errorf("got %#v (%v), want a guess of 5", msg, err)
+	}
+
+	// A line that's too long is an error, but doesn't stop the lines
+	// after it
+	if _, err := c.ReadMessage(time.Second); !errors.Is(err, protocol.ErrMalformedMessage) {
+		t.Errorf("long line:  got %v, want ErrMalformedMessage", err)
+	}
+
+	// A newline ends the command, wherever it is, so a room name can't
+	// have one in it
+	msg, err = c.ReadMessage(time.Second)
+	if join, ok := msg.(*protocol.JoinRoomMessage); err != nil || !ok || join.Name != "a" {
+		t.Errorf("got %#v (%v), want to join a", msg, err)
+	}
+	if _, err := c.ReadMessage(time.Second); !errors.Is(err, protocol.ErrUnknownMessageType) {
+		t.Errorf("what came after the newline:  got %v, want ErrUnknownMessageType", err)
+	}
+}
@@ -0,0 +1,551 @@
+// Package codec turns Go structs into bytes on the wire and back,
+// following the layout of the struct instead of hand-written Marshal
+// and decode functions.  Fields are encoded in order, with no padding
+// between them, and every multi-byte number is big endian:
+//
+//	bool                      1 byte (0 or 1)
+//	int8, uint8               1 byte
+//	int16, uint16             2 bytes
+//	int32, uint32             4 bytes
+//	int64, uint64             8 bytes
+//	string, []byte            a length prefix, followed by that many bytes
+//	                          (no NUL terminator!)
+//	[]T                       a count prefix, followed by that many T's
+//	[N]T                      N T's, with no prefix
+//	struct                    its fields, in order
+//	*T (optional fields)      1 byte saying whether it's there (0 or 1),
+//	                          followed by the T if it is
+//
+// int and uint aren't allowed, since their size depends on the machine.
+// How a field is encoded can be changed with a wire tag:
+//
+//	Name     string   `wire:"len=8"`     // 1-byte length prefix (8, 16 or 32 bits; default 16)
+//	Extra    *Details `wire:"optional"`  // Pointer fields have to be optional
+//	Round    uint32   `wire:"since=4"`   // Only in version 4 of the layout and later
+//	Internal int      `wire:"-"`         // Not sent at all
+//
+// Marshal and Unmarshal use the newest layout, with every field.  For
+// protocols that add fields over time, MarshalVersion and
+// UnmarshalVersion use an older one, leaving out fields that are newer
+// than it.
+//
+// Structs with no strings, slices or optional fields have a fixed
+// size, which Size reports (for the newest layout), so a reader knows
+// how many bytes to wait for before there's anything to decode.
+//
+// Only golang-sockets uses it.  The older examples (golang-sockets-init
+// and sockets-demo-golang) keep their hand-written Marshal and parse
+// code on purpose, so each of them still builds and reads on its own.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrShortBuffer   = errors.New("payload too short")
+	ErrTrailingBytes = errors.New("unexpected bytes at end of payload")
+	ErrTooLong       = errors.New("too long for its length prefix")
+	ErrInvalidBool   = errors.New("invalid bool")
+	ErrNotFixed      = errors.New("size isn't fixed")
+
+	// The type can't be encoded at all.  This is a programming error,
+	// not something bad on the wire.
+	ErrUnsupportedType = errors.New("unsupported type")
+)
+
+// Length prefix used when a field's tag doesn't pick one
+const defaultLenSize = 2
+
+// How one field is encoded (from its wire tag)
+type options struct {
+	lenSize  int // Bytes in the length prefix of strings and slices
+	optional bool
+	since    int // First version of the layout with this field (0 for all of them)
+}
+
+var defaultOptions = options{lenSize: defaultLenSize}
+
+type fieldInfo struct {
+	index int
+	name  string
+	opts  options
+}
+
+// Fields of each struct type we've seen (reflect.Type -> []fieldInfo),
+// so we only parse tags once per type
+var structCache sync.Map
+
+// The fields of a struct type that go on the wire, in order
+func structFields(t reflect.Type) ([]fieldInfo, error) {
+	if cached, ok := structCache.Load(t); ok {
+		return cached.([]fieldInfo), nil
+	}
+
+	var fields []fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		opts, skip, err := parseTag(f.Tag.Get("wire"))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s:  %w", t.Name(), f.Name, err)
+		}
+		if skip {
+			continue
+		}
+		if !f.IsExported() {
+			return nil, fmt.Errorf("%w:  %s.%s is unexported (tag it wire:\"-\")",
+				ErrUnsupportedType, t.Name(), f.Name)
+		}
+		if opts.optional != (f.Type.Kind() == reflect.Ptr) {
+			return nil, fmt.Errorf("%w:  %s.%s:  pointers have to be optional, and only pointers can be",
+				ErrUnsupportedType, t.Name(), f.Name)
+		}
+		fields = append(fields, fieldInfo{index: i, name: f.Name, opts: opts})
+	}
+
+	structCache.Store(t, fields)
+	return fields, nil
+}
+
+func parseTag(tag string) (opts options, skip bool, err error) {
+	opts = defaultOptions
+	if tag == "" {
+		return opts, false, nil
+	}
+	if tag == "-" {
+		return opts, true, nil
+	}
+
+	for _, part := range strings.Split(tag, ",") {
+		switch {
+		case part == "optional":
+			opts.optional = true
+		case strings.HasPrefix(part, "len="):
+			bits, err := strconv.Atoi(strings.TrimPrefix(part, "len="))
+			if err != nil || (bits != 8 && bits != 16 && bits != 32) {
+				return opts, false, fmt.Errorf("bad length prefix %q (want len=8, 16 or 32)", part)
+			}
+			opts.lenSize = bits / 8
+		case strings.HasPrefix(part, "since="):
+			version, err := strconv.Atoi(strings.TrimPrefix(part, "since="))
+			if err != nil || version < 1 {
+				return opts, false, fmt.Errorf("bad version %q (want since=1 or more)", part)
+			}
+			opts.since = version
+		default:
+			return opts, false, fmt.Errorf("unknown wire tag option %q", part)
+		}
+	}
+	return opts, false, nil
+}
+
+// ************** Encoding **************
+
+// Encode v (a struct, or a pointer to one)
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalVersion(v, 0)
+}
+
+// Encode v with the layout it had in the given version:  fields tagged
+// with a later version are left out.  Version 0 means the newest
+// layout, like Marshal.
+func MarshalVersion(v interface{}, version int) ([]byte, error) {
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil, fmt.Errorf("%w:  nil pointer", ErrUnsupportedType)
+		}
+		val = val.Elem()
+	}
+
+	e := &encoder{version: version}
+	if size, err := typeSize(val.Type()); err == nil {
+		e.buf = make([]byte, 0, size)
+	} else {
+		e.buf = make([]byte, 0, 64)
+	}
+
+	err := e.value(val, defaultOptions)
+	if err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf     []byte
+	version int
+}
+
+func (e *encoder) putUint(v uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[8-size:]...)
+}
+
+func (e *encoder) putLen(n int, size int) error {
+	if uint64(n) > maxLen(size) {
+		return fmt.Errorf("%w:  %d", ErrTooLong, n)
+	}
+	e.putUint(uint64(n), size)
+	return nil
+}
+
+func (e *encoder) value(v reflect.Value, opts options) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.putUint(1, 1)
+		} else {
+			e.putUint(0, 1)
+		}
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.putUint(uint64(v.Int()), int(v.Type().Size()))
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.putUint(v.Uint(), int(v.Type().Size()))
+
+	case reflect.String:
+		if err := e.putLen(v.Len(), opts.lenSize); err != nil {
+			return err
+		}
+		e.buf = append(e.buf, v.String()...)
+
+	case reflect.Slice:
+		if err := checkElement(v.Type().Elem()); err != nil {
+			return err
+		}
+		if err := e.putLen(v.Len(), opts.lenSize); err != nil {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.elements(v)
+
+	case reflect.Array:
+		return e.elements(v)
+
+	case reflect.Struct:
+		fields, err := structFields(v.Type())
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if !inVersion(f.opts, e.version) {
+				continue
+			}
+			err = e.value(v.Field(f.index), f.opts)
+			if err != nil {
+				return fmt.Errorf("%s:  %w", f.name, err)
+			}
+		}
+
+	case reflect.Ptr:
+		if !opts.optional {
+			return fmt.Errorf("%w:  %s", ErrUnsupportedType, v.Type())
+		}
+		if v.IsNil() {
+			e.putUint(0, 1)
+			return nil
+		}
+		e.putUint(1, 1)
+		return e.value(v.Elem(), defaultOptions)
+
+	default:
+		return fmt.Errorf("%w:  %s", ErrUnsupportedType, v.Type())
+	}
+
+	return nil
+}
+
+func (e *encoder) elements(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		err := e.value(v.Index(i), defaultOptions)
+		if err != nil {
+			return fmt.Errorf("[%d]:  %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ************** Decoding **************
+
+// Decode data into v (a pointer to a struct).  The whole of data has
+// to be used:  leftover bytes are an error, like missing ones.
+func Unmarshal(data []byte, v interface{}) error {
+	return UnmarshalVersion(data, v, 0)
+}
+
+// Decode data that has the layout from the given version (see
+// MarshalVersion).  Fields that are newer than that are set to zero.
+func UnmarshalVersion(data []byte, v interface{}, version int) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return fmt.Errorf("%w:  Unmarshal needs a non-nil pointer", ErrUnsupportedType)
+	}
+
+	d := &decoder{buf: data, version: version}
+	err := d.value(val.Elem(), defaultOptions)
+	if err != nil {
+		return err
+	}
+	if len(d.buf) != 0 {
+		return ErrTrailingBytes
+	}
+	return nil
+}
+
+type decoder struct {
+	buf     []byte
+	version int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if len(d.buf) < n {
+		return nil, ErrShortBuffer
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var full [8]byte
+	copy(full[8-size:], b)
+	return binary.BigEndian.Uint64(full[:]), nil
+}
+
+// Read a length (or count) prefix.  Every element is at least one
+// byte, so a length longer than what's left can't be right--checking
+// here means a bad prefix can't make us allocate a huge slice.
+func (d *decoder) len(size int) (int, error) {
+	n, err := d.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)) {
+		return 0, ErrShortBuffer
+	}
+	return int(n), nil
+}
+
+func (d *decoder) value(v reflect.Value, opts options) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		if b > 1 {
+			return fmt.Errorf("%w:  %d", ErrInvalidBool, b)
+		}
+		v.SetBool(b == 1)
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size := int(v.Type().Size())
+		n, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		// Sign extend
+		shift := 64 - 8*size
+		v.SetInt(int64(n<<shift) >> shift)
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := d.uint(int(v.Type().Size()))
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+
+	case reflect.String:
+		n, err := d.len(opts.lenSize)
+		if err != nil {
+			return err
+		}
+		b, _ := d.next(n)
+		v.SetString(string(b))
+
+	case reflect.Slice:
+		if err := checkElement(v.Type().Elem()); err != nil {
+			return err
+		}
+		n, err := d.len(opts.lenSize)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// Empty comes back as nil, like a struct that was never filled in
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := d.next(n)
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		return d.elements(v)
+
+	case reflect.Array:
+		return d.elements(v)
+
+	case reflect.Struct:
+		fields, err := structFields(v.Type())
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if !inVersion(f.opts, d.version) {
+				field := v.Field(f.index)
+				field.Set(reflect.Zero(field.Type()))
+				continue
+			}
+			err = d.value(v.Field(f.index), f.opts)
+			if err != nil {
+				return fmt.Errorf("%s:  %w", f.name, err)
+			}
+		}
+
+	case reflect.Ptr:
+		if !opts.optional {
+			return fmt.Errorf("%w:  %s", ErrUnsupportedType, v.Type())
+		}
+		present, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		switch present {
+		case 0:
+			v.Set(reflect.Zero(v.Type()))
+		case 1:
+			v.Set(reflect.New(v.Type().Elem()))
+			return d.value(v.Elem(), defaultOptions)
+		default:
+			return fmt.Errorf("%w:  %d (for an optional field)", ErrInvalidBool, present)
+		}
+
+	default:
+		return fmt.Errorf("%w:  %s", ErrUnsupportedType, v.Type())
+	}
+
+	return nil
+}
+
+func (d *decoder) elements(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		err := d.value(v.Index(i), defaultOptions)
+		if err != nil {
+			return fmt.Errorf("[%d]:  %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Check if a field is part of the layout in this version (0 for the
+// newest)
+func inVersion(opts options, version int) bool {
+	return version == 0 || opts.since <= version
+}
+
+// Slices can't hold things that take no bytes at all, since then
+// nothing would limit how many of them a count prefix can ask for
+func checkElement(t reflect.Type) error {
+	size, err := typeSize(t)
+	if err == nil && size == 0 {
+		return fmt.Errorf("%w:  slice of %s, which has no size", ErrUnsupportedType, t)
+	}
+	return nil
+}
+
+// ************** Sizes **************
+
+// Size of v's encoding, if every value of its type has the same size
+// (no strings, slices or optional fields).  v can be a struct, or a
+// pointer to one.  Returns an error wrapping ErrNotFixed otherwise.
+func Size(v interface{}) (int, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return 0, fmt.Errorf("%w:  nil", ErrUnsupportedType)
+	}
+	return typeSize(t)
+}
+
+// Like Size, but panics if the size isn't fixed.  Meant for
+// package-level variables, where that would be a programming error.
+func MustSize(v interface{}) int {
+	size, err := Size(v)
+	if err != nil {
+		panic(err)
+	}
+	return size
+}
+
+func typeSize(t reflect.Type) (int, error) {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return 1, nil
+	case reflect.Int16, reflect.Uint16:
+		return 2, nil
+	case reflect.Int32, reflect.Uint32:
+		return 4, nil
+	case reflect.Int64, reflect.Uint64:
+		return 8, nil
+
+	case reflect.String, reflect.Slice, reflect.Ptr:
+		return 0, fmt.Errorf("%w:  %s", ErrNotFixed, t)
+
+	case reflect.Array:
+		size, err := typeSize(t.Elem())
+		if err != nil {
+			return 0, err
+		}
+		return t.Len() * size, nil
+
+	case reflect.Struct:
+		fields, err := structFields(t)
+		if err != nil {
+			return 0, err
+		}
+		total := 0
+		for _, f := range fields {
+			size, err := typeSize(t.Field(f.index).Type)
+			if err != nil {
+				return 0, fmt.Errorf("%s:  %w", f.name, err)
+			}
+			total += size
+		}
+		return total, nil
+	}
+
+	return 0, fmt.Errorf("%w:  %s", ErrUnsupportedType, t)
+}
+
+// Largest length a prefix of this many bytes can hold
+func maxLen(size int) uint64 {
+	switch size {
+	case 1:
+		return math.MaxUint8
+	case 2:
+		return math.MaxUint16
+	}
+	return math.MaxUint32
+}
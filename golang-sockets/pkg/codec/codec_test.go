@@ -0,0 +1,251 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type point struct {
+	X int16
+	Y int16
+}
+
+type fixed struct {
+	A     uint8
+	B     int16
+	C     uint32
+	D     int64
+	E     bool
+	P     point
+	Array [2]uint16
+	Skip  string `wire:"-"`
+}
+
+type details struct {
+	Note string `wire:"len=8"`
+}
+
+type everything struct {
+	I8     int8
+	U16    uint16
+	I32    int32
+	U64    uint64
+	Flag   bool
+	Name   string
+	Short  string `wire:"len=8"`
+	Long   []byte `wire:"len=32"`
+	Data   []byte
+	Points []point
+	Tags   []string `wire:"len=8"`
+	Extra  *details `wire:"optional"`
+	Where  *point   `wire:"optional"`
+	Nested fixed
+}
+
+func TestFixed(t *testing.T) {
+	v := fixed{A: 1, B: -2, C: 3, D: -4, E: true, P: point{5, -6}, Array: [2]uint16{7, 8}, Skip: "not sent"}
+	want := "01" + "fffe" + "00000003" + "fffffffffffffffc" + "01" + "0005fffa" + "00070008"
+
+	data, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(data); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	size, err := Size(v)
+	if err != nil || size != len(data) {
+		t.Errorf("Size is %d (%v), want %d", size, err, len(data))
+	}
+
+	var back fixed
+	if err := Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	v.Skip = ""
+	if back != v {
+		t.Errorf("got %+v, want %+v", back, v)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := []everything{
+		{},
+		{
+			I8: -128, U16: 65535, I32: -1, U64: 1 << 63, Flag: true,
+			Name: "hello", Short: "hi", Long: []byte{1, 2, 3}, Data: []byte{0},
+			Points: []point{{1, 2}, {-3, -4}},
+			Tags:   []string{"a", "", "bc"},
+			Extra:  &details{Note: "note"},
+			Where:  &point{},
+			Nested: fixed{A: 9, Array: [2]uint16{1, 2}},
+		},
+	}
+
+	for _, v := range values {
+		data, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back everything
+		if err := Unmarshal(data, &back); err != nil {
+			t.Fatalf("Unmarshal(%x):  %v", data, err)
+		}
+		if !reflect.DeepEqual(back, v) {
+			t.Errorf("got %+v, want %+v", back, v)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	v := struct {
+		Short string `wire:"len=8"`
+		Long  string `wire:"len=32"`
+		Maybe *point `wire:"optional"`
+		Never *point `wire:"optional"`
+		List  []int32
+	}{"a", "b", &point{1, 2}, nil, []int32{-1}}
+	want := "0161" + "0000000162" + "01" + "00010002" + "00" + "0001" + "ffffffff"
+
+	data, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// Fields added in later versions are left out of older layouts
+func TestVersions(t *testing.T) {
+	type versioned struct {
+		A uint8
+		B uint16 `wire:"since=2"`
+		C uint8  `wire:"since=3"`
+	}
+	v := versioned{1, 2, 3}
+
+	for _, tc := range []struct {
+		version int
+		want    string
+	}{
+		{0, "01000203"},
+		{1, "01"},
+		{2, "010002"},
+		{3, "01000203"},
+		{9, "01000203"},
+	} {
+		data, err := MarshalVersion(&v, tc.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(data); got != tc.want {
+			t.Errorf("version %d:  got %s, want %s", tc.version, got, tc.want)
+		}
+
+		// What isn't in the layout comes back as zero
+		back := versioned{9, 9, 9}
+		if err := UnmarshalVersion(data, &back, tc.version); err != nil {
+			t.Fatalf("version %d:  %v", tc.version, err)
+		}
+		want := v
+		if tc.version == 1 {
+			want = versioned{A: 1}
+		} else if tc.version == 2 {
+			want = versioned{A: 1, B: 2}
+		}
+		if back != want {
+			t.Errorf("version %d:  got %+v, want %+v", tc.version, back, want)
+		}
+	}
+
+	if _, err := Marshal(struct {
+		A uint8 `wire:"since=0"`
+	}{}); err == nil {
+		t.Errorf("since=0 should fail")
+	}
+}
+
+func TestSizeNotFixed(t *testing.T) {
+	for _, v := range []interface{}{everything{}, details{}, struct{ P *point }{}} {
+		if _, err := Size(v); err == nil {
+			t.Errorf("Size(%T) should fail", v)
+		}
+	}
+	if _, err := Size(everything{}); !errors.Is(err, ErrNotFixed) {
+		t.Errorf("got %v, want ErrNotFixed", err)
+	}
+	if size := MustSize(&point{}); size != 4 {
+		t.Errorf("MustSize(point) is %d, want 4", size)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		v    interface{}
+		want error
+	}{
+		{"short", "000102", &point{}, ErrShortBuffer},
+		{"trailing", "0001000200", &point{}, ErrTrailingBytes},
+		{"string too long", "05616263", &details{}, ErrShortBuffer},
+		{"bad bool", "02", &struct{ B bool }{}, ErrInvalidBool},
+		{"bad presence", "02", &struct {
+			P *point `wire:"optional"`
+		}{}, ErrInvalidBool},
+		{"huge count", "ffff0001", &struct{ L []point }{}, ErrShortBuffer},
+		{"not a pointer", "", point{}, ErrUnsupportedType},
+		{"int", "00", &struct{ N int }{}, ErrUnsupportedType},
+		{"pointer without optional", "00", &struct{ P *point }{}, ErrUnsupportedType},
+		{"empty elements", "0001", &struct{ L []struct{} }{}, ErrUnsupportedType},
+	}
+
+	for _, tc := range cases {
+		data, _ := hex.DecodeString(tc.data)
+		err := Unmarshal(data, tc.v)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s:  got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	long := make([]byte, 256)
+	if _, err := Marshal(&details{Note: string(long)}); !errors.Is(err, ErrTooLong) {
+		t.Errorf("got %v, want ErrTooLong", err)
+	}
+	if _, err := Marshal(struct{ N uint }{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("got %v, want ErrUnsupportedType", err)
+	}
+	if _, err := Marshal(struct {
+		S string `wire:"len=12"`
+	}{}); err == nil {
+		t.Errorf("bad tag should fail")
+	}
+}
+
+// Anything that decodes has to encode back to exactly the same bytes
+func FuzzUnmarshal(f *testing.F) {
+	seed, _ := Marshal(everything{Name: "x", Points: []point{{1, 2}}, Extra: &details{"y"}})
+	f.Add(seed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v everything
+		if err := Unmarshal(data, &v); err != nil {
+			return
+		}
+		again, err := Marshal(&v)
+		if err != nil {
+			t.Fatalf("decoded %x, but it doesn't encode:  %v", data, err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Fatalf("decoded %x, but it encodes to %x", data, again)
+		}
+	})
+}
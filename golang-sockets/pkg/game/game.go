@@ -1,116 +1,611 @@
 package game
 
 import (
+	"fmt"
+	"log"
 	"math/rand"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+	"golang-sockets/pkg/stats"
 )
 
 type ClientInfo struct {
 	Id              int
 	Conn            net.Conn
-	GameResetChan   chan bool
+	Outbox          *Outbox        // Everything we send the client (see outbox.go)
+	Codec           protocol.Codec // How we talk to the client (set before the handshake)
 	ServerCloseChan chan bool
+
+	// Set during the handshake
+	Name    string
+	Session *Session
+	Version uint8
+
+	// Room this client is currently playing in, or nil if the client
+	// is in the lobby.  Only changed through the Lobby.
+	Game *GameInfo
+
+	lastActive int64 // When we last heard from the client (UnixNano, atomic)
+
+	// Only used by the client's handler goroutine
+	Limiter *RateLimiter // Limits how fast the client can send messages
+	Strikes int          // How many times the client has misbehaved
 }
 
+// Note that we just heard from the client
+func (ci *ClientInfo) Touch() {
+	atomic.StoreInt64(&ci.lastActive, time.Now().UnixNano())
+}
+
+// How long since we last heard from the client
+func (ci *ClientInfo) IdleTime() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&ci.lastActive)))
+}
+
+// Queue a message for the client.  Never blocks, so it's safe to call
+// while holding any lock.  If the client has fallen so far behind that
+// its outbox overflows (with the Disconnect policy), the client's
+// handler is told to hang up.
+func (ci *ClientInfo) Send(msg protocol.Message) {
+	if !ci.Outbox.Send(msg) && ci.Outbox.Overflowed() {
+		select {
+		case ci.ServerCloseChan <- true:
+		default:
+		}
+	}
+}
+
+// Name to use for this client in logs.  Before the handshake
+// finishes, we don't know the client's name yet.
+func (ci *ClientInfo) String() string {
+	if ci.Name == "" {
+		return fmt.Sprintf("client %d", ci.Id)
+	}
+	return ci.Name
+}
+
+// Totals over every room since the server started.  Fields are
+// updated with sync/atomic, since every room shares one Totals.
+type Totals struct {
+	RoundsPlayed int64
+	RoundsWon    int64
+	Guesses      int64
+	RoundGuesses int64 // Guesses in rounds that are over (for guesses per round)
+}
+
+// State for one game (aka "room").  Each room has its own target
+// number, and its own list of clients that get reset broadcasts.
 type GameInfo struct {
+	Name     string
+	Settings Settings // Protected by GameLock, since admins can change it
+
+	// New rules for the next round (nil if they're not changing), so
+	// the current round is played to the end under the rules it
+	// started with.  Protected by GameLock.
+	nextSettings *Settings
+
 	GameLock      sync.Mutex
 	TotalGuesses  int
+	PlayerGuesses map[string]int // Guesses this round, by player name
 	TargetNumber  int32
-	nextClientIdx int // Counter to increment each time we add a new client
 
-	ClientListLock  sync.Mutex
-	Clients         []*ClientInfo
-	ClientWaitGroup sync.WaitGroup
+	ClientListLock sync.Mutex
+	Clients        []*ClientInfo
+
+	// Where to record round results (nil to not keep stats)
+	Stats *stats.Store
+
+	// Where to count rounds and guesses (nil to not count them)
+	Totals *Totals
+
+	// Who to tell about everything that happens (nil if nobody)
+	Spectators *Spectators
+
+	// Where to record everything that happens (nil to not record it)
+	Journal *journal.Journal
+
+	// Targets are picked by the room's own random number generator, so
+	// a journal (which has the seed) can be replayed exactly
+	Seed int64
+	rand *rand.Rand // Protected by GameLock
+
+	round      uint32      // Round ID:  counts rounds, so stale guesses and timers can tell they're stale
+	roundTimer *time.Timer // Ends the round when time is up (nil if no limit)
+
+	// Turn-based play (see turns.go), protected by GameLock
+	turn      *ClientInfo // Who can guess now (nil if anyone can)
+	turnSeq   uint64      // Counts turns, like round
+	turnTimer *time.Timer // Skips the player if they take too long
 }
 
 const (
 	GuessTooHigh = 1
 	GuessCorrect = 0
 	GuessTooLow  = -1
+
+	// The guess was for a round that's already over (so it wasn't counted)
+	GuessRoundOver = 2
 )
 
-func InitializeGame() *GameInfo {
-	return &GameInfo{
+func InitializeGame(name string, settings Settings) *GameInfo {
+	return InitializeGameWithSeed(name, settings, rand.Int63())
+}
+
+// Like InitializeGame, but with a specific seed for the room's targets
+// (to replay a journal, say)
+func InitializeGameWithSeed(name string, settings Settings, seed int64) *GameInfo {
+	g := &GameInfo{
 		// Other fields initialized to zero
-		TargetNumber: rand.Int31n(8192),
+		Name:          name,
+		Settings:      settings,
+		PlayerGuesses: make(map[string]int),
+		round:         1,
+		Seed:          seed,
+		rand:          rand.New(rand.NewSource(seed)),
 	}
+	g.TargetNumber = g.newTarget()
+	log.Printf("Room %s:  target number is %d\n", g.Name, g.TargetNumber)
+	g.startTimerLocked()
+
+	return g
 }
 
-func (g *GameInfo) NewClient(conn net.Conn) *ClientInfo {
-	clientIndex := g.nextClientIdx
-	g.nextClientIdx++
+func (g *GameInfo) newTarget() int32 {
+	s := g.Settings
+	return s.MinNumber + g.rand.Int31n(s.MaxNumber-s.MinNumber)
+}
 
-	ci := &ClientInfo{
-		Id:              clientIndex,
-		Conn:            conn,
-		GameResetChan:   make(chan bool, 1),
-		ServerCloseChan: make(chan bool, 1),
+// If the room has a time limit, start the clock for the current round.
+// Should only be called when GameLock is held (or before anyone else
+// can see the room).
+func (g *GameInfo) startTimerLocked() {
+	if g.roundTimer != nil {
+		g.roundTimer.Stop()
+		g.roundTimer = nil
+	}
+	if g.Settings.TimeLimit <= 0 {
+		return
 	}
-	g.ClientWaitGroup.Add(1)
+
+	round := g.round
+	g.roundTimer = time.AfterFunc(g.Settings.TimeLimit, func() {
+		g.GameLock.Lock()
+		defer g.GameLock.Unlock()
+
+		if g.round != round {
+			return // The round already ended some other way
+		}
+		if g.NumClients() == 0 {
+			// Nobody's here to care, so just keep the same target
+			g.startTimerLocked()
+			return
+		}
+		log.Printf("Room %s:  time is up\n", g.Name)
+		g.endRoundLocked(nil, protocol.RoundOverTimeUp)
+	})
+}
+
+// Stop the room's timer, when the room is closed
+func (g *GameInfo) Close() {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	g.recordLocked(journal.Event{Type: journal.Close})
+	g.round++
+	if g.roundTimer != nil {
+		g.roundTimer.Stop()
+		g.roundTimer = nil
+	}
+	g.turnSeq++
+	if g.turnTimer != nil {
+		g.turnTimer.Stop()
+		g.turnTimer = nil
+	}
+}
+
+// Add a client to this room's list, so it gets reset broadcasts, and
+// tell the client it's in the room (before any broadcasts can reach it)
+func (g *GameInfo) AddClient(ci *ClientInfo) {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	g.sendJoinedLocked(ci)
 
 	g.ClientListLock.Lock()
 	g.Clients = append(g.Clients, ci)
 	g.ClientListLock.Unlock()
 
-	return ci
+	g.reportLocked(&protocol.GameEventMessage{Event: protocol.GameEventJoined, Player: ci.Name})
+	g.recordLocked(journal.Event{Type: journal.Join, Client: ci.Id, Player: ci.Name})
+
+	if !g.Settings.TurnBased {
+		return
+	}
+	if g.turn == nil {
+		// Nobody could take a turn before, but now somebody can
+		g.passTurnLocked(g.clientIndex(ci), "")
+	} else {
+		// Let the new player know whose turn it is
+		ci.Send(g.turnMessageLocked(""))
+	}
 }
 
-func (g *GameInfo) RemoveClient(target *ClientInfo) {
+// Tell a client it's in the room, and what the rules are.  Should only
+// be called when GameLock is held.
+func (g *GameInfo) sendJoinedLocked(ci *ClientInfo) {
+	// The client might not be on the list yet, but it's in the room
+	var players []string
+	joined := false
 	g.ClientListLock.Lock()
-	var idx int
+	for _, other := range g.Clients {
+		players = append(players, other.Name)
+		joined = joined || other == ci
+	}
+	g.ClientListLock.Unlock()
+	if !joined {
+		players = append(players, ci.Name)
+	}
 
-	// Find the client by its pointer and remove it from the list
-	// TODO:  There might be a better way to handle this process,
-	// comments welcome!
+	ci.Send(&protocol.RoomJoinedMessage{
+		Name:     g.Name,
+		Settings: g.Settings.Wire(),
+		Round:    g.round,
+		Players:  players,
+	})
+	ci.Send(g.RulesMessage(g.Settings))
+}
+
+// Remove a client from this room's list
+func (g *GameInfo) RemoveClient(target *ClientInfo) {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
 
+	g.ClientListLock.Lock()
+	// Find the client by its pointer and remove it from the list
+	idx := -1
 	for i, ci := range g.Clients {
 		if ci == target {
+			g.Clients = append(g.Clients[:i], g.Clients[i+1:]...)
 			idx = i
+			break
 		}
 	}
-
-	g.Clients = append(g.Clients[:idx], g.Clients[idx+1:]...)
-
 	g.ClientListLock.Unlock()
 
-	g.ClientWaitGroup.Done()
+	if idx >= 0 {
+		g.reportLocked(&protocol.GameEventMessage{Event: protocol.GameEventLeft, Player: target.Name})
+		g.recordLocked(journal.Event{Type: journal.Leave, Client: target.Id, Player: target.Name})
+	}
+
+	// If it was the client's turn, the next player (who is now at the
+	// same index) goes next
+	if idx >= 0 && g.turn == target {
+		g.passTurnLocked(idx, "")
+	}
 }
 
-func (g *GameInfo) TerminateClients() {
+// Number of clients currently in this room
+func (g *GameInfo) NumClients() int {
 	g.ClientListLock.Lock()
-	for _, ci := range g.Clients {
-		ci.ServerCloseChan <- true
-	}
-	g.ClientListLock.Unlock()
+	defer g.ClientListLock.Unlock()
 
-	// Wait for all clients to be done
-	g.ClientWaitGroup.Wait()
+	return len(g.Clients)
+}
+
+// End the current round and start a new one.  Everyone in the room
+// first gets a summary of the round that just ended, then a new game
+// message.  winner is nil if nobody guessed the number.
+func (g *GameInfo) ResetGame(winner *ClientInfo) {
+	reason := uint8(protocol.RoundOverWon)
+	if winner == nil {
+		reason = protocol.RoundOverReset
+	}
+	g.EndRound(winner, reason)
 }
 
-func (g *GameInfo) ResetGame() {
+// Like ResetGame, but also says why the round ended (one of the
+// protocol.RoundOver* reasons)
+func (g *GameInfo) EndRound(winner *ClientInfo, reason uint8) {
 	g.GameLock.Lock()
 	defer g.GameLock.Unlock()
 
-	g.TargetNumber = rand.Int31()
+	g.endRoundLocked(winner, reason)
+}
+
+func (g *GameInfo) endRoundLocked(winner *ClientInfo, reason uint8) {
+	summary := g.roundSummary(winner)
+	summary.Reason = reason
+	g.Broadcast(summary)
+	g.reportLocked(&protocol.GameEventMessage{
+		Event:   protocol.GameEventRoundOver,
+		Player:  summary.Winner,
+		Number:  summary.Target,
+		Result:  int32(reason),
+		Guesses: summary.TotalGuesses,
+	})
+
+	if g.Stats != nil {
+		// Only copied into the Store here; it's written out later, so
+		// we don't wait for the disk while holding GameLock
+		g.Stats.RecordRound(summary.Winner, g.PlayerGuesses)
+	}
+
+	rulesChanged := g.nextSettings != nil
+	if rulesChanged {
+		g.Settings = *g.nextSettings
+		g.nextSettings = nil
+	}
+
+	g.TargetNumber = g.newTarget()
+	g.recordLocked(journal.Event{
+		Type:       journal.Reset,
+		Number:     journal.Int32(summary.Target),
+		Result:     journal.ResetReason(reason),
+		Winner:     summary.Winner,
+		Guesses:    g.TotalGuesses,
+		NextTarget: journal.Int32(g.TargetNumber),
+	})
+
+	if g.Totals != nil {
+		atomic.AddInt64(&g.Totals.RoundsPlayed, 1)
+		atomic.AddInt64(&g.Totals.RoundGuesses, int64(g.TotalGuesses))
+		if winner != nil {
+			atomic.AddInt64(&g.Totals.RoundsWon, 1)
+		}
+	}
 	g.TotalGuesses = 0
+	g.PlayerGuesses = make(map[string]int)
+	g.round++
+	g.startTimerLocked()
+	log.Printf("Room %s:  new game, target number is %d\n", g.Name, g.TargetNumber)
+
+	// Everyone learns the new rules before the round they're for
+	if rulesChanged {
+		g.Broadcast(g.RulesMessage(g.Settings))
+	}
+	g.Broadcast(&protocol.GuessMessage{
+		MessageType: protocol.MessageTypeNewGame,
+		Number:      0,
+		Round:       g.round,
+	})
+
+	// Whoever made the last guess doesn't get to go first again
+	g.nextTurnLocked()
+}
+
+// Send a message to every client in the room.  This only queues the
+// message for each client, so one slow client can't hold up the room,
+// and it's fine to broadcast while holding GameLock.
+func (g *GameInfo) Broadcast(msg protocol.Message) {
+	g.ClientListLock.Lock()
+	defer g.ClientListLock.Unlock()
 
 	for _, c := range g.Clients {
-		c.GameResetChan <- true
+		c.Send(msg)
+	}
+}
+
+// Build the round over message for the current round.  Should only be
+// called when GameLock is held.
+func (g *GameInfo) roundSummary(winner *ClientInfo) *protocol.RoundOverMessage {
+	summary := &protocol.RoundOverMessage{
+		Round:        g.round,
+		Target:       g.TargetNumber,
+		TotalGuesses: uint32(g.TotalGuesses),
+	}
+	if winner != nil {
+		summary.Winner = winner.Name
+	}
+
+	for name, guesses := range g.PlayerGuesses {
+		summary.Players = append(summary.Players, protocol.PlayerGuesses{
+			Name:    name,
+			Guesses: uint32(guesses),
+		})
+	}
+
+	// Fewest guesses first
+	sort.Slice(summary.Players, func(i, j int) bool {
+		a, b := summary.Players[i], summary.Players[j]
+		if a.Guesses != b.Guesses {
+			return a.Guesses < b.Guesses
+		}
+		return a.Name < b.Name
+	})
+
+	return summary
+}
+
+// Rules for the round going on now (not ones waiting for the next
+// round)
+func (g *GameInfo) CurrentSettings() Settings {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	return g.Settings
+}
+
+// ID of the round going on now
+func (g *GameInfo) Round() uint32 {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	return g.round
+}
+
+func (g *GameInfo) Target() int32 {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	return g.TargetNumber
+}
+
+// Change the target for the current round (for admins)
+func (g *GameInfo) SetTarget(n int32) error {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	if !g.Settings.InRange(n) {
+		return ErrBadRange
+	}
+	g.TargetNumber = n
+	g.recordLocked(journal.Event{Type: journal.SetTarget, Number: journal.Int32(n)})
+
+	return nil
+}
+
+// Change the rules (for admins).  The new rules take effect when the
+// next round starts (which is also when everyone in the room hears
+// about them), so callers will usually want to ResetGame afterward.
+func (g *GameInfo) SetSettings(settings Settings) error {
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	g.nextSettings = &settings
+	wire := settings.Wire()
+	g.recordLocked(journal.Event{Type: journal.SetRules, Settings: &wire})
+
+	return nil
+}
+
+// Build the message announcing the room's rules
+func (g *GameInfo) RulesMessage(settings Settings) *protocol.RulesMessage {
+	return &protocol.RulesMessage{
+		Room:     g.Name,
+		Settings: settings.Wire(),
 	}
 }
 
-func (g *GameInfo) DoGuess(n int32) int32 {
+// How many guesses a player has made in the current round
+func (g *GameInfo) GuessesThisRound(name string) int {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	return g.PlayerGuesses[name]
+}
+
+// Check a guess against the target, and queue the response for the
+// client.  round is the round the client thinks it's guessing in (0
+// for whatever the current round is):  if that round is already over,
+// the guess isn't counted, and the response is GuessRoundOver.
+//
+// Everything happens under GameLock, so nobody else can guess in
+// between:  a correct guess (or the last guess anyone had left) ends the
+// round and starts the next one before the lock is released.  The
+// response goes out before anything the guess leads to (like the round
+// summary, or the next player's turn).
+//
+// Returns ErrNoGuessesLeft (without counting the guess) if the player
+// has already used up this round's guesses, ErrNotYourTurn if it's
+// somebody else's turn, or ErrOutOfRange if the target can't be that
+// number.
+func (g *GameInfo) DoGuess(ci *ClientInfo, n int32, round uint32) (int32, error) {
 	g.GameLock.Lock()
 	defer g.GameLock.Unlock()
 
+	g.recordLocked(journal.Event{
+		Type:       journal.Guess,
+		Client:     ci.Id,
+		Player:     ci.Name,
+		Number:     journal.Int32(n),
+		GuessRound: round,
+	})
+
+	if round != 0 && round != g.round {
+		ci.Send(&protocol.GuessMessage{
+			MessageType: protocol.MessageTypeResponse,
+			Number:      GuessRoundOver,
+			Round:       round,
+		})
+		g.recordResponseLocked(ci, GuessRoundOver, nil)
+		return GuessRoundOver, nil
+	}
+	err := g.checkGuessLocked(ci, n)
+	if err != nil {
+		g.recordResponseLocked(ci, 0, err)
+		return 0, err
+	}
+
 	g.TotalGuesses++
+	g.PlayerGuesses[ci.Name]++
+	if g.Totals != nil {
+		atomic.AddInt64(&g.Totals.Guesses, 1)
+	}
 
+	result := int32(GuessCorrect)
 	if n < g.TargetNumber {
-		return GuessTooLow
+		result = GuessTooLow
 	} else if n > g.TargetNumber {
-		return GuessTooHigh
-	} else {
-		return GuessCorrect
+		result = GuessTooHigh
+	}
+	ci.Send(&protocol.GuessMessage{
+		MessageType: protocol.MessageTypeResponse,
+		Number:      result,
+		Round:       g.round,
+	})
+	g.recordResponseLocked(ci, result, nil)
+	g.reportLocked(&protocol.GameEventMessage{
+		Event:  protocol.GameEventGuess,
+		Player: ci.Name,
+		Number: n,
+		Result: result,
+	})
+
+	if result == GuessCorrect {
+		log.Printf("Room %s:  %s wins!\n", g.Name, ci)
+		g.endRoundLocked(ci, protocol.RoundOverWon)
+	} else if g.outOfGuessesLocked() {
+		// If that was the last guess anyone had left, don't make
+		// everyone wait for the round to time out
+		log.Printf("Room %s:  everyone is out of guesses\n", g.Name)
+		g.endRoundLocked(nil, protocol.RoundOverNoGuesses)
+	} else if g.Settings.TurnBased {
+		g.nextTurnLocked()
+	}
+
+	return result, nil
+}
+
+// Check if the client can make this guess now.  Should only be called
+// when GameLock is held.
+func (g *GameInfo) checkGuessLocked(ci *ClientInfo, n int32) error {
+	if !g.Settings.InRange(n) {
+		return fmt.Errorf("%w:  guess a number from %d to %d", ErrOutOfRange,
+			g.Settings.MinNumber, g.Settings.MaxNumber-1)
+	}
+	if max := g.Settings.MaxGuesses; max > 0 && g.PlayerGuesses[ci.Name] >= max {
+		return ErrNoGuessesLeft
+	}
+	return g.checkTurnLocked(ci)
+}
+
+// Check if everyone in the room has used up their guesses for this
+// round, in which case there's no point waiting for it to end.  Should
+// only be called when GameLock is held.
+func (g *GameInfo) outOfGuessesLocked() bool {
+	max := g.Settings.MaxGuesses
+	if max <= 0 {
+		return false
+	}
+
+	g.ClientListLock.Lock()
+	defer g.ClientListLock.Unlock()
+
+	for _, ci := range g.Clients {
+		if g.PlayerGuesses[ci.Name] < max {
+			return false
+		}
 	}
+	return len(g.Clients) > 0
 }
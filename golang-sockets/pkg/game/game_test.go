@@ -0,0 +1,249 @@
+package game
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"golang-sockets/pkg/protocol"
+)
+
+var testSettings = Settings{MinNumber: 0, MaxNumber: 100}
+
+// A client that isn't connected to anything.  Whatever it's sent stays
+// in its outbox, for the test to look at.
+func testClient(id int, name string) *ClientInfo {
+	return &ClientInfo{
+		Id:              id,
+		Name:            name,
+		Outbox:          NewOutbox(1000, DropOldest),
+		ServerCloseChan: make(chan bool, 1),
+	}
+}
+
+// Take everything that's been sent to ci so far
+func sent(ci *ClientInfo) []protocol.Message {
+	ci.Outbox.lock.Lock()
+	defer ci.Outbox.lock.Unlock()
+
+	msgs := ci.Outbox.queue
+	ci.Outbox.queue = nil
+	return msgs
+}
+
+// A room (with a fixed seed) with these clients in it, who have
+// already been told they're in it
+func testRoom(settings Settings, clients ...*ClientInfo) *GameInfo {
+	g := InitializeGameWithSeed("test", settings, 1)
+	for _, ci := range clients {
+		g.AddClient(ci)
+		sent(ci)
+	}
+	return g
+}
+
+func TestGuess(t *testing.T) {
+	al := testClient(1, "al")
+	g := testRoom(testSettings, al)
+	defer g.Close()
+
+	target := g.Target()
+	cases := []struct {
+		guess int32
+		want  int32
+	}{
+		{target - 1, GuessTooLow},
+		{target + 1, GuessTooHigh},
+		{target, GuessCorrect},
+	}
+	for _, tc := range cases {
+		if tc.guess < 0 || tc.guess >= 100 {
+			continue
+		}
+		result, err := g.DoGuess(al, tc.guess, 1)
+		if err != nil || result != tc.want {
+			t.Errorf("guess %d (target %d):  got %d (%v), want %d", tc.guess, target, result, err, tc.want)
+		}
+	}
+
+	if _, err := g.DoGuess(al, 100, 0); err == nil {
+		t.Errorf("guess out of range:  no error")
+	}
+}
+
+// Guesses for a round that's over aren't counted, whatever they are
+func TestGuessStaleRound(t *testing.T) {
+	al := testClient(1, "al")
+	g := testRoom(testSettings, al)
+	defer g.Close()
+
+	g.ResetGame(nil)
+	sent(al)
+
+	result, err := g.DoGuess(al, g.Target(), 1)
+	if err != nil || result != GuessRoundOver {
+		t.Fatalf("got %d (%v), want GuessRoundOver", result, err)
+	}
+	if g.Round() != 2 || g.GuessesThisRound("al") != 0 {
+		t.Errorf("the guess was counted")
+	}
+
+	msgs := sent(al)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want just the response", len(msgs))
+	}
+	resp, ok := msgs[0].(*protocol.GuessMessage)
+	if !ok || resp.MessageType != protocol.MessageTypeResponse || resp.Number != GuessRoundOver || resp.Round != 1 {
+		t.Errorf("got %#v, want a GuessRoundOver response for round 1", msgs[0])
+	}
+}
+
+// When everyone guesses the number at once, only one of them wins,
+// and the others are told they were too late
+func TestOneWinner(t *testing.T) {
+	var clients []*ClientInfo
+	for i := 0; i < 20; i++ {
+		clients = append(clients, testClient(i, string(rune('a'+i))))
+	}
+	g := testRoom(testSettings, clients...)
+	defer g.Close()
+
+	target := g.Target()
+	results := make([]int32, len(clients))
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i, ci := range clients {
+		wg.Add(1)
+		go func(i int, ci *ClientInfo) {
+			defer wg.Done()
+			<-start
+			var err error
+			results[i], err = g.DoGuess(ci, target, 1)
+			if err != nil {
+				t.Errorf("%s:  %v", ci, err)
+			}
+		}(i, ci)
+	}
+	close(start)
+	wg.Wait()
+
+	winners := 0
+	for i, result := range results {
+		switch result {
+		case GuessCorrect:
+			winners++
+		case GuessRoundOver:
+		default:
+			t.Errorf("%s got %d", clients[i], result)
+		}
+	}
+	if winners != 1 {
+		t.Errorf("%d winners, want 1", winners)
+	}
+	if g.Round() != 2 {
+		t.Errorf("round is %d, want 2", g.Round())
+	}
+}
+
+// The winner hears that its guess was right before it hears the round
+// is over, and everyone gets the summary before the new round starts
+func TestResponseBeforeSummary(t *testing.T) {
+	al, bob := testClient(1, "al"), testClient(2, "bob")
+	g := testRoom(testSettings, al, bob)
+	defer g.Close()
+
+	target := g.Target()
+	if _, err := g.DoGuess(al, target, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := sent(al)
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	if resp, ok := msgs[0].(*protocol.GuessMessage); !ok || resp.MessageType != protocol.MessageTypeResponse || resp.Number != GuessCorrect {
+		t.Errorf("first message:  got %#v, want the response", msgs[0])
+	}
+	summary, ok := msgs[1].(*protocol.RoundOverMessage)
+	if !ok || summary.Winner != "al" || summary.Target != target || summary.Round != 1 {
+		t.Errorf("second message:  got %#v, want al winning round 1", msgs[1])
+	}
+	if newGame, ok := msgs[2].(*protocol.GuessMessage); !ok || newGame.MessageType != protocol.MessageTypeNewGame || newGame.Round != 2 {
+		t.Errorf("third message:  got %#v, want round 2 starting", msgs[2])
+	}
+
+	msgs = sent(bob)
+	if len(msgs) != 2 {
+		t.Fatalf("bob got %d messages, want 2", len(msgs))
+	}
+	if _, ok := msgs[0].(*protocol.RoundOverMessage); !ok {
+		t.Errorf("bob's first message:  got %#v, want the summary", msgs[0])
+	}
+}
+
+// New rules wait for the next round, and the round they start with
+// plays by them from the beginning
+func TestSetSettings(t *testing.T) {
+	al, bob := testClient(1, "al"), testClient(2, "bob")
+	g := testRoom(testSettings, al, bob)
+	defer g.Close()
+
+	next := Settings{MinNumber: 1000, MaxNumber: 1010, TurnBased: true}
+	if err := g.SetSettings(next); err != nil {
+		t.Fatal(err)
+	}
+	if g.CurrentSettings() != testSettings || !testSettings.InRange(g.Target()) {
+		t.Fatalf("the rules changed in the middle of the round")
+	}
+	if _, err := g.DoGuess(bob, 5, 0); err != nil {
+		t.Errorf("guessing under the old rules:  %v", err)
+	}
+	if len(sent(bob)) != 1 {
+		t.Errorf("bob heard about the new rules before they took effect")
+	}
+
+	g.ResetGame(nil)
+	if g.CurrentSettings() != next {
+		t.Errorf("got rules %v, want %v", g.CurrentSettings(), next)
+	}
+	if target := g.Target(); !next.InRange(target) {
+		t.Errorf("target %d isn't in the new range", target)
+	}
+	if turn := whoseTurn(g); turn != "al" {
+		t.Errorf("%q has the turn in the new turn-based round, want al", turn)
+	}
+
+	// The rules come before the round they're for
+	var order []string
+	for _, msg := range sent(bob) {
+		switch m := msg.(type) {
+		case *protocol.RulesMessage:
+			if m.Settings != next.Wire() {
+				t.Errorf("told rules %v, want %v", m.Settings, next.Wire())
+			}
+			order = append(order, "rules")
+		case *protocol.GuessMessage:
+			order = append(order, "new game")
+		}
+	}
+	if len(order) != 2 || order[0] != "rules" || order[1] != "new game" {
+		t.Errorf("bob heard %v, want [rules new game]", order)
+	}
+}
+
+// Someone joining hears who's already there
+func TestJoinedPlayers(t *testing.T) {
+	al, bob, cy := testClient(1, "al"), testClient(2, "bob"), testClient(3, "cy")
+	g := testRoom(testSettings, al, bob)
+	defer g.Close()
+
+	g.AddClient(cy)
+	msgs := sent(cy)
+	if len(msgs) == 0 {
+		t.Fatalf("cy wasn't told anything")
+	}
+	joined, ok := msgs[0].(*protocol.RoomJoinedMessage)
+	if !ok || !reflect.DeepEqual(joined.Players, []string{"al", "bob", "cy"}) {
+		t.Errorf("got %#v, want al, bob and cy in the room", msgs[0])
+	}
+}
@@ -0,0 +1,40 @@
+package game
+
+import (
+	"golang-sockets/pkg/journal"
+)
+
+// Record an event in the room's journal (see pkg/journal), with the
+// room's name and current round.  Should only be called when GameLock
+// is held (or before anybody else can see the room).
+func (g *GameInfo) recordLocked(ev journal.Event) {
+	ev.Room = g.Name
+	ev.Round = g.round
+	g.Journal.Record(ev)
+}
+
+// Record what we told a client about its guess:  a result, or an error
+func (g *GameInfo) recordResponseLocked(ci *ClientInfo, result int32, err error) {
+	ev := journal.Event{Type: journal.Response, Client: ci.Id, Player: ci.Name}
+	if err != nil {
+		ev.Error = err.Error()
+	} else {
+		ev.Result = JournalResult(result)
+	}
+	g.recordLocked(ev)
+}
+
+// How a guess result (GuessTooHigh, ...) is written in the journal
+func JournalResult(result int32) string {
+	switch result {
+	case GuessTooHigh:
+		return journal.TooHigh
+	case GuessTooLow:
+		return journal.TooLow
+	case GuessCorrect:
+		return journal.Correct
+	case GuessRoundOver:
+		return journal.TooLate
+	}
+	return "unknown"
+}
@@ -0,0 +1,565 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+	"golang-sockets/pkg/stats"
+)
+
+const (
+	// Name of the room the server always creates at startup.  It's never
+	// removed, even when nobody is in it.
+	DefaultRoomName = "main"
+
+	MaxPlayerNameLength = 32
+	MaxRoomNameLength   = 32
+
+	// How long Shutdown waits after closing connections by force
+	forceCloseTimeout = time.Second
+)
+
+var (
+	ErrNoSuchRoom = errors.New("no such room")
+	ErrRoomExists = errors.New("room already exists")
+	ErrBadName    = errors.New("invalid name")
+	ErrBadRange   = errors.New("invalid number range")
+	ErrNameTaken  = errors.New("name is already in use")
+
+	ErrBadSettings   = errors.New("invalid settings")
+	ErrNoGuessesLeft = errors.New("no guesses left this round")
+	ErrNotYourTurn   = errors.New("it's not your turn")
+	ErrOutOfRange    = errors.New("guess is out of range")
+)
+
+// The lobby keeps track of every connected client, and every room.
+// Clients start out in the lobby (ci.Game == nil), and can then
+// create, join or leave rooms.
+type Lobby struct {
+	RoomLock sync.Mutex
+	Rooms    map[string]*GameInfo
+
+	nextClientIdx int // Counter to increment each time we add a new client
+
+	ClientListLock sync.Mutex
+	Clients        []*ClientInfo
+
+	// One for each client from NewClient, until whoever handles it is
+	// completely finished with it (including its connection), which
+	// is later than RemoveClient
+	ClientWaitGroup sync.WaitGroup
+
+	// Player statistics, shared by every room
+	Stats  *stats.Store
+	Totals Totals
+
+	// Clients watching rooms instead of playing (see spectators.go)
+	Spectators *Spectators
+
+	// Where to record everything that happens (nil to not record it)
+	Journal *journal.Journal
+
+	// Sessions by resume token, guarded by ClientListLock (see session.go)
+	sessions     map[string]*Session
+	ResumeWindow time.Duration // How long to hold sessions after a disconnect
+
+	// Rate limit for each new client (see ratelimit.go)
+	RateLimit float64 // Messages per second, 0 for no limit
+	RateBurst int
+
+	// Outbox settings for each new client (see outbox.go)
+	QueueSize int // 0 for DefaultQueueSize
+	Overflow  OverflowPolicy
+}
+
+// Summary of a room, for listing rooms to clients
+type RoomSummary struct {
+	Name       string
+	Settings   Settings
+	NumPlayers int
+}
+
+// Start a lobby, whose default room uses the given settings.  j can be
+// nil, if we're not keeping a journal.
+func NewLobby(statsStore *stats.Store, j *journal.Journal, settings Settings, resumeWindow time.Duration) *Lobby {
+	l := &Lobby{
+		Rooms:        make(map[string]*GameInfo),
+		Stats:        statsStore,
+		Spectators:   NewSpectators(),
+		Journal:      j,
+		sessions:     make(map[string]*Session),
+		ResumeWindow: resumeWindow,
+	}
+	l.Rooms[DefaultRoomName] = l.newRoom(DefaultRoomName, settings)
+
+	return l
+}
+
+func (l *Lobby) newRoom(name string, settings Settings) *GameInfo {
+	g := InitializeGame(name, settings)
+	g.Stats = l.Stats
+	g.Totals = &l.Totals
+	g.Spectators = l.Spectators
+	g.Journal = l.Journal
+
+	// Nobody else can see the room yet, so no need for GameLock
+	wire := settings.Wire()
+	g.recordLocked(journal.Event{
+		Type:     journal.Room,
+		Number:   journal.Int32(g.TargetNumber),
+		Seed:     journal.Int64(g.Seed),
+		Settings: &wire,
+	})
+
+	return g
+}
+
+func (l *Lobby) NewClient(conn net.Conn) *ClientInfo {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	clientIndex := l.nextClientIdx
+	l.nextClientIdx++
+
+	ci := &ClientInfo{
+		Id:              clientIndex,
+		Conn:            conn,
+		Outbox:          NewOutbox(l.QueueSize, l.Overflow),
+		ServerCloseChan: make(chan bool, 1),
+		Limiter:         NewRateLimiter(l.RateLimit, l.RateBurst),
+	}
+	ci.Touch()
+	l.ClientWaitGroup.Add(1)
+
+	l.Clients = append(l.Clients, ci)
+
+	return ci
+}
+
+// Finish the handshake for a client.  If resumeToken matches a session
+// we're holding on to, the client takes over that session (and its
+// name).  Otherwise, we check the client's name (or pick one if it
+// didn't ask for one) and start a new session.  Returns true if an old
+// session was resumed, and the room it was in (see RestoreRoom).
+func (l *Lobby) RegisterClient(ci *ClientInfo, name string, version uint8, resumeToken string) (bool, string, error) {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	ci.Version = version
+
+	if sess := l.findSessionLocked(resumeToken); sess != nil {
+		if old := sess.client; old != nil {
+			// The old connection is probably dead, and we just
+			// haven't noticed yet.  Either way, it's not needed now.
+			select {
+			case old.ServerCloseChan <- true:
+			default:
+			}
+		}
+		sess.client = ci
+		ci.Name = sess.Name
+		ci.Session = sess
+		l.recordConnect(ci, true)
+
+		return true, sess.Room, nil
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("player-%d", ci.Id)
+	}
+	if !validName(name, MaxPlayerNameLength) {
+		return false, "", fmt.Errorf("%w:  %q", ErrBadName, name)
+	}
+	if l.nameInUseLocked(name, nil) {
+		return false, "", fmt.Errorf("%w:  %q", ErrNameTaken, name)
+	}
+
+	sess := &Session{
+		Token:  newResumeToken(),
+		Id:     rand.Uint32(),
+		Name:   name,
+		client: ci,
+	}
+	l.sessions[sess.Token] = sess
+	ci.Name = name
+	ci.Session = sess
+	l.recordConnect(ci, false)
+
+	return false, "", nil
+}
+
+func (l *Lobby) recordConnect(ci *ClientInfo, resumed bool) {
+	l.Journal.Record(journal.Event{
+		Type:    journal.Connect,
+		Client:  ci.Id,
+		Player:  ci.Name,
+		Address: ci.Conn.RemoteAddr().String(),
+		Resumed: resumed,
+	})
+}
+
+// Names (of players and rooms) show up in everyone's terminal, and as
+// single words in text protocol lines, so keep them short, printable
+// and without spaces
+func validName(name string, maxLength int) bool {
+	if name == "" || len(name) > maxLength {
+		return false
+	}
+	for _, c := range name {
+		if !unicode.IsPrint(c) || unicode.IsSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove a client from the server entirely (leaving its room first)
+func (l *Lobby) RemoveClient(target *ClientInfo) {
+	l.detachSession(target)
+	l.LeaveRoom(target)
+
+	l.ClientListLock.Lock()
+	for i, ci := range l.Clients {
+		if ci == target {
+			l.Clients = append(l.Clients[:i], l.Clients[i+1:]...)
+			break
+		}
+	}
+	l.ClientListLock.Unlock()
+
+	if target.Session != nil {
+		l.Journal.Record(journal.Event{Type: journal.Disconnect, Client: target.Id, Player: target.Name})
+	}
+}
+
+// Find a connected client by name (or nil)
+func (l *Lobby) FindClient(name string) *ClientInfo {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	for _, ci := range l.Clients {
+		if ci.Name == name {
+			return ci
+		}
+	}
+	return nil
+}
+
+// Get a copy of the list of connected clients
+func (l *Lobby) ListClients() []*ClientInfo {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	return append([]*ClientInfo{}, l.Clients...)
+}
+
+// A connected client, and where it was when ClientRooms was called
+type ClientRoom struct {
+	Client *ClientInfo
+	Name   string // As ci.String() would say it
+	Room   string // Room the client is in ("" for the lobby)
+}
+
+// Get every connected client, with the room it's in.  Clients only
+// change rooms under RoomLock, so (unlike reading ci.Game from another
+// goroutine) the rooms are all as they were at one moment.
+func (l *Lobby) ClientRooms() []ClientRoom {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	list := make([]ClientRoom, 0, len(l.Clients))
+	for _, ci := range l.Clients {
+		cr := ClientRoom{Client: ci, Name: ci.String()}
+		if ci.Game != nil {
+			cr.Room = ci.Game.Name
+		}
+		list = append(list, cr)
+	}
+	return list
+}
+
+// Disconnect a client, and forget its session so it can't resume it
+func (l *Lobby) Kick(ci *ClientInfo) {
+	l.ClientListLock.Lock()
+	if ci.Session != nil {
+		delete(l.sessions, ci.Session.Token)
+	}
+	l.ClientListLock.Unlock()
+
+	select {
+	case ci.ServerCloseChan <- true:
+	default:
+	}
+}
+
+// Find a room by name (or nil)
+func (l *Lobby) Room(name string) *GameInfo {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	return l.Rooms[name]
+}
+
+// Disconnect every client, because the server is shutting down.  Each
+// one is told why, and its reader is woken up (by a read deadline in
+// the past), so its handler can send what's left in its outbox and
+// hang up.  If they aren't all gone by the time ctx is done, we close
+// the rest of the connections ourselves, which unblocks anything still
+// stuck on the socket, and give them forceCloseTimeout more.
+func (l *Lobby) Shutdown(ctx context.Context, reason string) error {
+	for _, ci := range l.ListClients() {
+		ci.Send(&protocol.ShutdownMessage{Reason: reason})
+		ci.Conn.SetReadDeadline(time.Now())
+		select {
+		case ci.ServerCloseChan <- true:
+		default: // If there's already a close pending, that's good enough
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.ClientWaitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	clients := l.ListClients()
+	for _, ci := range clients {
+		ci.Conn.Close()
+	}
+
+	timer := time.NewTimer(forceCloseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return fmt.Errorf("%w (closed %d connection(s))", ctx.Err(), len(clients))
+	case <-timer.C:
+		return fmt.Errorf("%w (%d client(s) still won't go away)", ctx.Err(), len(l.ListClients()))
+	}
+}
+
+// List all rooms, sorted by name
+func (l *Lobby) ListRooms() []RoomSummary {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	rooms := make([]RoomSummary, 0, len(l.Rooms))
+	for _, g := range l.Rooms {
+		rooms = append(rooms, RoomSummary{
+			Name:       g.Name,
+			Settings:   g.CurrentSettings(),
+			NumPlayers: g.NumClients(),
+		})
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	return rooms
+}
+
+// Create a new room and move the client into it
+func (l *Lobby) CreateRoom(ci *ClientInfo, name string, settings Settings) (*GameInfo, error) {
+	if !validName(name, MaxRoomNameLength) {
+		return nil, fmt.Errorf("%w:  %q", ErrBadName, name)
+	}
+	err := settings.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Holding RoomLock until the client is in, so nobody can close the
+	// room while it's empty
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	if _, exists := l.Rooms[name]; exists {
+		return nil, ErrRoomExists
+	}
+	g := l.newRoom(name, settings)
+	l.Rooms[name] = g
+	l.moveClientLocked(ci, g)
+
+	return g, nil
+}
+
+// Move the client into an existing room
+func (l *Lobby) JoinRoom(ci *ClientInfo, name string) (*GameInfo, error) {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	g, ok := l.Rooms[name]
+	if !ok {
+		return nil, ErrNoSuchRoom
+	}
+	l.moveClientLocked(ci, g)
+
+	return g, nil
+}
+
+// Put a client that resumed its session back in the session's room
+// (as RegisterClient returned it).  If the session was taken over from
+// a connection that's still in the room, the player never really left,
+// so the new connection just takes the old one's place, and nobody is
+// told anyone left or joined.
+func (l *Lobby) RestoreRoom(ci *ClientInfo, room string) (*GameInfo, error) {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	g, ok := l.Rooms[room]
+	if !ok {
+		return nil, ErrNoSuchRoom
+	}
+
+	if old := l.previousConnection(ci); old != nil && g.clientIndex(old) >= 0 {
+		// old.Game is left alone, since old's handler may still be
+		// using it.  When it leaves, it won't be in the room any more
+		// (and moveClientLocked won't say it left).
+		g.RemoveClient(old)
+		ci.Game = g
+		l.updateSessionRoom(ci, g)
+		g.AddClient(ci)
+		return g, nil
+	}
+
+	l.moveClientLocked(ci, g)
+	return g, nil
+}
+
+// The connection that had ci's session before it (if it's still
+// connected)
+func (l *Lobby) previousConnection(ci *ClientInfo) *ClientInfo {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	for _, other := range l.Clients {
+		if other != ci && other.Session != nil && other.Session == ci.Session {
+			return other
+		}
+	}
+	return nil
+}
+
+// Check if another connection has taken over ci's session, so the
+// player is still around even though ci is going
+func (l *Lobby) sessionTakenOver(ci *ClientInfo) bool {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	return ci.Session != nil && ci.Session.client != nil && ci.Session.client != ci
+}
+
+// Move the client back to the lobby.  Does nothing if the client
+// isn't in a room.
+func (l *Lobby) LeaveRoom(ci *ClientInfo) {
+	l.moveClient(ci, nil)
+}
+
+// Take the client out of its room (if any), and make it a spectator of
+// the named room ("" for every room).  The room has to exist when the
+// client starts watching it, but it's fine if it closes later:  the
+// client just hears nothing until a room with that name opens again.
+func (l *Lobby) Spectate(ci *ClientInfo, room string) error {
+	if room != "" && l.Room(room) == nil {
+		return ErrNoSuchRoom
+	}
+
+	l.moveClient(ci, nil)
+	l.Spectators.Add(ci, room)
+
+	return nil
+}
+
+// Take the client out of its current room (if any) and put it in the
+// new one (or in the lobby, if g is nil).  Rooms other than the default
+// room are closed when the last client leaves.
+func (l *Lobby) moveClient(ci *ClientInfo, g *GameInfo) {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	l.moveClientLocked(ci, g)
+}
+
+// Should only be called when RoomLock is held (and g was looked up
+// under the same hold, so it can't have been closed since)
+func (l *Lobby) moveClientLocked(ci *ClientInfo, g *GameInfo) {
+	// Going anywhere (even back to the lobby) means the client is done
+	// spectating
+	l.Spectators.Remove(ci)
+
+	old := ci.Game
+	if old == g {
+		if g != nil {
+			// Nothing to do, but the client still expects an answer
+			g.GameLock.Lock()
+			g.sendJoinedLocked(ci)
+			g.GameLock.Unlock()
+		}
+		return
+	}
+
+	if old != nil {
+		old.RemoveClient(ci)
+		if !l.sessionTakenOver(ci) {
+			old.Broadcast(&protocol.PlayerEventMessage{
+				Event: protocol.PlayerEventLeft,
+				Name:  ci.Name,
+			})
+		}
+
+		l.closeRoomIfUnusedLocked(old)
+	}
+
+	ci.Game = g
+	l.updateSessionRoom(ci, g)
+	if g != nil {
+		g.Broadcast(&protocol.PlayerEventMessage{
+			Event: protocol.PlayerEventJoined,
+			Name:  ci.Name,
+		})
+		g.AddClient(ci)
+	}
+}
+
+// Rooms other than the default room are closed once nobody is in them,
+// unless someone who was in the room might still resume their session.
+// Should only be called when RoomLock is held.  Returns true if the
+// room was closed.
+func (l *Lobby) closeRoomIfUnusedLocked(g *GameInfo) bool {
+	if g.Name == DefaultRoomName || g.NumClients() > 0 || l.roomWanted(g.Name) {
+		return false
+	}
+
+	delete(l.Rooms, g.Name)
+	g.Close()
+	return true
+}
+
+// Close a room if nobody is using it any more.  Rooms that were kept
+// open for a detached session are checked again once it expires (see
+// detachSession).
+func (l *Lobby) closeRoomIfUnused(name string) {
+	l.RoomLock.Lock()
+	defer l.RoomLock.Unlock()
+
+	if g, ok := l.Rooms[name]; ok {
+		l.closeRoomIfUnusedLocked(g)
+	}
+}
@@ -0,0 +1,69 @@
+package game
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Room names end up as single words in text protocol lines, so ones
+// with spaces (or anything else unprintable) are turned away
+func TestCreateRoomNames(t *testing.T) {
+	l := NewLobby(nil, nil, testSettings, time.Minute)
+	al := connect(t, l)
+	if _, _, err := l.RegisterClient(al, "al", 4, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "my room", "a\nb", "tab\there", "bell\a", strings.Repeat("x", MaxRoomNameLength+1)} {
+		if _, err := l.CreateRoom(al, name, testSettings); !errors.Is(err, ErrBadName) {
+			t.Errorf("%q:  got %v, want ErrBadName", name, err)
+		}
+		if l.Room(name) != nil {
+			t.Errorf("%q:  the room was opened anyway", name)
+		}
+	}
+
+	if _, err := l.CreateRoom(al, "side-2", testSettings); err != nil {
+		t.Errorf("side-2:  %v", err)
+	}
+}
+
+func TestClientRooms(t *testing.T) {
+	l := NewLobby(nil, nil, testSettings, time.Minute)
+	al, bob := connect(t, l), connect(t, l)
+	for _, c := range []struct {
+		ci   *ClientInfo
+		name string
+	}{{al, "al"}, {bob, "bob"}} {
+		if _, _, err := l.RegisterClient(c.ci, c.name, 4, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.CreateRoom(al, "side", testSettings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving around while someone's looking shouldn't race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			l.JoinRoom(bob, DefaultRoomName)
+			l.LeaveRoom(bob)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		l.ClientRooms()
+	}
+	<-done
+
+	rooms := map[string]string{}
+	for _, cr := range l.ClientRooms() {
+		rooms[cr.Name] = cr.Room
+	}
+	if len(rooms) != 2 || rooms["al"] != "side" || rooms["bob"] != "" {
+		t.Errorf("got %v, want al in side and bob in the lobby", rooms)
+	}
+}
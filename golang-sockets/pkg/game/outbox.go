@@ -0,0 +1,190 @@
+package game
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// Every message to a client goes through the client's outbox, a
+// bounded queue that a dedicated writer goroutine (Run) drains onto the
+// socket.  Putting a message in the outbox never blocks, so a slow or
+// stuck client can't hold up whoever is sending to it--in particular,
+// broadcasts can be sent while holding GameLock.
+//
+// If a client falls so far behind that its outbox fills up, the
+// overflow policy decides what happens.
+type OverflowPolicy int
+
+const (
+	DropOldest OverflowPolicy = iota // Throw away the oldest queued message
+	Disconnect                       // Give up on the client
+)
+
+// Default number of messages each outbox can hold
+const DefaultQueueSize = 64
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "drop-oldest":
+		return DropOldest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return DropOldest, fmt.Errorf("unknown overflow policy %q (want drop-oldest or disconnect)", s)
+}
+
+func (p OverflowPolicy) String() string {
+	if p == Disconnect {
+		return "disconnect"
+	}
+	return "drop-oldest"
+}
+
+type Outbox struct {
+	lock   sync.Mutex
+	cond   *sync.Cond // Signaled when a message is queued, or the outbox closes
+	queue  []protocol.Message
+	size   int
+	policy OverflowPolicy
+
+	closed     bool // No more messages will be accepted
+	overflowed bool // Closed because the client couldn't keep up
+	dropped    int  // Messages thrown away by DropOldest
+
+	done chan struct{} // Closed when Run returns
+}
+
+func NewOutbox(size int, policy OverflowPolicy) *Outbox {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	o := &Outbox{
+		size:   size,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	o.cond = sync.NewCond(&o.lock)
+
+	return o
+}
+
+// Queue a message for the client.  Never blocks.  Returns false if the
+// message couldn't be queued because the outbox is closed, or just
+// overflowed with the Disconnect policy.
+func (o *Outbox) Send(msg protocol.Message) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.closed {
+		return false
+	}
+
+	if len(o.queue) >= o.size {
+		if o.policy == Disconnect {
+			o.closed = true
+			o.overflowed = true
+			o.queue = nil
+			o.cond.Signal()
+			return false
+		}
+		o.queue = o.queue[1:]
+		o.dropped++
+	}
+
+	o.queue = append(o.queue, msg)
+	o.cond.Signal()
+
+	return true
+}
+
+// Stop accepting messages.  Run keeps going until it has written
+// everything that's already queued.
+func (o *Outbox) Close() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.closed = true
+	o.cond.Signal()
+}
+
+// Closed when the writer is done
+func (o *Outbox) Done() <-chan struct{} {
+	return o.done
+}
+
+// Number of messages waiting to be written
+func (o *Outbox) Len() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return len(o.queue)
+}
+
+// Number of messages thrown away so far
+func (o *Outbox) Dropped() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.dropped
+}
+
+// Check if the outbox gave up on the client because it fell too far behind
+func (o *Outbox) Overflowed() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.overflowed
+}
+
+// Wait for the next message to write.  Returns false once the outbox is
+// closed and empty.
+func (o *Outbox) next() (protocol.Message, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for len(o.queue) == 0 && !o.closed {
+		o.cond.Wait()
+	}
+	if len(o.queue) == 0 {
+		return nil, false
+	}
+
+	msg := o.queue[0]
+	o.queue[0] = nil // Let the message be garbage collected
+	o.queue = o.queue[1:]
+
+	return msg, true
+}
+
+// Writer loop:  write queued messages to conn (using codec) until the
+// outbox is closed and empty, or a write fails.  If timeout is nonzero,
+// each write has to finish within that long.  Meant to run in its own
+// goroutine.
+func (o *Outbox) Run(conn net.Conn, codec protocol.Codec, timeout time.Duration) error {
+	defer close(o.done)
+
+	for {
+		msg, ok := o.next()
+		if !ok {
+			return nil
+		}
+
+		if timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		err := codec.WriteMessage(msg)
+		if err != nil {
+			// No point sending anything else
+			o.lock.Lock()
+			o.closed = true
+			o.queue = nil
+			o.lock.Unlock()
+
+			return err
+		}
+	}
+}
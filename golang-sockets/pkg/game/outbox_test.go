@@ -0,0 +1,95 @@
+package game
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+func ping(seq uint32) protocol.Message {
+	return &protocol.PingMessage{MessageType: protocol.MessageTypePing, Seq: seq}
+}
+
+func TestOutboxDropOldest(t *testing.T) {
+	o := NewOutbox(3, DropOldest)
+	for seq := uint32(1); seq <= 5; seq++ {
+		if !o.Send(ping(seq)) {
+			t.Fatalf("message %d wasn't queued", seq)
+		}
+	}
+	if o.Dropped() != 2 || o.Overflowed() {
+		t.Errorf("dropped %d (overflowed %v), want 2 dropped", o.Dropped(), o.Overflowed())
+	}
+
+	// The newest ones are the ones left
+	o.Close()
+	for want := uint32(3); want <= 5; want++ {
+		msg, ok := o.next()
+		if !ok || msg.(*protocol.PingMessage).Seq != want {
+			t.Fatalf("got %#v, want ping %d", msg, want)
+		}
+	}
+	if _, ok := o.next(); ok {
+		t.Errorf("a closed outbox should be empty once it's drained")
+	}
+}
+
+func TestOutboxDisconnect(t *testing.T) {
+	ci := testClient(1, "al")
+	ci.Outbox = NewOutbox(3, Disconnect)
+
+	for seq := uint32(1); seq <= 3; seq++ {
+		ci.Send(ping(seq))
+	}
+	select {
+	case <-ci.ServerCloseChan:
+		t.Fatalf("hung up on a client that kept up")
+	default:
+	}
+
+	ci.Send(ping(4))
+	if !ci.Outbox.Overflowed() {
+		t.Errorf("the outbox didn't overflow")
+	}
+	select {
+	case <-ci.ServerCloseChan:
+	default:
+		t.Errorf("the client's handler wasn't told to hang up")
+	}
+
+	// Nothing more goes to a client we've given up on
+	if ci.Outbox.Send(ping(5)) || ci.Outbox.Len() != 0 {
+		t.Errorf("an overflowed outbox still has (or takes) messages")
+	}
+}
+
+// Run writes everything that was queued before Close, then stops
+func TestOutboxRun(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	o := NewOutbox(0, DropOldest)
+	for seq := uint32(1); seq <= 3; seq++ {
+		o.Send(ping(seq))
+	}
+	o.Close()
+
+	go o.Run(serverConn, &protocol.FrameCodec{Conn: serverConn}, time.Second)
+
+	for want := uint32(1); want <= 3; want++ {
+		msg, err := protocol.ReadMessage(clientConn, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := msg.(*protocol.PingMessage); !ok || p.Seq != want {
+			t.Fatalf("got %#v, want ping %d", msg, want)
+		}
+	}
+	select {
+	case <-o.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Run didn't return")
+	}
+}
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"time"
+)
+
+// A token bucket rate limiter:  the bucket holds up to Burst tokens,
+// and refills at Rate tokens per second.  Each message takes one
+// token, so a client can send a quick burst of messages, but can't
+// keep sending faster than Rate.
+//
+// Each client's limiter is only used by that client's handler
+// goroutine, so there's no lock.
+type RateLimiter struct {
+	Rate  float64 // Tokens per second, 0 for no limit
+	Burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		Rate:   rate,
+		Burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Take a token if there is one.  Returns false if the caller should
+// be slowed down.
+func (rl *RateLimiter) Allow() bool {
+	if rl.Rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.Rate
+	if rl.tokens > rl.Burst {
+		rl.tokens = rl.Burst
+	}
+	rl.last = now
+
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
@@ -0,0 +1,47 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+// How many tokens the limiter hands out right now
+func drainTokens(rl *RateLimiter) int {
+	n := 0
+	for rl.Allow() {
+		n++
+		if n > 1000 {
+			break
+		}
+	}
+	return n
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(10, 5)
+
+	// A burst goes through, and then nothing does
+	if n := drainTokens(rl); n != 5 {
+		t.Fatalf("burst of %d, want 5", n)
+	}
+
+	// The bucket refills at the rate, but never past the burst.
+	// Instead of waiting, pretend the last message was a while ago.
+	rl.last = rl.last.Add(-300 * time.Millisecond)
+	if n := drainTokens(rl); n != 3 {
+		t.Errorf("after 300ms:  %d messages, want 3", n)
+	}
+	rl.last = rl.last.Add(-time.Hour)
+	if n := drainTokens(rl); n != 5 {
+		t.Errorf("after an hour:  %d messages, want 5", n)
+	}
+}
+
+func TestRateLimiterOff(t *testing.T) {
+	rl := NewRateLimiter(0, 0)
+	for i := 0; i < 1000; i++ {
+		if !rl.Allow() {
+			t.Fatalf("message %d was limited", i)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// Per-room settings (aka the rules of the game).  The default room gets
+// the server's settings, and new rooms can ask for their own.
+type Settings struct {
+	MinNumber  int32 // Target is picked from [MinNumber, MaxNumber)
+	MaxNumber  int32
+	MaxGuesses int           // Guesses each player gets per round, 0 for no limit
+	TimeLimit  time.Duration // How long each round lasts, 0 for no limit
+
+	TurnBased   bool          // Players take turns guessing (see turns.go)
+	TurnTimeout time.Duration // How long each turn lasts, 0 for no limit
+}
+
+// Settings used when nothing else is configured
+func DefaultSettings() Settings {
+	return Settings{
+		MinNumber:   0,
+		MaxNumber:   8192,
+		TurnTimeout: 30 * time.Second,
+	}
+}
+
+// Check that the settings make sense
+func (s Settings) Validate() error {
+	if s.MinNumber < 0 || s.MaxNumber <= s.MinNumber {
+		return fmt.Errorf("%w:  %d-%d", ErrBadRange, s.MinNumber, s.MaxNumber-1)
+	}
+	if s.MaxGuesses < 0 {
+		return fmt.Errorf("%w:  max guesses can't be negative", ErrBadSettings)
+	}
+	if s.TimeLimit < 0 {
+		return fmt.Errorf("%w:  time limit can't be negative", ErrBadSettings)
+	}
+	if s.TimeLimit > 0 && s.TimeLimit < time.Second {
+		return fmt.Errorf("%w:  time limit must be at least one second", ErrBadSettings)
+	}
+	if s.TurnTimeout < 0 || (s.TurnTimeout > 0 && s.TurnTimeout < time.Second) {
+		return fmt.Errorf("%w:  turn timeout must be 0 or at least one second", ErrBadSettings)
+	}
+	return nil
+}
+
+// Check if n is a number the target could be
+func (s Settings) InRange(n int32) bool {
+	return n >= s.MinNumber && n < s.MaxNumber
+}
+
+// Convert settings into their wire format
+func (s Settings) Wire() protocol.RoomSettings {
+	return protocol.RoomSettings{
+		MinNumber:   s.MinNumber,
+		MaxNumber:   s.MaxNumber,
+		MaxGuesses:  uint32(s.MaxGuesses),
+		TimeLimitMs: uint32(s.TimeLimit.Milliseconds()),
+
+		TurnBased:     s.TurnBased,
+		TurnTimeoutMs: uint32(s.TurnTimeout.Milliseconds()),
+	}
+}
+
+// Convert settings from their wire format
+func SettingsFromWire(s protocol.RoomSettings) Settings {
+	return Settings{
+		MinNumber:  s.MinNumber,
+		MaxNumber:  s.MaxNumber,
+		MaxGuesses: int(s.MaxGuesses),
+		TimeLimit:  time.Duration(s.TimeLimitMs) * time.Millisecond,
+
+		TurnBased:   s.TurnBased,
+		TurnTimeout: time.Duration(s.TurnTimeoutMs) * time.Millisecond,
+	}
+}
+
+// The rules file is JSON, like this (every field is optional):
+//
+//	{
+//	    "min": 0,
+//	    "max": 100,
+//	    "max_guesses": 10,
+//	    "time_limit": "2m",
+//	    "turn_based": true,
+//	    "turn_timeout": "30s"
+//	}
+type settingsFile struct {
+	Min         *int32 `json:"min"`
+	Max         *int32 `json:"max"`
+	MaxGuesses  *int   `json:"max_guesses"`
+	TimeLimit   string `json:"time_limit"`
+	TurnBased   *bool  `json:"turn_based"`
+	TurnTimeout string `json:"turn_timeout"`
+}
+
+// Load settings from a rules file.  Anything the file leaves out is
+// taken from base.
+func LoadSettings(path string, base Settings) (Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return base, err
+	}
+
+	var f settingsFile
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return base, fmt.Errorf("%s:  %w", path, err)
+	}
+
+	s := base
+	if f.Min != nil {
+		s.MinNumber = *f.Min
+	}
+	if f.Max != nil {
+		s.MaxNumber = *f.Max
+	}
+	if f.MaxGuesses != nil {
+		s.MaxGuesses = *f.MaxGuesses
+	}
+	if f.TimeLimit != "" {
+		s.TimeLimit, err = time.ParseDuration(f.TimeLimit)
+		if err != nil {
+			return base, fmt.Errorf("%s:  time_limit:  %w", path, err)
+		}
+	}
+	if f.TurnBased != nil {
+		s.TurnBased = *f.TurnBased
+	}
+	if f.TurnTimeout != "" {
+		s.TurnTimeout, err = time.ParseDuration(f.TurnTimeout)
+		if err != nil {
+			return base, fmt.Errorf("%s:  turn_timeout:  %w", path, err)
+		}
+	}
+
+	return s, s.Validate()
+}
+
+// Parse rules given as words, like "max=100 guesses=5 time=1m" (from
+// the client's /create command, say).  Anything they leave out is taken
+// from base.  A bare number is the max, since that's all /create used
+// to take.
+func ParseRules(args []string, base Settings) (Settings, error) {
+	settings := base
+
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			key, value = "max", arg
+		}
+
+		var err error
+		switch key {
+		case "min", "max":
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 32)
+			if key == "min" {
+				settings.MinNumber = int32(n)
+			} else {
+				settings.MaxNumber = int32(n)
+			}
+		case "guesses":
+			settings.MaxGuesses, err = strconv.Atoi(value)
+		case "time":
+			settings.TimeLimit, err = time.ParseDuration(value)
+		case "turns":
+			settings.TurnBased = value == "on"
+			if value != "on" && value != "off" {
+				err = fmt.Errorf("turns must be on or off")
+			}
+		case "turntime":
+			settings.TurnTimeout, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown rule %s", key)
+		}
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, settings.Validate()
+}
+
+// Describe the settings for people
+func (s Settings) String() string {
+	str := fmt.Sprintf("numbers %d-%d", s.MinNumber, s.MaxNumber-1)
+	if s.MaxGuesses > 0 {
+		str += fmt.Sprintf(", %d guess(es) per round", s.MaxGuesses)
+	}
+	if s.TimeLimit > 0 {
+		str += fmt.Sprintf(", %v per round", s.TimeLimit)
+	}
+	if s.TurnBased {
+		str += ", take turns"
+		if s.TurnTimeout > 0 {
+			str += fmt.Sprintf(" (%v per turn)", s.TurnTimeout)
+		}
+	}
+	return str
+}
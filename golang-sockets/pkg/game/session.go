@@ -0,0 +1,126 @@
+package game
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// A session outlives the connection it was created on:  when a client
+// disconnects, we hold on to its session for a while (the lobby's
+// ResumeWindow), so the client can reconnect with the session's
+// resume token and pick up where it left off.
+type Session struct {
+	Token string
+	Id    uint32
+	Name  string
+
+	// Room the client is in, so we can put it back there when it resumes
+	Room string
+
+	client  *ClientInfo // Client using this session, nil if detached
+	expires time.Time   // When a detached session is forgotten
+}
+
+// Resume tokens are secrets (anyone with the token can take over the
+// session), so they come from crypto/rand, not math/rand
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Find a session that can be resumed with this token.  Should only be
+// called when ClientListLock is held.
+func (l *Lobby) findSessionLocked(token string) *Session {
+	if token == "" {
+		return nil
+	}
+
+	sess, ok := l.sessions[token]
+	if !ok {
+		return nil
+	}
+	if sess.client == nil && time.Now().After(sess.expires) {
+		delete(l.sessions, token)
+		return nil
+	}
+	return sess
+}
+
+// Check if any session (connected or not) is using this name.  Should
+// only be called when ClientListLock is held.
+func (l *Lobby) nameInUseLocked(name string, except *Session) bool {
+	for token, sess := range l.sessions {
+		if sess == except || sess.Name != name {
+			continue
+		}
+		if sess.client == nil && time.Now().After(sess.expires) {
+			delete(l.sessions, token)
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Check if a detached session is waiting to go back to a room, so we
+// shouldn't close it even if it's empty.
+func (l *Lobby) roomWanted(name string) bool {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	for _, sess := range l.sessions {
+		if sess.client == nil && sess.Room == name && time.Now().Before(sess.expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// Called when a client goes away:  keep its session around for
+// ResumeWindow (or forget it right away).
+func (l *Lobby) detachSession(ci *ClientInfo) {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	sess := ci.Session
+	if sess == nil || sess.client != ci {
+		// Never finished the handshake, or another connection has
+		// already taken over this session
+		return
+	}
+
+	sess.client = nil
+
+	if l.ResumeWindow > 0 {
+		sess.expires = time.Now().Add(l.ResumeWindow)
+
+		// If the session's room is only kept open for it, close the
+		// room once nobody can come back to it
+		if room := sess.Room; room != "" {
+			time.AfterFunc(l.ResumeWindow, func() { l.closeRoomIfUnused(room) })
+		}
+	} else {
+		delete(l.sessions, sess.Token)
+	}
+}
+
+// Remember which room a client is in.  Called with RoomLock held.
+func (l *Lobby) updateSessionRoom(ci *ClientInfo, g *GameInfo) {
+	l.ClientListLock.Lock()
+	defer l.ClientListLock.Unlock()
+
+	sess := ci.Session
+	if sess == nil || sess.client != ci {
+		return
+	}
+
+	sess.Room = ""
+	if g != nil {
+		sess.Room = g.Name
+	}
+}
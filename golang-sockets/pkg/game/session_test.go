@@ -0,0 +1,160 @@
+package game
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// A client that has connected to l, but not finished the handshake
+func connect(t *testing.T, l *Lobby) *ClientInfo {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	return l.NewClient(serverConn)
+}
+
+func TestResume(t *testing.T) {
+	l := NewLobby(nil, nil, testSettings, time.Minute)
+
+	al := connect(t, l)
+	if resumed, _, err := l.RegisterClient(al, "al", 4, ""); err != nil || resumed {
+		t.Fatalf("got %v (%v), want a new session", resumed, err)
+	}
+	token := al.Session.Token
+	if _, err := l.CreateRoom(al, "side", testSettings); err != nil {
+		t.Fatal(err)
+	}
+	l.RemoveClient(al)
+
+	// The room waits for al to come back, and so does al's name
+	if l.Room("side") == nil {
+		t.Fatalf("al's room closed while al could still come back")
+	}
+	if _, _, err := l.RegisterClient(connect(t, l), "al", 4, ""); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("taking al's name:  got %v, want ErrNameTaken", err)
+	}
+
+	// A token that isn't al's doesn't get al's session
+	other := connect(t, l)
+	if resumed, _, err := l.RegisterClient(other, "", 4, token+"x"); err != nil || resumed || other.Name == "al" {
+		t.Errorf("bad token:  got %v as %s (%v), want a new session", resumed, other, err)
+	}
+
+	again := connect(t, l)
+	resumed, room, err := l.RegisterClient(again, "someone-else", 4, token)
+	if err != nil || !resumed {
+		t.Fatalf("got %v (%v), want al's session back", resumed, err)
+	}
+	if again.Name != "al" || room != "side" {
+		t.Errorf("resumed as %s in room %q, want al in side", again, room)
+	}
+
+	// Resuming a session that's still connected somewhere else hangs
+	// up on the old connection
+	newer := connect(t, l)
+	if resumed, _, _ := l.RegisterClient(newer, "", 4, token); !resumed {
+		t.Fatalf("couldn't take over al's session")
+	}
+	select {
+	case <-again.ServerCloseChan:
+	default:
+		t.Errorf("the old connection wasn't told to hang up")
+	}
+}
+
+func TestResumeExpires(t *testing.T) {
+	l := NewLobby(nil, nil, testSettings, 50*time.Millisecond)
+
+	al := connect(t, l)
+	if _, _, err := l.RegisterClient(al, "al", 4, ""); err != nil {
+		t.Fatal(err)
+	}
+	token := al.Session.Token
+	if _, err := l.CreateRoom(al, "side", testSettings); err != nil {
+		t.Fatal(err)
+	}
+	l.RemoveClient(al)
+
+	time.Sleep(100 * time.Millisecond)
+	if l.Room("side") != nil {
+		t.Errorf("al's room is still open after al's session expired")
+	}
+
+	bob := connect(t, l)
+	resumed, _, err := l.RegisterClient(bob, "bob", 4, token)
+	if err != nil || resumed || bob.Name != "bob" {
+		t.Errorf("got %v as %s (%v), want a new session for bob", resumed, bob, err)
+	}
+	if _, _, err := l.RegisterClient(connect(t, l), "al", 4, ""); err != nil {
+		t.Errorf("al's name is still taken:  %v", err)
+	}
+}
+
+// Taking over a session that's still in a room swaps the connections,
+// without anyone hearing that the player left (or joined)
+func TestTakeOver(t *testing.T) {
+	l := NewLobby(nil, nil, testSettings, time.Minute)
+
+	al, bob := connect(t, l), connect(t, l)
+	if _, _, err := l.RegisterClient(al, "al", 4, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.RegisterClient(bob, "bob", 4, ""); err != nil {
+		t.Fatal(err)
+	}
+	g, err := l.CreateRoom(al, "side", testSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.JoinRoom(bob, "side"); err != nil {
+		t.Fatal(err)
+	}
+	sent(bob)
+
+	newer := connect(t, l)
+	_, room, err := l.RegisterClient(newer, "", 4, al.Session.Token)
+	if err != nil || room != "side" {
+		t.Fatalf("got room %q (%v), want side", room, err)
+	}
+	if _, err := l.RestoreRoom(newer, room); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the new connection is in the room, even before the old one
+	// has gone
+	if g.clientIndex(al) >= 0 || g.clientIndex(newer) < 0 || g.NumClients() != 2 {
+		t.Errorf("the room has the wrong connections for al")
+	}
+	l.RemoveClient(al)
+	if g.NumClients() != 2 {
+		t.Errorf("%d client(s) in the room after the old connection left, want 2", g.NumClients())
+	}
+
+	for _, msg := range sent(bob) {
+		if ev, ok := msg.(*protocol.PlayerEventMessage); ok {
+			t.Errorf("bob heard %#v, but al never left", ev)
+		}
+	}
+}
+
+// Kicked players can't come back
+func TestKickForgetsSession(t *testing.T) {
+	l := NewLobby(nil, nil, testSettings, time.Minute)
+
+	al := connect(t, l)
+	if _, _, err := l.RegisterClient(al, "al", 4, ""); err != nil {
+		t.Fatal(err)
+	}
+	l.Kick(al)
+	l.RemoveClient(al)
+
+	if resumed, _, _ := l.RegisterClient(connect(t, l), "", 4, al.Session.Token); resumed {
+		t.Errorf("resumed a kicked player's session")
+	}
+}
@@ -0,0 +1,88 @@
+package game
+
+import (
+	"sync"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// Spectators watch rooms without playing in them.  They get a
+// GameEventMessage for every guess, join, leave and round result in the
+// room they're watching, but they're never in a room's Clients list,
+// so they never get a turn, show up in round results, or get any of
+// the room's broadcasts.
+//
+// Events are reported while holding GameLock, so lock comes after
+// GameLock (and ClientListLock) in the lock order.
+type Spectators struct {
+	lock     sync.Mutex
+	watching map[*ClientInfo]string // Room each spectator watches ("" for every room)
+}
+
+func NewSpectators() *Spectators {
+	return &Spectators{watching: make(map[*ClientInfo]string)}
+}
+
+// Start sending a client the events for a room ("" for every room)
+func (s *Spectators) Add(ci *ClientInfo, room string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.watching[ci] = room
+}
+
+// Stop sending a client events.  Returns true if it was spectating.
+func (s *Spectators) Remove(ci *ClientInfo) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.watching[ci]
+	delete(s.watching, ci)
+	return ok
+}
+
+// Which room a client is watching ("" for every room).  ok is false if
+// the client isn't spectating.
+func (s *Spectators) Watching(ci *ClientInfo) (room string, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	room, ok = s.watching[ci]
+	return room, ok
+}
+
+// Number of spectators
+func (s *Spectators) Count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.watching)
+}
+
+// Send an event to everyone watching its room.  Like Broadcast, this
+// never blocks.
+func (s *Spectators) Report(ev *protocol.GameEventMessage) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for ci, room := range s.watching {
+		if room == "" || room == ev.Room {
+			ci.Send(ev)
+		}
+	}
+}
+
+// Tell the spectators about something that happened in this room (ev
+// only needs the event's own fields filled in).  Should only be called
+// when GameLock is held.
+func (g *GameInfo) reportLocked(ev *protocol.GameEventMessage) {
+	if g.Spectators == nil {
+		return
+	}
+
+	ev.Time = time.Now().UnixMilli()
+	ev.Room = g.Name
+	ev.Round = g.round
+	g.Spectators.Report(ev)
+}
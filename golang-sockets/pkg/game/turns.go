@@ -0,0 +1,129 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"golang-sockets/pkg/journal"
+	"golang-sockets/pkg/protocol"
+)
+
+// In turn-based rooms, players take turns guessing, in the order they
+// joined the room (which is the order of g.Clients).  Everyone in the
+// room hears whose turn it is, and anyone else who guesses gets
+// ErrNotYourTurn.  Players who take too long (Settings.TurnTimeout) are
+// skipped, and so are players who have used up their guesses for the
+// round.
+//
+// The turn is only changed while holding GameLock.
+
+// Index of a client in g.Clients, or -1 if it isn't in the room
+func (g *GameInfo) clientIndex(target *ClientInfo) int {
+	g.ClientListLock.Lock()
+	defer g.ClientListLock.Unlock()
+
+	for i, ci := range g.Clients {
+		if ci == target {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check if the client is allowed to guess right now.  Should only be
+// called when GameLock is held.
+func (g *GameInfo) checkTurnLocked(ci *ClientInfo) error {
+	if g.Settings.TurnBased && g.turn != ci {
+		return ErrNotYourTurn
+	}
+	return nil
+}
+
+// Give the turn to the first player at or after index start in
+// g.Clients (wrapping around) who still has guesses left, and tell
+// everyone.  skipped is the name of the player who just ran out of
+// time, if any.  Should only be called when GameLock is held.
+func (g *GameInfo) passTurnLocked(start int, skipped string) {
+	g.turnSeq++
+	if g.turnTimer != nil {
+		g.turnTimer.Stop()
+		g.turnTimer = nil
+	}
+	g.turn = nil
+
+	if !g.Settings.TurnBased {
+		return
+	}
+
+	g.ClientListLock.Lock()
+	n := len(g.Clients)
+	for i := 0; i < n; i++ {
+		ci := g.Clients[(start+i)%n]
+		if max := g.Settings.MaxGuesses; max == 0 || g.PlayerGuesses[ci.Name] < max {
+			g.turn = ci
+			break
+		}
+	}
+	g.ClientListLock.Unlock()
+
+	if g.turn == nil {
+		// Nobody can guess, so the round is about to end anyway
+		return
+	}
+
+	if timeout := g.Settings.TurnTimeout; timeout > 0 {
+		seq := g.turnSeq
+		g.turnTimer = time.AfterFunc(timeout, func() {
+			g.GameLock.Lock()
+			defer g.GameLock.Unlock()
+
+			if g.turnSeq != seq {
+				return // Somebody else has the turn now
+			}
+			log.Printf("Room %s:  %s ran out of time\n", g.Name, g.turn)
+			g.skipTurnLocked()
+		})
+	}
+
+	g.Broadcast(g.turnMessageLocked(skipped))
+}
+
+// Skip whoever has the turn, as if they ran out of time (to replay a
+// journal, say).  Returns who was skipped, or false if nobody had the
+// turn.
+func (g *GameInfo) SkipTurn() (string, bool) {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	if g.turn == nil {
+		return "", false
+	}
+	name := g.turn.Name
+	g.skipTurnLocked()
+
+	return name, true
+}
+
+// Should only be called when GameLock is held, and somebody has the turn
+func (g *GameInfo) skipTurnLocked() {
+	g.recordLocked(journal.Event{Type: journal.Skip, Client: g.turn.Id, Player: g.turn.Name})
+	g.passTurnLocked(g.clientIndex(g.turn)+1, g.turn.Name)
+}
+
+// Pass the turn on from whoever has it now.  Should only be called
+// when GameLock is held.
+func (g *GameInfo) nextTurnLocked() {
+	// If nobody has the turn, clientIndex returns -1, so we start
+	// from the beginning
+	g.passTurnLocked(g.clientIndex(g.turn)+1, "")
+}
+
+// Build the message saying whose turn it is.  Should only be called
+// when GameLock is held.
+func (g *GameInfo) turnMessageLocked(skipped string) *protocol.TurnMessage {
+	return &protocol.TurnMessage{
+		Name:          g.turn.Name,
+		TurnTimeoutMs: uint32(g.Settings.TurnTimeout.Milliseconds()),
+		Skipped:       skipped,
+	}
+}
@@ -0,0 +1,111 @@
+package game
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// Name of whoever has the turn ("" if nobody)
+func whoseTurn(g *GameInfo) string {
+	g.GameLock.Lock()
+	defer g.GameLock.Unlock()
+
+	if g.turn == nil {
+		return ""
+	}
+	return g.turn.Name
+}
+
+// A guess that won't end the round
+func wrongGuess(g *GameInfo) int32 {
+	if target := g.Target(); target > 0 {
+		return target - 1
+	}
+	return 1
+}
+
+func TestTurnOrder(t *testing.T) {
+	al, bob, cy := testClient(1, "al"), testClient(2, "bob"), testClient(3, "cy")
+	g := testRoom(Settings{MinNumber: 0, MaxNumber: 100, TurnBased: true}, al, bob, cy)
+	defer g.Close()
+
+	if _, err := g.DoGuess(bob, wrongGuess(g), 0); !errors.Is(err, ErrNotYourTurn) {
+		t.Errorf("bob guessing first:  got %v, want ErrNotYourTurn", err)
+	}
+
+	// In the order they joined, and around again
+	for _, ci := range []*ClientInfo{al, bob, cy, al} {
+		if turn := whoseTurn(g); turn != ci.Name {
+			t.Fatalf("%s's turn, want %s's", turn, ci.Name)
+		}
+		if _, err := g.DoGuess(ci, wrongGuess(g), 0); err != nil {
+			t.Fatalf("%s:  %v", ci, err)
+		}
+	}
+
+	// Everyone hears whose turn it is
+	var turns []string
+	for _, msg := range sent(cy) {
+		if turn, ok := msg.(*protocol.TurnMessage); ok {
+			turns = append(turns, turn.Name)
+		}
+	}
+	if len(turns) != 4 || turns[0] != "bob" || turns[1] != "cy" || turns[2] != "al" || turns[3] != "bob" {
+		t.Errorf("cy heard turns %v, want [bob cy al bob]", turns)
+	}
+
+	// If the player whose turn it is leaves, the next one goes
+	g.RemoveClient(bob)
+	if turn := whoseTurn(g); turn != "cy" {
+		t.Errorf("%s's turn after bob left, want cy's", turn)
+	}
+}
+
+func TestTurnTimeout(t *testing.T) {
+	al, bob := testClient(1, "al"), testClient(2, "bob")
+	g := testRoom(Settings{MinNumber: 0, MaxNumber: 100, TurnBased: true, TurnTimeout: 50 * time.Millisecond}, al, bob)
+	defer g.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for whoseTurn(g) != "bob" {
+		if time.Now().After(deadline) {
+			t.Fatalf("al's turn never timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var skipped *protocol.TurnMessage
+	for _, msg := range sent(bob) {
+		if turn, ok := msg.(*protocol.TurnMessage); ok && turn.Name == "bob" {
+			skipped = turn
+			break
+		}
+	}
+	if skipped == nil || skipped.Skipped != "al" {
+		t.Errorf("got %#v, want bob's turn after al was skipped", skipped)
+	}
+}
+
+// Players who have used up their guesses don't get a turn
+func TestTurnSkipsPlayersWithoutGuesses(t *testing.T) {
+	al, bob, cy := testClient(1, "al"), testClient(2, "bob"), testClient(3, "cy")
+	g := testRoom(Settings{MinNumber: 0, MaxNumber: 100, MaxGuesses: 1, TurnBased: true}, al, bob, cy)
+	defer g.Close()
+
+	if _, err := g.DoGuess(al, wrongGuess(g), 0); err != nil {
+		t.Fatal(err)
+	}
+	g.SkipTurn()
+	if turn := whoseTurn(g); turn != "cy" {
+		t.Fatalf("%s's turn, want cy's", turn)
+	}
+
+	// al is out of guesses, so it's bob's turn again
+	g.SkipTurn()
+	if turn := whoseTurn(g); turn != "bob" {
+		t.Errorf("%s's turn, want bob's", turn)
+	}
+}
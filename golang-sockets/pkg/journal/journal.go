@@ -0,0 +1,208 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// A Journal is an append-only record of everything that happens on the
+// server:  one JSON object per line, per event.  Together with each
+// room's random seed, it has everything needed to play a session back
+// (see cmd/replay), so we can find out after the fact exactly why a
+// round went the way it did.
+//
+// Events in the same room are recorded while holding the room's
+// GameLock, so they're in the same order the room saw them.
+type Journal struct {
+	lock   sync.Mutex
+	file   *os.File
+	failed bool // We already logged a write error
+}
+
+// Event types
+const (
+	Start      = "start"      // The server started (rooms from before are gone)
+	Connect    = "connect"    // A client finished its handshake
+	Disconnect = "disconnect" // A client went away
+	Room       = "room"       // A room was opened (with its seed and settings)
+	Close      = "close"      // A room was closed
+	Join       = "join"       // A player joined a room
+	Leave      = "leave"      // A player left a room
+	Guess      = "guess"      // A player guessed
+	Response   = "response"   // What we told them (a result, or an error)
+	Skip       = "skip"       // A player ran out of time for their turn
+	Reset      = "reset"      // A round ended, and the next one started
+	SetTarget  = "set_target" // An admin changed the target
+	SetRules   = "set_rules"  // An admin changed the rules
+)
+
+// One line of the journal.  Which fields are filled in depends on Type.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Room  string    `json:"room,omitempty"`
+	Round uint32    `json:"round,omitempty"` // Round going on in the room
+
+	Client  int    `json:"client,omitempty"` // Connection ID (a player keeps their name across reconnects, but not this)
+	Player  string `json:"player,omitempty"`
+	Address string `json:"address,omitempty"`
+
+	// Guess:  the number guessed, and which round the player thought
+	// it was (0 for "the current round")
+	// Reset, SetTarget:  the target
+	Number     *int32 `json:"number,omitempty"`
+	GuessRound uint32 `json:"guess_round,omitempty"`
+
+	// Response:  TooHigh, TooLow, ... or an error
+	// Reset:  why the round ended (won, time_up, ...)
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Reset:  who won (if anybody), how many guesses it took everyone,
+	// and the target for the next round
+	Winner     string `json:"winner,omitempty"`
+	Guesses    int    `json:"guesses,omitempty"`
+	NextTarget *int32 `json:"next_target,omitempty"`
+
+	// Room:  the seed for the room's random targets
+	// Room, SetRules:  the room's rules
+	Seed     *int64                 `json:"seed,omitempty"`
+	Settings *protocol.RoomSettings `json:"settings,omitempty"`
+
+	// Connect:  whether the client took over an old session
+	Resumed bool `json:"resumed,omitempty"`
+}
+
+// Results for Response events
+const (
+	TooHigh = "too_high"
+	TooLow  = "too_low"
+	Correct = "correct"
+	TooLate = "too_late" // The guess was for a round that was already over
+)
+
+// Reasons for Reset events, by protocol.RoundOver* reason
+var resetReasons = map[uint8]string{
+	protocol.RoundOverWon:       "won",
+	protocol.RoundOverReset:     "reset",
+	protocol.RoundOverTimeUp:    "time_up",
+	protocol.RoundOverNoGuesses: "no_guesses",
+}
+
+func ResetReason(reason uint8) string {
+	name, ok := resetReasons[reason]
+	if !ok {
+		return fmt.Sprintf("reason %d", reason)
+	}
+	return name
+}
+
+// The protocol.RoundOver* reason for a Reset event's Result
+func ParseResetReason(name string) (uint8, bool) {
+	for reason, n := range resetReasons {
+		if n == name {
+			return reason, true
+		}
+	}
+	return 0, false
+}
+
+// Helpers for the optional fields
+func Int32(n int32) *int32 { return &n }
+func Int64(n int64) *int64 { return &n }
+
+// Open a journal for appending (creating it if it doesn't exist)
+func Open(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file: file}, nil
+}
+
+// Add an event to the journal, stamping it with the current time.  A
+// nil Journal records nothing, so callers don't have to check.
+//
+// Write errors are logged (once), but otherwise ignored:  losing the
+// journal is bad, but not bad enough to stop the game over.
+func (j *Journal) Record(ev Event) {
+	if j == nil {
+		return
+	}
+	ev.Time = time.Now()
+
+	line, err := json.Marshal(&ev)
+	if err != nil {
+		log.Printf("journal:  %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	// One Write per line, so a crash can only cut off the last line
+	_, err = j.file.Write(line)
+	if err != nil && !j.failed {
+		log.Printf("journal:  write failed, events will be missing:  %v\n", err)
+		j.failed = true
+	}
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.file.Close()
+}
+
+// Reads a journal back, one event at a time
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Get the next event.  Returns io.EOF at the end of the journal.
+func (r *Reader) Next() (Event, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ev Event
+		err := json.Unmarshal(r.scanner.Bytes(), &ev)
+		if err != nil {
+			return ev, fmt.Errorf("line %d:  %w", r.line, err)
+		}
+		return ev, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+// Line number of the last event Next returned
+func (r *Reader) Line() int {
+	return r.line
+}
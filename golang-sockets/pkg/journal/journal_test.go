@@ -0,0 +1,153 @@
+package journal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang-sockets/pkg/protocol"
+)
+
+// Read every event in a file, up to the first error (or io.EOF)
+func readAll(t *testing.T, path string) ([]Event, *Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	r := NewReader(f)
+	var events []Event
+	for {
+		ev, err := r.Next()
+		if err != nil {
+			return events, r, err
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := protocol.RoomSettings{MinNumber: 0, MaxNumber: 100, TurnBased: true}
+	written := []Event{
+		{Type: Start},
+		{Type: Room, Room: "main", Round: 1, Number: Int32(0), Seed: Int64(-42), Settings: &settings},
+		{Type: Guess, Room: "main", Round: 1, Client: 3, Player: "al", Number: Int32(-7), GuessRound: 1},
+		{Type: Response, Room: "main", Round: 1, Client: 3, Player: "al", Error: "guess is out of range"},
+		{Type: Reset, Room: "main", Round: 1, Number: Int32(0), Result: ResetReason(protocol.RoundOverWon),
+			Winner: "al", Guesses: 2, NextTarget: Int32(17)},
+	}
+	for _, ev := range written {
+		j.Record(ev)
+	}
+
+	// Events recorded after a reopen are added to the end
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leave := Event{Type: Leave, Room: "main", Round: 2, Client: 3, Player: "al"}
+	j.Record(leave)
+	written = append(written, leave)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, r, err := readAll(t, path)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v, want io.EOF at the end", err)
+	}
+	if len(read) != len(written) {
+		t.Fatalf("read %d event(s), want %d", len(read), len(written))
+	}
+	for i := range written {
+		if read[i].Time.IsZero() {
+			t.Errorf("event %d has no time", i)
+		}
+		read[i].Time = written[i].Time
+		if !reflect.DeepEqual(read[i], written[i]) {
+			t.Errorf("event %d:  got %+v, want %+v", i, read[i], written[i])
+		}
+	}
+	if r.Line() != len(written) {
+		t.Errorf("on line %d, want %d", r.Line(), len(written))
+	}
+
+	// A zero seed or target is still there, not left out as empty
+	if read[1].Number == nil || *read[1].Number != 0 {
+		t.Errorf("the room's target of 0 was lost")
+	}
+}
+
+// A crash can cut off the last line.  Everything before it still reads
+// back, and the cut-off line is an error (saying which line), not an
+// event with half its fields missing.
+func TestTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Record(Event{Type: Start})
+	j.Record(Event{Type: Join, Room: "main", Round: 1, Client: 1, Player: "al"})
+	j.Record(Event{Type: Guess, Room: "main", Round: 1, Client: 1, Player: "al", Number: Int32(50)})
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := strings.LastIndex(strings.TrimSuffix(string(data), "\n"), "\n") + 20
+	if err := os.WriteFile(path, data[:cut], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read, _, err := readAll(t, path)
+	if len(read) != 2 || read[1].Player != "al" {
+		t.Errorf("got %+v, want the 2 whole events", read)
+	}
+	if err == nil || errors.Is(err, io.EOF) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("got %v, want an error about line 3", err)
+	}
+}
+
+// Blank lines (say, from editing a journal by hand) are skipped
+func TestBlankLines(t *testing.T) {
+	r := NewReader(strings.NewReader("\n{\"type\":\"start\"}\n\n{\"type\":\"close\",\"room\":\"x\"}\n"))
+
+	ev, err := r.Next()
+	if err != nil || ev.Type != Start || r.Line() != 2 {
+		t.Errorf("got %+v on line %d (%v), want start on line 2", ev, r.Line(), err)
+	}
+	ev, err = r.Next()
+	if err != nil || ev.Type != Close || r.Line() != 4 {
+		t.Errorf("got %+v on line %d (%v), want close on line 4", ev, r.Line(), err)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+// A nil Journal (when the server isn't keeping one) records nothing
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	j.Record(Event{Type: Start})
+	if err := j.Close(); err != nil {
+		t.Errorf("closing a nil Journal:  %v", err)
+	}
+}
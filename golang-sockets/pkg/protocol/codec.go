@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// How messages are read from and written to one connection.  Most
+// clients speak the framed protocol (see framing.go), but the server
+// also understands other ways of writing the same messages (like a
+// line-based text protocol), so it picks a Codec for each connection
+// and otherwise doesn't care which one it's using.
+type Codec interface {
+	// Read the next message.  If timeout is nonzero, give up if it
+	// doesn't arrive within that long.
+	ReadMessage(timeout time.Duration) (Message, error)
+
+	// Send a message.  Like WriteMessage, this must use a single Write.
+	WriteMessage(m Message) error
+}
+
+// Codec for the framed protocol
+type FrameCodec struct {
+	Conn net.Conn
+
+	// Version to send frames with (0 for ProtocolVersion until
+	// SetVersion is called).  Atomic, since whoever does the handshake
+	// usually isn't whoever writes.
+	version uint32
+}
+
+func (c *FrameCodec) ReadMessage(timeout time.Duration) (Message, error) {
+	return ReadMessage(c.Conn, timeout)
+}
+
+func (c *FrameCodec) WriteMessage(m Message) error {
+	version := uint8(atomic.LoadUint32(&c.version))
+	if version == 0 {
+		return WriteMessage(c.Conn, m)
+	}
+	return WriteMessageVersion(c.Conn, m, version)
+}
+
+// Send frames with this version from now on (once the handshake has
+// picked one).  Frames we read can still have any version we speak.
+func (c *FrameCodec) SetVersion(version uint8) {
+	atomic.StoreUint32(&c.version, uint32(version))
+}
+
+// A connection we've already read (or peeked at) some bytes from, to see
+// what kind of client is on the other end.  Reads get those bytes
+// first, then carry on with the socket.
+type BufferedConn struct {
+	net.Conn
+	Reader *bufio.Reader // Wraps Conn
+}
+
+func (c *BufferedConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Errors
+//
+// Everything in this package that reads or writes a connection returns
+// errors of the kinds below (usually as an *Error, which also carries
+// what actually went wrong), so callers can check with errors.Is what
+// kind of trouble they're in and decide for themselves what to do
+// about it, without picking through io, net and syscall errors.  The
+// one exception is net.ErrClosed, which means we closed the connection
+// ourselves, so it's nobody's fault.
+//
+// After ErrUnknownMessageType and ErrMalformedMessage, the connection
+// can still be used:  the whole message was read, we just couldn't make
+// sense of it.  After anything else, it can't.
+
+var (
+	// The peer hung up (or reset the connection) between messages
+	ErrPeerClosed = errors.New("connection closed by peer")
+
+	// The connection ended partway through a message
+	ErrShortRead = errors.New("short read")
+
+	// Nothing arrived within the timeout (or before the read deadline)
+	ErrTimeout = errors.New("timed out")
+
+	// The peer sent something that isn't our protocol at all, so we
+	// can't tell where the next message starts
+	ErrProtocolViolation = errors.New("protocol violation")
+
+	// A frame with a version we don't speak.  These are also protocol
+	// violations, since other versions might frame things differently.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+	ErrUnknownMessageType = errors.New("unknown message type")
+	ErrMalformedMessage   = errors.New("malformed message")
+)
+
+// One of the errors above (Kind), along with the error that caused it,
+// if any.  errors.Is matches either one.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ":  " + e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// So os.IsTimeout still recognizes our timeouts, like it did the
+// socket's own
+func (e *Error) Timeout() bool {
+	return e.Kind == ErrTimeout
+}
+
+// Sort an error from reading or writing a connection into one of the
+// kinds above.  partial is whether we'd already read part of a message
+// (so the peer hanging up means it was cut short).  Errors that are
+// already sorted, and ones we can't say anything more about, are
+// returned as they are.  Codecs outside this package use this too.
+func ConnError(err error, partial bool) error {
+	var sorted *Error
+	var netErr net.Error
+
+	switch {
+	case err == nil || errors.As(err, &sorted) || errors.Is(err, net.ErrClosed):
+		return err
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &Error{Kind: ErrTimeout, Err: err}
+	case errors.Is(err, io.ErrUnexpectedEOF), partial && errors.Is(err, io.EOF):
+		return &Error{Kind: ErrShortRead, Err: io.ErrUnexpectedEOF}
+	case errors.Is(err, io.EOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return &Error{Kind: ErrPeerClosed, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		sent string // Hex, after which the peer hangs up
+		want error
+	}{
+		{"nothing", "", ErrPeerClosed},
+		{"half a header", "0400", ErrShortRead},
+		{"header only", "040f0002", ErrShortRead},
+		{"half a payload", "040f000200", ErrShortRead},
+		{"bad version", "01030000", ErrProtocolViolation},
+	}
+
+	for _, tc := range cases {
+		clientConn, serverConn := net.Pipe()
+		go func() {
+			data, _ := hex.DecodeString(tc.sent)
+			clientConn.Write(data)
+			clientConn.Close()
+		}()
+
+		_, err := ReadMessage(serverConn, time.Second)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s:  got %v, want %v", tc.name, err, tc.want)
+		}
+		serverConn.Close()
+	}
+}
+
+func TestReadErrorDetails(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go clientConn.Close()
+
+	// What actually happened is still there, for anyone who checks
+	_, err := ReadMessage(serverConn, time.Second)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("got %v, want it to wrap io.EOF", err)
+	}
+
+	frame, _ := hex.DecodeString("01030000")
+	_, err = UnmarshalFrame(UnmarshalHeader(frame), nil)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("got %v, want it to wrap ErrUnsupportedVersion", err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	_, err := ReadMessage(serverConn, 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+	if !os.IsTimeout(err) {
+		t.Errorf("os.IsTimeout(%v) should be true", err)
+	}
+}
+
+// After a message we can't decode, the next one still comes through
+func TestReadSkipsUndecodable(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		data, _ := hex.DecodeString("04ff0001aa" + "040f0002000a")
+		clientConn.Write(data)
+	}()
+
+	_, err := ReadMessage(serverConn, time.Second)
+	if !errors.Is(err, ErrUnknownMessageType) {
+		t.Fatalf("got %v, want ErrUnknownMessageType", err)
+	}
+	msg, err := ReadMessage(serverConn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top, ok := msg.(*GetTopMessage); !ok || top.Count != 10 {
+		t.Errorf("got %#v, want a GetTop for 10", msg)
+	}
+}
+
+func TestSendGuessErrors(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	serverConn.Close()
+
+	if err := SendGuess(5, clientConn); err == nil {
+		t.Errorf("sending to a closed connection should fail")
+	}
+}
@@ -0,0 +1,231 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// Framed wire format
+//
+// GuessMessage is always exactly 5 bytes, which is fine for a guess,
+// but there's no way to send anything variable-length (like a player
+// name or some error text) that way.  Instead, every message is sent
+// as a "frame":  a small fixed-size header that tells the receiver how
+// many payload bytes follow, and how to interpret them:
+//
+//	 0        1        2        3
+//	+--------+--------+--------+--------+
+//	| version|  type  |  payload length |
+//	+--------+--------+--------+--------+
+//	|  payload (0-65535 bytes) ...      |
+//	+--------+--------+--------+--------+
+//
+// Like before, all multi-byte fields are big endian.  The receiver
+// always reads exactly HeaderSize bytes first, and then exactly
+// Length more bytes, so it never needs to guess where a message ends.
+
+const (
+	// Versions of the protocol we speak.  Which one to use is agreed
+	// on in the Hello/Welcome handshake at the start of each
+	// connection, and from then on, frames are sent with that version
+	// (and its payload layouts).  Until then, they're sent with
+	// ProtocolVersion, which is fine since the handshake messages
+	// haven't changed.  We accept frames with any version in
+	// [MinProtocolVersion, ProtocolVersion], and reject the rest
+	// rather than trying to parse them.
+	//
+	// Version 2 added the game rules to RoomSettings, and the reason
+	// a round ended to RoundOver.  Version 3 added turn-based play.
+	// Version 4 added round IDs to guesses, responses, new games,
+	// RoomJoined and RoundOver.  Version 5 added the players in the
+	// room to RoomJoined.
+	MinProtocolVersion = 3
+	ProtocolVersion    = 5
+
+	HeaderSize     = 4
+	MaxPayloadSize = math.MaxUint16
+)
+
+// All versions we support, newest first (for HelloMessage.Versions)
+func SupportedVersions() []uint8 {
+	versions := make([]uint8, 0)
+	for v := ProtocolVersion; v >= MinProtocolVersion; v-- {
+		versions = append(versions, uint8(v))
+	}
+	return versions
+}
+
+// Pick the newest version both sides support.  Returns false if
+// there isn't one.
+func NegotiateVersion(offered []uint8) (uint8, bool) {
+	best, found := uint8(0), false
+	for _, v := range offered {
+		if v >= MinProtocolVersion && v <= ProtocolVersion && (!found || v > best) {
+			best, found = v, true
+		}
+	}
+	return best, found
+}
+
+type Header struct {
+	Version     uint8
+	MessageType uint8
+	Length      uint16
+}
+
+func (h *Header) Marshal() []byte {
+	buf := make([]byte, HeaderSize)
+	buf[0] = h.Version
+	buf[1] = h.MessageType
+	binary.BigEndian.PutUint16(buf[2:], h.Length)
+
+	return buf
+}
+
+func UnmarshalHeader(buffer []byte) Header {
+	return Header{
+		Version:     buffer[0],
+		MessageType: buffer[1],
+		Length:      binary.BigEndian.Uint16(buffer[2:]),
+	}
+}
+
+// Anything that can be sent inside a frame.  Type() goes in the
+// header, MarshalPayload() produces the bytes after it (laid out the
+// way they are in that protocol version).
+type Message interface {
+	Type() uint8
+	MarshalPayload(version uint8) ([]byte, error)
+}
+
+// Function to turn a payload back into a message.  msgType is
+// passed in so the same decoder can handle several message types
+// with the same layout (like guess/response/new game), and version
+// is the version from the frame's header.
+type DecodeFunc func(msgType, version uint8, payload []byte) (Message, error)
+
+type messageSpec struct {
+	name   string
+	decode DecodeFunc
+}
+
+// Registry of all message types we know how to decode, filled in by
+// RegisterMessage (see messages.go)
+var registry = make(map[uint8]messageSpec)
+
+// Add a message type to the registry.  This is meant to be called
+// from init(), so registering the same type twice is a programming
+// error and panics.
+func RegisterMessage(msgType uint8, name string, decode DecodeFunc) {
+	if _, exists := registry[msgType]; exists {
+		panic(fmt.Sprintf("message type %d registered twice", msgType))
+	}
+	registry[msgType] = messageSpec{name: name, decode: decode}
+}
+
+// Human-readable name of a message type, for logging
+func MessageName(msgType uint8) string {
+	spec, ok := registry[msgType]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", msgType)
+	}
+	return spec.name
+}
+
+// Build a complete frame (header + payload) for a message, in the
+// given protocol version
+func MarshalFrame(m Message, version uint8) ([]byte, error) {
+	payload, err := m.MarshalPayload(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("%s payload too large:  %d bytes",
+			MessageName(m.Type()), len(payload))
+	}
+
+	header := Header{
+		Version:     version,
+		MessageType: m.Type(),
+		Length:      uint16(len(payload)),
+	}
+
+	buf := bytes.NewBuffer(header.Marshal())
+	buf.Write(payload)
+
+	return buf.Bytes(), nil
+}
+
+// Turn a header and its payload back into a message, using the registry
+func UnmarshalFrame(header Header, payload []byte) (Message, error) {
+	if header.Version < MinProtocolVersion || header.Version > ProtocolVersion {
+		return nil, &Error{Kind: ErrProtocolViolation, Err: fmt.Errorf("%w %d (we speak %d-%d)",
+			ErrUnsupportedVersion, header.Version, MinProtocolVersion, ProtocolVersion)}
+	}
+
+	spec, ok := registry[header.MessageType]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownMessageType, header.MessageType)
+	}
+
+	m, err := spec.decode(header.MessageType, header.Version, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s):  %v", ErrMalformedMessage, spec.name, err)
+	}
+
+	return m, nil
+}
+
+// Send a message as a single frame, with ProtocolVersion.  That's
+// what clients (which always speak the newest version) send the
+// Hello in, but once the handshake is done, everything else should
+// go out with WriteMessageVersion.
+func WriteMessage(conn net.Conn, m Message) error {
+	return WriteMessageVersion(conn, m, ProtocolVersion)
+}
+
+// Send a message as a single frame, with the version agreed on in the
+// handshake.  We build the whole frame first and send it with one
+// Write, so frames from different goroutines can't end up interleaved
+// on the wire.
+func WriteMessageVersion(conn net.Conn, m Message, version uint8) error {
+	frame, err := MarshalFrame(m, version)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(frame)
+	return ConnError(err, false)
+}
+
+// Read exactly one frame from the socket and decode it.  If timeout is
+// nonzero, give up if the frame doesn't arrive within that long.  (See
+// errors.go for what can go wrong.)
+func ReadMessage(conn net.Conn, timeout time.Duration) (Message, error) {
+	headerBuf := make([]byte, HeaderSize)
+	_, err := RecvAll(conn, headerBuf, HeaderSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	header := UnmarshalHeader(headerBuf)
+
+	// Now we know exactly how much more to read
+	payload := make([]byte, header.Length)
+	_, err = RecvAll(conn, payload, int(header.Length), timeout)
+	if errors.Is(err, io.EOF) {
+		// Hanging up after the header still cuts the frame short
+		return nil, &Error{Kind: ErrShortRead, Err: io.ErrUnexpectedEOF}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return UnmarshalFrame(header, payload)
+}
@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang-sockets/pkg/codec"
+)
+
+// Legacy dialects
+//
+// Before frames, every version of the guessing game spoke the same
+// fixed-size 5-byte message (see GuessMessage.Marshal):
+//
+//	+--------+--------+--------+--------+--------+
+//	|  type  |      number (int32, big endian)   |
+//	+--------+--------+--------+--------+--------+
+//
+// Clients send guesses (type 0) and get back one response (type 1)
+// for each, where the number is GuessTooHigh, GuessCorrect, ...
+// Nothing else was ever sent, except by the last version of this
+// program, which also told clients about new rounds (type 2).  The
+// clients differ in what else they can cope with, so each one is its
+// own Dialect.  They all need to be answered exactly once per guess,
+// since most of them send a guess and then block until the answer
+// comes back--so when the game answers with an error instead (not
+// your turn, out of guesses, ...), the client gets a response with
+// LegacyNotCounted, which the Go clients print as an invalid
+// response.  (The C client can't tell it from "too high".)
+//
+// Frames always start with the protocol version, which is never 0, so
+// a client whose first byte is 0 is sending a legacy guess.
+
+// Response number for guesses the game didn't answer normally
+// (GuessRoundOver in pkg/game)
+const LegacyNotCounted = 2
+
+type Dialect struct {
+	Name    string
+	Client  string // Which program speaks it
+	NewGame bool   // Whether it understands MessageTypeNewGame
+}
+
+var dialects = map[string]*Dialect{}
+
+func registerDialect(d *Dialect) {
+	dialects[d.Name] = d
+}
+
+func init() {
+	// golang-sockets-init/guessing-game-v0 only got as far as the
+	// constants, but they're the same 5 bytes
+	registerDialect(&Dialect{Name: "init-v0", Client: "golang-sockets-init/guessing-game-v0"})
+
+	// Reads each response with a single conn.Read, so every message
+	// has to go out in one Write
+	registerDialect(&Dialect{Name: "init-v1", Client: "golang-sockets-init/guessing-game-v1"})
+	registerDialect(&Dialect{Name: "init-v2", Client: "golang-sockets-init/guessing-game-v2"})
+
+	// Reads in the background, and log.Fatalln's on any read error
+	// (including us hanging up), but only knows about responses
+	registerDialect(&Dialect{Name: "demo-v2", Client: "sockets-demo-golang/guessing-game-v2"})
+
+	// Sends a packed struct and reads exactly one back per guess.  Any
+	// number it doesn't expect is still read as too high/too low.
+	registerDialect(&Dialect{Name: "c", Client: "sockets-demo/guessing-game (C)"})
+
+	// golang-sockets before frames:  also gets a new game message when
+	// a round starts
+	registerDialect(&Dialect{Name: "original", Client: "golang-sockets before frames", NewGame: true})
+}
+
+// Look up a dialect by name
+func LookupDialect(name string) (*Dialect, error) {
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %s (try one of %v)", name, DialectNames())
+	}
+	return d, nil
+}
+
+// Names of every legacy dialect, sorted
+func DialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Codec for a client speaking a legacy dialect.  It turns the client's
+// guesses into the same messages framed clients send, and only passes
+// on what the dialect understands.
+type LegacyCodec struct {
+	Conn    net.Conn
+	Dialect *Dialect
+
+	greeted bool // Only used by the reader:  whether we've returned a Hello yet
+
+	lock       sync.Mutex // Reads and writes happen in different goroutines
+	unanswered int        // Guesses the client is still waiting to hear about
+}
+
+func (c *LegacyCodec) ReadMessage(timeout time.Duration) (Message, error) {
+	// Every client has to start with a Hello, but these clients don't
+	// know about them, so make one up (which has the server pick a name)
+	if !c.greeted {
+		c.greeted = true
+		return &HelloMessage{Versions: SupportedVersions()}, nil
+	}
+
+	buffer := make([]byte, GuessMessageSize)
+	_, err := RecvAll(c.Conn, buffer, GuessMessageSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := legacyGuess{}
+	err = codec.Unmarshal(buffer, &legacy)
+	if err != nil {
+		return nil, fmt.Errorf("%w:  %v", ErrMalformedMessage, err)
+	}
+
+	// Every message is the same size, so we can carry on after one we
+	// don't understand
+	if legacy.MessageType != MessageTypeGuess {
+		return nil, fmt.Errorf("%w %d (legacy clients can only guess)",
+			ErrUnknownMessageType, legacy.MessageType)
+	}
+
+	c.lock.Lock()
+	c.unanswered++
+	c.lock.Unlock()
+
+	// Round 0 is whatever round is going on when the guess arrives
+	return &GuessMessage{MessageType: MessageTypeGuess, Number: legacy.Number}, nil
+}
+
+func (c *LegacyCodec) WriteMessage(m Message) error {
+	var out *legacyGuess
+
+	switch msg := m.(type) {
+	case *GuessMessage:
+		switch msg.MessageType {
+		case MessageTypeResponse:
+			c.answered()
+			out = &legacyGuess{MessageType: MessageTypeResponse, Number: msg.Number}
+		case MessageTypeNewGame:
+			if c.Dialect.NewGame {
+				out = &legacyGuess{MessageType: MessageTypeNewGame}
+			}
+		}
+	case *ErrorMessage:
+		// The only thing these clients do is guess, so an error is
+		// about a guess, and they're waiting for an answer to it
+		if c.answered() {
+			out = &legacyGuess{MessageType: MessageTypeResponse, Number: LegacyNotCounted}
+		}
+	}
+
+	if out == nil {
+		return nil // Nothing this dialect can say
+	}
+
+	buf, err := codec.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = c.Conn.Write(buf)
+	return ConnError(err, false)
+}
+
+// Count an answer to a guess.  Returns false if there was no guess
+// waiting for one.
+func (c *LegacyCodec) answered() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.unanswered == 0 {
+		return false
+	}
+	c.unanswered--
+	return true
+}
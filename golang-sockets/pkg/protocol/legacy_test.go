@@ -0,0 +1,274 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Conformance tests for the legacy dialects.  Each test plays the part
+// of one of the old clients, reading and writing the way it does, with
+// a LegacyCodec on the other end of the connection standing in for the
+// server.
+
+// How one old client reads a message from the server
+type legacyReader func(conn net.Conn) (uint8, int32, error)
+
+// golang-sockets-init v1 and sockets-demo-golang v2:  one conn.Read,
+// which only works if every message arrives in one piece
+func readOnce(conn net.Conn) (uint8, int32, error) {
+	buffer := make([]byte, 5)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n != 5 {
+		return 0, 0, errors.New("message split across reads")
+	}
+	return buffer[0], int32(binary.BigEndian.Uint32(buffer[1:])), nil
+}
+
+// golang-sockets-init v2, the C client (recv_all) and golang-sockets
+// before frames:  keep reading until there are 5 bytes
+func readFull(conn net.Conn) (uint8, int32, error) {
+	buffer := make([]byte, 5)
+	_, err := io.ReadFull(conn, buffer)
+	if err != nil {
+		return 0, 0, err
+	}
+	return buffer[0], int32(binary.BigEndian.Uint32(buffer[1:])), nil
+}
+
+// How one old client sends a guess
+type legacyWriter func(conn net.Conn, guess int32) error
+
+// Most of them send the whole message at once (the C client's packed
+// struct, GuessMessage.Marshal, ...)
+func writeOnce(conn net.Conn, guess int32) error {
+	buf := make([]byte, 5)
+	buf[0] = MessageTypeGuess
+	binary.BigEndian.PutUint32(buf[1:], uint32(guess))
+	_, err := conn.Write(buf)
+	return err
+}
+
+// golang-sockets' SendGuess (and golang-sockets-init v2's
+// SendGuessMessageV2) send the type and number separately
+func writeSplit(conn net.Conn, guess int32) error {
+	_, err := conn.Write([]byte{MessageTypeGuess})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(guess))
+	_, err = conn.Write(buf)
+	return err
+}
+
+var legacyClients = []struct {
+	dialect string
+	read    legacyReader
+	write   legacyWriter
+}{
+	{"init-v0", readFull, writeOnce}, // Never got as far as sockets, so any reader will do
+	{"init-v1", readOnce, writeOnce},
+	{"init-v2", readFull, writeOnce},
+	{"demo-v2", readOnce, writeOnce},
+	{"c", readFull, writeOnce},
+	{"original", readFull, writeSplit},
+}
+
+func TestLegacyDialectsRegistered(t *testing.T) {
+	for _, client := range legacyClients {
+		if _, err := LookupDialect(client.dialect); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(DialectNames()) != len(legacyClients) {
+		t.Errorf("dialects %v aren't all tested", DialectNames())
+	}
+	if _, err := LookupDialect("framed"); err == nil {
+		t.Errorf("framed isn't a legacy dialect")
+	}
+}
+
+// What happens on the server side of one test:  the messages the game
+// sends, and the guesses it expects
+type legacyServer struct {
+	t     *testing.T
+	codec *LegacyCodec
+}
+
+func (s *legacyServer) expectHello() {
+	msg, err := s.codec.ReadMessage(time.Second)
+	if err != nil {
+		s.t.Errorf("reading hello:  %v", err)
+		return
+	}
+	hello, ok := msg.(*HelloMessage)
+	if !ok || hello.Name != "" || !reflect.DeepEqual(hello.Versions, SupportedVersions()) {
+		s.t.Errorf("got %#v, want a made-up hello", msg)
+	}
+}
+
+func (s *legacyServer) expectGuess(n int32) {
+	msg, err := s.codec.ReadMessage(time.Second)
+	if err != nil {
+		s.t.Errorf("reading guess:  %v", err)
+		return
+	}
+	want := &GuessMessage{MessageType: MessageTypeGuess, Number: n}
+	if !reflect.DeepEqual(msg, want) {
+		s.t.Errorf("got %#v, want %#v", msg, want)
+	}
+}
+
+func (s *legacyServer) send(msgs ...Message) {
+	for _, m := range msgs {
+		if err := s.codec.WriteMessage(m); err != nil {
+			s.t.Errorf("writing %s:  %v", MessageName(m.Type()), err)
+		}
+	}
+}
+
+// Counts writes, so we can check every message is sent with one
+type writeCounter struct {
+	net.Conn
+	lock   sync.Mutex
+	writes []int
+}
+
+func (c *writeCounter) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	c.writes = append(c.writes, len(b))
+	c.lock.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestLegacyConformance(t *testing.T) {
+	for _, client := range legacyClients {
+		t.Run(client.dialect, func(t *testing.T) {
+			dialect, _ := LookupDialect(client.dialect)
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			counter := &writeCounter{Conn: serverConn}
+			server := &legacyServer{t: t, codec: &LegacyCodec{Conn: counter, Dialect: dialect}}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer serverConn.Close()
+
+				server.expectHello()
+
+				// A guess gets its response, and nothing the client
+				// doesn't understand
+				server.expectGuess(50)
+				server.send(
+					&RoomJoinedMessage{Name: "main"},
+					&RulesMessage{Room: "main"},
+					&PingMessage{MessageType: MessageTypePing, Seq: 1},
+					&PlayerEventMessage{Name: "someone"},
+					&RoundOverMessage{Round: 1, Winner: "someone"},
+					&GuessMessage{MessageType: MessageTypeNewGame, Round: 2},
+					&TurnMessage{Name: "someone"},
+					&GuessMessage{MessageType: MessageTypeResponse, Number: 1, Round: 2},
+				)
+
+				// Errors answer a guess too, since the client is
+				// waiting for one
+				server.expectGuess(-7)
+				server.send(&ErrorMessage{Code: ErrorCodeNotYourTurn, Text: "wait"})
+
+				// But not when nothing's waiting
+				server.send(&ErrorMessage{Code: ErrorCodeKicked, Text: "bye"})
+			}()
+
+			// What the client sees
+			expect := func(wantType uint8, wantNumber int32) {
+				msgType, number, err := client.read(clientConn)
+				if err != nil {
+					t.Fatalf("read:  %v", err)
+				}
+				if msgType != wantType || number != wantNumber {
+					t.Fatalf("got (%d, %d), want (%d, %d)", msgType, number, wantType, wantNumber)
+				}
+			}
+
+			if err := client.write(clientConn, 50); err != nil {
+				t.Fatal(err)
+			}
+			if dialect.NewGame {
+				expect(MessageTypeNewGame, 0)
+			}
+			expect(MessageTypeResponse, 1)
+
+			if err := client.write(clientConn, -7); err != nil {
+				t.Fatal(err)
+			}
+			expect(MessageTypeResponse, LegacyNotCounted)
+
+			// Then the server hangs up, without sending anything else
+			if _, _, err := client.read(clientConn); err != io.EOF {
+				t.Errorf("got %v, want EOF", err)
+			}
+			<-done
+
+			for _, n := range counter.writes {
+				if n != GuessMessageSize {
+					t.Errorf("wrote %v, want one write per message", counter.writes)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyUnknownType(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	dialect, _ := LookupDialect("c")
+	codec := &LegacyCodec{Conn: serverConn, Dialect: dialect}
+
+	go func() {
+		// A response isn't something clients send, but it's the
+		// right size, so the next guess still makes sense
+		clientConn.Write([]byte{MessageTypeResponse, 0, 0, 0, 1})
+		writeOnce(clientConn, 3)
+	}()
+
+	codec.ReadMessage(time.Second) // Hello
+	_, err := codec.ReadMessage(time.Second)
+	if !errors.Is(err, ErrUnknownMessageType) {
+		t.Errorf("got %v, want ErrUnknownMessageType", err)
+	}
+	msg, err := codec.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guess, ok := msg.(*GuessMessage); !ok || guess.Number != 3 {
+		t.Errorf("got %#v, want a guess of 3", msg)
+	}
+}
+
+func TestLegacyTimeout(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	dialect, _ := LookupDialect("init-v1")
+	codec := &LegacyCodec{Conn: serverConn, Dialect: dialect}
+
+	codec.ReadMessage(time.Second)                   // Hello
+	go clientConn.Write([]byte{MessageTypeGuess, 0}) // Never finishes
+	_, err := codec.ReadMessage(50 * time.Millisecond)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
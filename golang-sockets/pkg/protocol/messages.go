@@ -0,0 +1,597 @@
+package protocol
+
+import (
+	"fmt"
+
+	"golang-sockets/pkg/codec"
+)
+
+// Message types beyond the original three (see protocol.go)
+const (
+	MessageTypeListRooms  = 3
+	MessageTypeRoomList   = 4
+	MessageTypeCreateRoom = 5
+	MessageTypeJoinRoom   = 6
+	MessageTypeLeaveRoom  = 7
+	MessageTypeRoomJoined = 8
+	MessageTypeRoomLeft   = 9
+	MessageTypeError      = 10
+	MessageTypeHello      = 11
+	MessageTypeWelcome    = 12
+	MessageTypePlayer     = 13
+	MessageTypeRoundOver  = 14
+	MessageTypeGetTop     = 15
+	MessageTypeTop        = 16
+	MessageTypeGetStats   = 17
+	MessageTypeStats      = 18
+	MessageTypePing       = 19
+	MessageTypePong       = 20
+	MessageTypeRules      = 21
+	MessageTypeTurn       = 22
+	MessageTypeSpectate   = 23
+	MessageTypeSpectating = 24
+	MessageTypeGameEvent  = 25
+	MessageTypeShutdown   = 26
+)
+
+// Error codes for ErrorMessage
+const (
+	ErrorCodeBadRequest  = 1
+	ErrorCodeNotInRoom   = 2
+	ErrorCodeNoSuchRoom  = 3
+	ErrorCodeRoomExists  = 4
+	ErrorCodeBadVersion  = 5
+	ErrorCodeBadHello    = 6
+	ErrorCodeNameTaken   = 7
+	ErrorCodeKicked      = 8
+	ErrorCodeNoGuesses   = 9
+	ErrorCodeNotYourTurn = 10
+	ErrorCodeRateLimited = 11
+	ErrorCodeOutOfRange  = 12
+	ErrorCodeUnknownType = 13
+)
+
+var errorCodeNames = map[uint8]string{
+	ErrorCodeBadRequest:  "bad request",
+	ErrorCodeNotInRoom:   "not in a room",
+	ErrorCodeNoSuchRoom:  "no such room",
+	ErrorCodeRoomExists:  "room exists",
+	ErrorCodeBadVersion:  "bad version",
+	ErrorCodeBadHello:    "bad hello",
+	ErrorCodeNameTaken:   "name taken",
+	ErrorCodeKicked:      "kicked",
+	ErrorCodeNoGuesses:   "no guesses left",
+	ErrorCodeNotYourTurn: "not your turn",
+	ErrorCodeRateLimited: "rate limited",
+	ErrorCodeOutOfRange:  "out of range",
+	ErrorCodeUnknownType: "unknown message type",
+}
+
+// Human-readable name of an error code
+func ErrorCodeName(code uint8) string {
+	name, ok := errorCodeNames[code]
+	if !ok {
+		return fmt.Sprintf("error %d", code)
+	}
+	return name
+}
+
+// Why a round ended, for RoundOverMessage
+const (
+	RoundOverWon       = 0 // Somebody guessed the number
+	RoundOverReset     = 1 // The server reset the round
+	RoundOverTimeUp    = 2 // The round's time limit ran out
+	RoundOverNoGuesses = 3 // Every player used up their guesses
+)
+
+// Events for PlayerEventMessage
+const (
+	PlayerEventJoined = 0
+	PlayerEventLeft   = 1
+)
+
+// Kinds of GameEventMessage
+const (
+	GameEventGuess     = 0
+	GameEventJoined    = 1
+	GameEventLeft      = 2
+	GameEventRoundOver = 3
+)
+
+// Every message type we can send in a frame gets registered here.
+// The original 5-byte messages (guess, response, new game) keep
+// their type numbers:  their payload is the 4-byte number, followed by
+// the 4-byte round ID (since version 4).
+func init() {
+	RegisterMessage(MessageTypeGuess, "guess", decodeGuessMessage)
+	RegisterMessage(MessageTypeResponse, "response", decodeGuessMessage)
+	RegisterMessage(MessageTypeNewGame, "new game", decodeGuessMessage)
+
+	RegisterMessage(MessageTypeListRooms, "list rooms", decodeListRoomsMessage)
+	RegisterMessage(MessageTypeRoomList, "room list", decodeRoomListMessage)
+	RegisterMessage(MessageTypeCreateRoom, "create room", decodeCreateRoomMessage)
+	RegisterMessage(MessageTypeJoinRoom, "join room", decodeJoinRoomMessage)
+	RegisterMessage(MessageTypeLeaveRoom, "leave room", decodeLeaveRoomMessage)
+	RegisterMessage(MessageTypeRoomJoined, "room joined", decodeRoomJoinedMessage)
+	RegisterMessage(MessageTypeRoomLeft, "room left", decodeRoomLeftMessage)
+	RegisterMessage(MessageTypeError, "error", decodeErrorMessage)
+	RegisterMessage(MessageTypeHello, "hello", decodeHelloMessage)
+	RegisterMessage(MessageTypeWelcome, "welcome", decodeWelcomeMessage)
+	RegisterMessage(MessageTypePlayer, "player event", decodePlayerEventMessage)
+	RegisterMessage(MessageTypeRoundOver, "round over", decodeRoundOverMessage)
+	RegisterMessage(MessageTypeGetTop, "get leaderboard", decodeGetTopMessage)
+	RegisterMessage(MessageTypeTop, "leaderboard", decodeTopMessage)
+	RegisterMessage(MessageTypeGetStats, "get stats", decodeGetStatsMessage)
+	RegisterMessage(MessageTypeStats, "stats", decodeStatsMessage)
+	RegisterMessage(MessageTypePing, "ping", decodePingMessage)
+	RegisterMessage(MessageTypePong, "pong", decodePingMessage)
+	RegisterMessage(MessageTypeRules, "rules", decodeRulesMessage)
+	RegisterMessage(MessageTypeTurn, "turn", decodeTurnMessage)
+	RegisterMessage(MessageTypeSpectate, "spectate", decodeSpectateMessage)
+	RegisterMessage(MessageTypeSpectating, "spectating", decodeSpectateMessage)
+	RegisterMessage(MessageTypeGameEvent, "game event", decodeGameEventMessage)
+	RegisterMessage(MessageTypeShutdown, "shutdown", decodeShutdownMessage)
+}
+
+// Payloads are just the message's fields, in order, encoded by
+// pkg/codec (see there for the layout).  Fields that aren't sent, like
+// the MessageType of messages that share a layout, are tagged wire:"-",
+// and fields that were added in a later protocol version are tagged
+// with that version (wire:"since=4"), so they're left out of frames for
+// clients that speak an older one.
+//
+// Decode payload (in the given version's layout) into m, an empty
+// message of the right type
+func decodeFields(m Message, version uint8, payload []byte) (Message, error) {
+	return m, codec.UnmarshalVersion(payload, m, int(version))
+}
+
+// ************** GuessMessage **************
+
+func (m *GuessMessage) Type() uint8 {
+	return m.MessageType
+}
+
+func (m *GuessMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeGuessMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&GuessMessage{MessageType: msgType}, version, payload)
+}
+
+// ************** Rooms **************
+
+// Settings (aka rules) for a room, as sent on the wire
+type RoomSettings struct {
+	MinNumber   int32 // Target is picked from [MinNumber, MaxNumber)
+	MaxNumber   int32
+	MaxGuesses  uint32 // Guesses per player per round, 0 for no limit
+	TimeLimitMs uint32 // Length of each round, 0 for no limit
+
+	TurnBased     bool
+	TurnTimeoutMs uint32 // Length of each turn, 0 for no limit
+}
+
+type RoomInfo struct {
+	Name       string
+	Settings   RoomSettings
+	NumPlayers uint16
+}
+
+// Client -> server:  list all rooms (no payload)
+type ListRoomsMessage struct{}
+
+func (m *ListRoomsMessage) Type() uint8 { return MessageTypeListRooms }
+
+func (m *ListRoomsMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeListRoomsMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&ListRoomsMessage{}, version, payload)
+}
+
+// Server -> client:  reply to ListRooms
+type RoomListMessage struct {
+	Rooms []RoomInfo
+}
+
+func (m *RoomListMessage) Type() uint8 { return MessageTypeRoomList }
+
+func (m *RoomListMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeRoomListMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&RoomListMessage{}, version, payload)
+}
+
+// Client -> server:  create a room and join it
+type CreateRoomMessage struct {
+	Name     string
+	Settings RoomSettings
+}
+
+func (m *CreateRoomMessage) Type() uint8 { return MessageTypeCreateRoom }
+
+func (m *CreateRoomMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeCreateRoomMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&CreateRoomMessage{}, version, payload)
+}
+
+// Client -> server:  join an existing room (leaving the current one)
+type JoinRoomMessage struct {
+	Name string
+}
+
+func (m *JoinRoomMessage) Type() uint8 { return MessageTypeJoinRoom }
+
+func (m *JoinRoomMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeJoinRoomMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&JoinRoomMessage{}, version, payload)
+}
+
+// Client -> server:  go back to the lobby (no payload)
+type LeaveRoomMessage struct{}
+
+func (m *LeaveRoomMessage) Type() uint8 { return MessageTypeLeaveRoom }
+
+func (m *LeaveRoomMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeLeaveRoomMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&LeaveRoomMessage{}, version, payload)
+}
+
+// Server -> client:  reply to CreateRoom or JoinRoom
+type RoomJoinedMessage struct {
+	Name     string
+	Settings RoomSettings
+	Round    uint32   `wire:"since=4"` // The round that's going on now
+	Players  []string `wire:"since=5"` // Everyone in the room (including the client), in the order they joined
+}
+
+func (m *RoomJoinedMessage) Type() uint8 { return MessageTypeRoomJoined }
+
+func (m *RoomJoinedMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeRoomJoinedMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&RoomJoinedMessage{}, version, payload)
+}
+
+// Server -> client:  reply to LeaveRoom (no payload)
+type RoomLeftMessage struct{}
+
+func (m *RoomLeftMessage) Type() uint8 { return MessageTypeRoomLeft }
+
+func (m *RoomLeftMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeRoomLeftMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&RoomLeftMessage{}, version, payload)
+}
+
+// Server -> client:  the rules for a room.  Sent after RoomJoined,
+// and again to everyone in the room if the rules change.
+type RulesMessage struct {
+	Room     string
+	Settings RoomSettings
+}
+
+func (m *RulesMessage) Type() uint8 { return MessageTypeRules }
+
+func (m *RulesMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeRulesMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&RulesMessage{}, version, payload)
+}
+
+// Server -> client:  in turn-based rooms, whose turn it is now.  Sent
+// to everyone in the room whenever the turn changes.
+type TurnMessage struct {
+	Name          string // Player who can guess now
+	TurnTimeoutMs uint32 // How long they have, 0 for no limit
+	Skipped       string // Player who just ran out of time, if any
+}
+
+func (m *TurnMessage) Type() uint8 { return MessageTypeTurn }
+
+func (m *TurnMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeTurnMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&TurnMessage{}, version, payload)
+}
+
+// ************** Errors **************
+
+// Server -> client:  the last request couldn't be handled
+type ErrorMessage struct {
+	Code uint8
+	Text string
+}
+
+func (m *ErrorMessage) Type() uint8 { return MessageTypeError }
+
+func (m *ErrorMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeErrorMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&ErrorMessage{}, version, payload)
+}
+
+// ************** Handshake **************
+
+// Client -> server:  first message on every connection.  Name may be
+// empty, in which case the server picks one.  To pick up an old
+// session after reconnecting, send the token from its Welcome.
+type HelloMessage struct {
+	Name        string
+	Versions    []uint8 `wire:"len=8"` // Protocol versions the client can speak (1-byte count)
+	ResumeToken string  // Empty to start a new session
+}
+
+func (m *HelloMessage) Type() uint8 { return MessageTypeHello }
+
+func (m *HelloMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeHelloMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&HelloMessage{}, version, payload)
+}
+
+// Server -> client:  reply to Hello if the server accepts the client
+type WelcomeMessage struct {
+	Version     uint8  // Protocol version the server picked
+	SessionId   uint32 // Identifies this client for the rest of the session
+	Name        string // Name the server will use for this client
+	ResumeToken string // Send this in a Hello to resume the session later
+
+	// If the client asked to resume a session, what we restored
+	Resumed      bool
+	Room         string // Room the client is back in (empty for the lobby)
+	RoundGuesses uint32 // Guesses the client already made this round
+}
+
+func (m *WelcomeMessage) Type() uint8 { return MessageTypeWelcome }
+
+func (m *WelcomeMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeWelcomeMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&WelcomeMessage{}, version, payload)
+}
+
+// Server -> client:  someone joined or left the client's room
+type PlayerEventMessage struct {
+	Event uint8
+	Name  string
+}
+
+func (m *PlayerEventMessage) Type() uint8 { return MessageTypePlayer }
+
+func (m *PlayerEventMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodePlayerEventMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&PlayerEventMessage{}, version, payload)
+}
+
+// ************** Round results **************
+
+type PlayerGuesses struct {
+	Name    string
+	Guesses uint32
+}
+
+// Server -> client:  sent to everyone in a room when a round ends,
+// right before the new game message
+type RoundOverMessage struct {
+	Round        uint32 `wire:"since=4"` // The round that ended
+	Reason       uint8  // RoundOverWon, RoundOverTimeUp, ...
+	Winner       string // Empty if nobody won
+	Target       int32
+	TotalGuesses uint32
+	Players      []PlayerGuesses // Guesses per player this round
+}
+
+func (m *RoundOverMessage) Type() uint8 { return MessageTypeRoundOver }
+
+func (m *RoundOverMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeRoundOverMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&RoundOverMessage{}, version, payload)
+}
+
+// ************** Statistics **************
+
+// One player's statistics, as sent on the wire
+type PlayerStats struct {
+	Name           string
+	RoundsPlayed   uint32
+	RoundsWon      uint32
+	WinningGuesses uint32 // Total guesses over all rounds won
+	BestRound      uint32 // Fewest guesses in a winning round (0 if never won)
+}
+
+func (p *PlayerStats) AverageGuessesPerWin() float64 {
+	if p.RoundsWon == 0 {
+		return 0
+	}
+	return float64(p.WinningGuesses) / float64(p.RoundsWon)
+}
+
+// Client -> server:  ask for the top Count players
+type GetTopMessage struct {
+	Count uint16
+}
+
+func (m *GetTopMessage) Type() uint8 { return MessageTypeGetTop }
+
+func (m *GetTopMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeGetTopMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&GetTopMessage{}, version, payload)
+}
+
+// Server -> client:  reply to GetTop, best player first
+type TopMessage struct {
+	Players []PlayerStats
+}
+
+func (m *TopMessage) Type() uint8 { return MessageTypeTop }
+
+func (m *TopMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeTopMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&TopMessage{}, version, payload)
+}
+
+// Client -> server:  ask for one player's stats (empty name means
+// the client's own stats)
+type GetStatsMessage struct {
+	Name string
+}
+
+func (m *GetStatsMessage) Type() uint8 { return MessageTypeGetStats }
+
+func (m *GetStatsMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeGetStatsMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&GetStatsMessage{}, version, payload)
+}
+
+// Server -> client:  reply to GetStats
+type StatsMessage struct {
+	Stats PlayerStats
+}
+
+func (m *StatsMessage) Type() uint8 { return MessageTypeStats }
+
+func (m *StatsMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeStatsMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&StatsMessage{}, version, payload)
+}
+
+// ************** Keepalives **************
+
+// Either side can send a ping at any time, and the other side must
+// answer with a pong carrying the same sequence number.  Pings keep
+// idle connections busy, so a peer that stops answering can be detected.
+type PingMessage struct {
+	MessageType uint8 `wire:"-"` // MessageTypePing or MessageTypePong
+	Seq         uint32
+}
+
+func (m *PingMessage) Type() uint8 { return m.MessageType }
+
+func (m *PingMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodePingMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&PingMessage{MessageType: msgType}, version, payload)
+}
+
+// ************** Spectators **************
+
+// Client -> server (Spectate):  stop playing, and watch a room instead
+// (or every room, if Room is empty).  Spectators aren't in any room, so
+// they can't guess, and nobody waits for their turn.  Joining or
+// leaving a room stops spectating.
+//
+// Server -> client (Spectating):  the reply, after which the client
+// gets a GameEventMessage for everything that happens in the room(s).
+type SpectateMessage struct {
+	MessageType uint8 `wire:"-"` // MessageTypeSpectate or MessageTypeSpectating
+	Room        string
+}
+
+func (m *SpectateMessage) Type() uint8 { return m.MessageType }
+
+func (m *SpectateMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeSpectateMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&SpectateMessage{MessageType: msgType}, version, payload)
+}
+
+// Server -> spectator:  something happened in a room.  What Number
+// and Result mean depends on the event:
+//
+//	GameEventGuess:      Player guessed Number, and got Result (like
+//	                     a response:  GuessTooHigh, GuessCorrect, ...)
+//	GameEventJoined:     Player joined the room
+//	GameEventLeft:       Player left the room
+//	GameEventRoundOver:  The round ended.  Player is the winner (if
+//	                     any), Number is the target, Result is the
+//	                     reason (RoundOverWon, ...), and Guesses is how
+//	                     many guesses everyone made in total.
+type GameEventMessage struct {
+	Time    int64 // When it happened, in Unix milliseconds
+	Room    string
+	Round   uint32 // The round it happened in
+	Event   uint8  // GameEventGuess, GameEventJoined, ...
+	Player  string
+	Number  int32
+	Result  int32
+	Guesses uint32
+}
+
+func (m *GameEventMessage) Type() uint8 { return MessageTypeGameEvent }
+
+func (m *GameEventMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeGameEventMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&GameEventMessage{}, version, payload)
+}
+
+// ************** Shutdown **************
+
+// Server -> client:  the server is shutting down, and will close the
+// connection once it's sent everything already queued
+type ShutdownMessage struct {
+	Reason string
+}
+
+func (m *ShutdownMessage) Type() uint8 { return MessageTypeShutdown }
+
+func (m *ShutdownMessage) MarshalPayload(version uint8) ([]byte, error) {
+	return codec.MarshalVersion(m, int(version))
+}
+
+func decodeShutdownMessage(msgType, version uint8, payload []byte) (Message, error) {
+	return decodeFields(&ShutdownMessage{}, version, payload)
+}
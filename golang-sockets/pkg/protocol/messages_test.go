@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var testSettings = RoomSettings{
+	MinNumber:     1,
+	MaxNumber:     100,
+	MaxGuesses:    7,
+	TimeLimitMs:   60000,
+	TurnBased:     true,
+	TurnTimeoutMs: 30000,
+}
+
+// One of every message, with its frame.  Frames for messages older
+// than pkg/codec came from the hand-written encoders it replaced, so
+// moving onto it mustn't change a single byte on the wire.
+var goldenFrames = []struct {
+	msg   Message
+	frame string
+}{
+	{&GuessMessage{MessageType: MessageTypeGuess, Number: -5, Round: 3}, "05000008fffffffb00000003"},
+	{&GuessMessage{MessageType: MessageTypeResponse, Number: 1, Round: 3}, "050100080000000100000003"},
+	{&GuessMessage{MessageType: MessageTypeNewGame, Number: 0, Round: 4}, "050200080000000000000004"},
+	{&ListRoomsMessage{}, "05030000"},
+	{&RoomListMessage{Rooms: []RoomInfo{
+		{Name: "main", Settings: testSettings, NumPlayers: 3},
+		{Name: "b"},
+	}}, "05040039000200046d61696e0000000100000064000000070000ea60010000753000030001620000000000000000000000000000000000000000000000"},
+	{&CreateRoomMessage{Name: "fast", Settings: testSettings}, "0505001b0004666173740000000100000064000000070000ea600100007530"},
+	{&JoinRoomMessage{Name: "main"}, "0506000600046d61696e"},
+	{&LeaveRoomMessage{}, "05070000"},
+	{&RoomJoinedMessage{Name: "main", Settings: testSettings, Round: 9, Players: []string{"al", "bob"}},
+		"0508002a00046d61696e0000000100000064000000070000ea600100007530000000090002" + "0002616c0003626f62"},
+	{&RoomLeftMessage{}, "05090000"},
+	{&ErrorMessage{Code: ErrorCodeNotYourTurn, Text: "wait"}, "050a00070a000477616974"},
+	{&HelloMessage{Name: "bob", Versions: []uint8{4, 3}, ResumeToken: "tok"}, "050b000d0003626f620204030003746f6b"},
+	{&WelcomeMessage{Version: 4, SessionId: 77, Name: "bob", ResumeToken: "tok",
+		Resumed: true, Room: "main", RoundGuesses: 2}, "050c001a040000004d0003626f620003746f6b0100046d61696e00000002"},
+	{&PlayerEventMessage{Event: PlayerEventLeft, Name: "al"}, "050d0005010002616c"},
+	{&RoundOverMessage{Round: 5, Reason: RoundOverWon, Winner: "al", Target: 42, TotalGuesses: 9,
+		Players: []PlayerGuesses{{"al", 4}, {"bob", 5}}}, "050e002400000005000002616c0000002a0000000900020002616c000000040003626f6200000005"},
+	{&GetTopMessage{Count: 10}, "050f0002000a"},
+	{&TopMessage{Players: []PlayerStats{{"al", 10, 3, 20, 4}}}, "0510001600010002616c0000000a000000030000001400000004"},
+	{&GetStatsMessage{Name: "al"}, "051100040002616c"},
+	{&StatsMessage{Stats: PlayerStats{Name: "bob", RoundsPlayed: 1}}, "051200150003626f6200000001000000000000000000000000"},
+	{&PingMessage{MessageType: MessageTypePing, Seq: 12}, "051300040000000c"},
+	{&PingMessage{MessageType: MessageTypePong, Seq: 12}, "051400040000000c"},
+	{&RulesMessage{Room: "main", Settings: testSettings}, "0515001b00046d61696e0000000100000064000000070000ea600100007530"},
+	{&TurnMessage{Name: "al", TurnTimeoutMs: 30000, Skipped: "bob"}, "0516000d0002616c000075300003626f62"},
+	{&SpectateMessage{MessageType: MessageTypeSpectate, Room: "main"}, "0517000600046d61696e"},
+	{&SpectateMessage{MessageType: MessageTypeSpectating}, "051800020000"},
+	{&GameEventMessage{Time: 1700000000123, Room: "main", Round: 2, Event: GameEventGuess,
+		Player: "al", Number: 50, Result: -1, Guesses: 3}, "051900230000018bcfe5687b00046d61696e00000002000002616c00000032ffffffff00000003"},
+	{&ShutdownMessage{Reason: "bye"}, "051a00050003627965"},
+}
+
+func TestGoldenFrames(t *testing.T) {
+	for _, tc := range goldenFrames {
+		name := MessageName(tc.msg.Type())
+
+		frame, err := MarshalFrame(tc.msg, ProtocolVersion)
+		if err != nil {
+			t.Errorf("%s:  MarshalFrame:  %v", name, err)
+			continue
+		}
+		if got := hex.EncodeToString(frame); got != tc.frame {
+			t.Errorf("%s:  got frame\n\t%s\nwant\n\t%s", name, got, tc.frame)
+		}
+
+		// And back again
+		want, _ := hex.DecodeString(tc.frame)
+		header := UnmarshalHeader(want)
+		m, err := UnmarshalFrame(header, want[HeaderSize:])
+		if err != nil {
+			t.Errorf("%s:  UnmarshalFrame:  %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, tc.msg) {
+			t.Errorf("%s:  decoded %#v, want %#v", name, m, tc.msg)
+		}
+	}
+}
+
+func TestEveryTypeHasGoldenFrame(t *testing.T) {
+	tested := make(map[uint8]bool)
+	for _, tc := range goldenFrames {
+		tested[tc.msg.Type()] = true
+	}
+	for msgType := range registry {
+		if !tested[msgType] {
+			t.Errorf("no golden frame for %s", MessageName(msgType))
+		}
+	}
+}
+
+// Frames for clients that speak an older version leave out what that
+// version didn't have yet, and we can read theirs
+func TestOlderVersion(t *testing.T) {
+	joined := &RoomJoinedMessage{Name: "main", Settings: testSettings, Round: 9, Players: []string{"al"}}
+	cases := []struct {
+		version uint8
+		msg     Message
+		frame   string
+		want    Message
+	}{
+		{3, &GuessMessage{MessageType: MessageTypeGuess, Number: -5, Round: 3}, "03000004fffffffb",
+			&GuessMessage{MessageType: MessageTypeGuess, Number: -5}},
+		{3, joined, "0308001b00046d61696e0000000100000064000000070000ea600100007530",
+			&RoomJoinedMessage{Name: "main", Settings: testSettings}},
+		{4, joined, "0408001f00046d61696e0000000100000064000000070000ea60010000753000000009",
+			&RoomJoinedMessage{Name: "main", Settings: testSettings, Round: 9}},
+		{3, &RoundOverMessage{Round: 5, Reason: RoundOverWon, Winner: "al", Target: 42, TotalGuesses: 9},
+			"030e000f000002616c0000002a000000090000",
+			&RoundOverMessage{Reason: RoundOverWon, Winner: "al", Target: 42, TotalGuesses: 9}},
+		{3, &TurnMessage{Name: "al"}, "0316000a0002616c000000000000", &TurnMessage{Name: "al"}},
+	}
+
+	for _, tc := range cases {
+		name := fmt.Sprintf("%s (version %d)", MessageName(tc.msg.Type()), tc.version)
+
+		frame, err := MarshalFrame(tc.msg, tc.version)
+		if err != nil {
+			t.Errorf("%s:  MarshalFrame:  %v", name, err)
+			continue
+		}
+		if got := hex.EncodeToString(frame); got != tc.frame {
+			t.Errorf("%s:  got frame\n\t%s\nwant\n\t%s", name, got, tc.frame)
+		}
+
+		m, err := UnmarshalFrame(UnmarshalHeader(frame), frame[HeaderSize:])
+		if err != nil {
+			t.Errorf("%s:  UnmarshalFrame:  %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, tc.want) {
+			t.Errorf("%s:  decoded %#v, want %#v", name, m, tc.want)
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	if got := SupportedVersions(); !bytes.Equal(got, []uint8{5, 4, 3}) {
+		t.Errorf("we support %v, want [5 4 3]", got)
+	}
+
+	cases := []struct {
+		offered []uint8
+		want    uint8
+		ok      bool
+	}{
+		{[]uint8{5, 4, 3}, 5, true},
+		{[]uint8{4, 3}, 4, true},
+		{[]uint8{3}, 3, true},
+		{[]uint8{2, 3, 9}, 3, true},
+		{[]uint8{9}, 0, false},
+		{[]uint8{1, 2}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := NegotiateVersion(tc.offered)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("offered %v:  got %d (%v), want %d (%v)", tc.offered, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestLegacyGuess(t *testing.T) {
+	m := &GuessMessage{MessageType: MessageTypeResponse, Number: -1, Round: 7}
+	buf, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(buf); got != "01ffffffff" {
+		t.Errorf("got %s, want 01ffffffff", got)
+	}
+	if GuessMessageSize != 5 {
+		t.Errorf("GuessMessageSize is %d, want 5", GuessMessageSize)
+	}
+}
+
+func TestMalformedPayloads(t *testing.T) {
+	cases := []struct {
+		name  string
+		frame string
+	}{
+		{"short guess", "04000004fffffffb"},
+		{"long guess", "04000009fffffffb0000000300"},
+		{"payload on list rooms", "0403000100"},
+		{"string past the end", "0406000400056d61"},
+		{"bad bool", "040c001a040000004d0003626f620003746f6b0200046d61696e00000002"},
+		{"huge room count", "04040002ffff"},
+	}
+
+	for _, tc := range cases {
+		frame, _ := hex.DecodeString(tc.frame)
+		_, err := UnmarshalFrame(UnmarshalHeader(frame), frame[HeaderSize:])
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("%s:  got %v, want ErrMalformedMessage", tc.name, err)
+		}
+	}
+}
+
+// Anything that decodes has to encode back to exactly the same bytes,
+// and nothing should make the decoders panic
+func FuzzUnmarshalFrame(f *testing.F) {
+	for _, tc := range goldenFrames {
+		frame, _ := hex.DecodeString(tc.frame)
+		f.Add(frame[0], frame[1], frame[HeaderSize:])
+		f.Add(uint8(MinProtocolVersion), frame[1], frame[HeaderSize:])
+	}
+
+	f.Fuzz(func(t *testing.T, version, msgType uint8, payload []byte) {
+		if len(payload) > MaxPayloadSize {
+			return
+		}
+		header := Header{Version: version, MessageType: msgType, Length: uint16(len(payload))}
+		m, err := UnmarshalFrame(header, payload)
+		if err != nil {
+			return
+		}
+
+		again, err := m.MarshalPayload(version)
+		if err != nil {
+			t.Fatalf("%s decoded, but doesn't encode:  %v", MessageName(msgType), err)
+		}
+		if !bytes.Equal(again, payload) {
+			t.Fatalf("%s:  decoded %x, but it encodes to %x", MessageName(msgType), payload, again)
+		}
+	})
+}
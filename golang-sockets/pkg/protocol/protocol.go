@@ -3,117 +3,107 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
-	"log"
 	"net"
-	"os"
 	"time"
+
+	"golang-sockets/pkg/codec"
 )
 
 // A struct to represent our messages
+// On its own (see Marshal), this is the original fixed-size 5-byte
+// format.  It can also be sent inside a frame (see framing.go), along
+// with the round it belongs to.
 type GuessMessage struct {
-	MessageType uint8
+	MessageType uint8 `wire:"-"` // In frames, this goes in the header
 	Number      int32
+	Round       uint32 `wire:"since=4"` // Only sent in frames (0 in a guess means "the current round")
 }
 
 const (
 	MessageTypeGuess    = 0
 	MessageTypeResponse = 1
 	MessageTypeNewGame  = 2
-
-	GuessMessageSize = 5
 )
 
+// The original format:  just the type and the number
+type legacyGuess struct {
+	MessageType uint8
+	Number      int32
+}
+
+// Size of the original format (5 bytes)
+var GuessMessageSize = codec.MustSize(legacyGuess{})
+
 // In order to send our message out on the wire, we need to
 // turn it into a byte stream
 //
 // Method 1
-func (m *GuessMessage) Marshal() []byte {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, m.MessageType)
-	if err != nil {
-		log.Fatalln("Marshal failed:  ", err)
-	}
-
-	err = binary.Write(buf, binary.BigEndian, m.Number)
-	if err != nil {
-		log.Fatalln("Marshal failed:  ", err)
-	}
-
-	return buf.Bytes()
+func (m *GuessMessage) Marshal() ([]byte, error) {
+	return codec.Marshal(&legacyGuess{MessageType: m.MessageType, Number: m.Number})
 }
 
-func SendGuess(num int, conn net.Conn) {
+// Method 2:  the type, then the number, in separate writes (like the
+// original client, and the "original" dialect in legacy.go)
+func SendGuess(num int, conn net.Conn) error {
 	buf1 := new(bytes.Buffer)
 	err := binary.Write(buf1, binary.BigEndian, uint8(MessageTypeGuess))
 	if err != nil {
-		log.Fatalln("Marshal failed:  ", err)
+		return err
 	}
 	_, err = conn.Write(buf1.Bytes())
 	if err != nil {
-		log.Fatalln("Write", err)
+		return ConnError(err, false)
 	}
 
 	buf2 := new(bytes.Buffer)
 	err = binary.Write(buf2, binary.BigEndian, int32(num))
 	if err != nil {
-		log.Fatalln("Marshal failed:  ", err)
+		return err
 	}
 	_, err = conn.Write(buf2.Bytes())
-	if err != nil {
-		log.Fatalln("Write", err)
-	}
+	return ConnError(err, false)
 }
 
-func RecvAll(conn net.Conn, buffer []byte, n int, timeout bool) (int, error) {
-	if timeout {
+// Read until buffer is full.  If timeout is nonzero, give up if the
+// data doesn't arrive within that long.
+func RecvAll(conn net.Conn, buffer []byte, n int, timeout time.Duration) (int, error) {
+	if timeout > 0 {
 		// Let's say we want to optionally have this read timeout if nothing was received
-		// within 5 seconds
-		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// within some amount of time
+		conn.SetReadDeadline(time.Now().Add(timeout))
 	}
 	bytesRead, err := io.ReadFull(conn, buffer)
-	if timeout {
+	if timeout > 0 {
 		// Remove the timeout deadline so that other reads
 		// on this socket aren't affected
 		conn.SetReadDeadline(time.Time{})
 	}
 
-	// Check for various error conditions
-	// We might want to handle these in some way here; however, it might
-	// be better to pass them up to the caller to handle them in a more graceful way
-	// (ie, like stopping other parts of the application)
-	if err == io.EOF {
-		log.Println("Connection closed")
-		return bytesRead, io.EOF
-	} else if os.IsTimeout(err) {
-		// Could handle timeouts differently here, if desired
-		return bytesRead, err
-	} else if err != nil {
-		return bytesRead, err
-	} else { // No error
-		return bytesRead, nil
-	}
+	// We could handle some of these here (like logging that the
+	// connection closed), but only the caller knows whether it can carry
+	// on, so we just say which kind of error it was (see errors.go) and
+	// pass it up
+	return bytesRead, ConnError(err, bytesRead > 0)
 }
 
-func ReadGuessMessage(conn net.Conn, timeout bool) (GuessMessage, error) {
+func ReadGuessMessage(conn net.Conn, timeout time.Duration) (GuessMessage, error) {
 	// Our messages are all the same size--but what would happen if they weren't?
 
 	buffer := make([]byte, GuessMessageSize)
 
 	//bytesRead, err := conn.Read(buffer)
-	bytesRead, err := RecvAll(conn, buffer, GuessMessageSize, timeout)
-
-	log.Printf("Read %d bytes\n", bytesRead)
-
-	if err == io.EOF {
-		log.Println("Connection closed")
-		return GuessMessage{}, io.EOF
-	} else if err != nil {
+	_, err := RecvAll(conn, buffer, GuessMessageSize, timeout)
+	if err != nil {
 		return GuessMessage{}, err
 	}
 
-	msg := GuessMessage{MessageType: buffer[0],
-		Number: int32(binary.BigEndian.Uint32(buffer[1:]))}
+	legacy := legacyGuess{}
+	err = codec.Unmarshal(buffer, &legacy)
+	if err != nil {
+		return GuessMessage{}, fmt.Errorf("%w:  %v", ErrMalformedMessage, err)
+	}
 
-	return msg, nil
+	return GuessMessage{MessageType: legacy.MessageType, Number: legacy.Number}, nil
 }
@@ -0,0 +1,525 @@
+// Package rudp ("reliable UDP") runs a TCP-like byte stream over UDP,
+// so the guessing game can use the same framing (see
+// pkg/protocol/framing.go) over either transport.  A Conn is a
+// net.Conn, and a Listener is a net.Listener.
+//
+// UDP datagrams can be lost, duplicated or arrive out of order, so:
+//
+//   - Every chunk of data we send gets a sequence number, and stays in
+//     our "unacked" table until the peer acknowledges it.  If the ack
+//     doesn't come back in time, we send it again, waiting twice as
+//     long each time.  A peer that never answers is given up on.
+//   - The receiver acknowledges every numbered packet, even ones it has
+//     already seen (in case the first ack was lost), but only delivers
+//     each sequence number once, and in order.  Packets that arrive
+//     early wait until the gaps before them are filled.
+//   - At most sendWindow packets can be in flight at once, so we don't
+//     flood a slow peer (or network).
+//   - If the app on the other end isn't reading, the peer answers our
+//     next packet with Busy instead of an ack.  We keep offering it
+//     the packet every probeInterval (like TCP's zero window probes),
+//     but a peer that's busy is still there, so that doesn't count
+//     towards giving up on it.
+//
+// See packet.go for the wire format.
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sendWindow    = 64          // Packets we can have in flight at once
+	maxReadBuffer = 1024 * 1024 // Stop accepting data the app hasn't read past this many bytes
+
+	initialRTO = 200 * time.Millisecond // Retransmit timeout before we've measured anything
+	minRTO     = 50 * time.Millisecond
+	maxRTO     = 2 * time.Second
+	maxRetries = 8 // Give up on a packet after sending it this many more times
+
+	probeInterval = 200 * time.Millisecond // How often we offer a busy peer its next packet
+
+	tickInterval  = 10 * time.Millisecond // How often we check for packets to resend
+	closeTimeout  = 2 * time.Second       // How long Close waits for the peer to get everything
+	lingerTimeout = 10 * time.Second      // After Close, how long we wait for the peer to finish
+	timeWait      = 2 * maxRTO            // After finishing, how long we still answer the peer
+)
+
+var (
+	ErrPeerGone = errors.New("peer stopped responding")
+	ErrReset    = errors.New("connection reset by peer")
+)
+
+// Error for reads and writes that hit their deadline.  It has a
+// Timeout method, so os.IsTimeout recognizes it, just like the errors
+// from a TCP socket.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// A numbered packet we've sent, but haven't heard back about yet
+type outPacket struct {
+	data    []byte // The whole datagram, ready to resend
+	sentAt  time.Time
+	rto     time.Duration // How long to wait for the ack this time
+	retries int
+}
+
+type Conn struct {
+	id     uint32
+	local  net.Addr
+	remote net.Addr
+	send   func([]byte) // Send one datagram to the peer
+	onDone func()       // Called (in its own goroutine) when the connection is finished, or timeWait after that if it finished cleanly
+
+	// Serialize whole reads and writes, so that one Write's data is
+	// never interleaved with another's
+	readLock  sync.Mutex
+	writeLock sync.Mutex
+
+	lock sync.Mutex
+
+	// Sending
+	nextSeq uint32 // Sequence number for the next packet we send
+	unacked map[uint32]*outPacket
+
+	// Receiving
+	expected uint32            // Next sequence number to deliver
+	early    map[uint32]packet // Arrived before the packets in front of them
+	readBuf  bytes.Buffer      // Delivered, but not read by the app yet
+	gotFin   bool              // The peer is done sending
+
+	// Round trip time estimate (RFC 6298), to pick retransmit timeouts
+	srtt, rttvar time.Duration
+	rto          time.Duration
+
+	closed   bool // Close was called (at closedAt)
+	closedAt time.Time
+	err      error // Why the connection failed (nil if it didn't)
+	finished bool  // Nothing more will be sent or received
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readable chan struct{} // Poked when there might be something new for Read
+	writable chan struct{} // Poked when there might be room in the window
+	done     chan struct{} // Closed when the connection is finished
+}
+
+func newConn(id uint32, local, remote net.Addr, send func([]byte), onDone func()) *Conn {
+	c := &Conn{
+		id:       id,
+		local:    local,
+		remote:   remote,
+		send:     send,
+		onDone:   onDone,
+		nextSeq:  1,
+		unacked:  make(map[uint32]*outPacket),
+		expected: 1,
+		early:    make(map[uint32]packet),
+		rto:      initialRTO,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go c.retransmitLoop()
+
+	return c
+}
+
+// Wake up a goroutine waiting on ch, if there is one
+func poke(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Wait until ch is poked, the connection finishes, or the deadline
+// passes.  Must be called with c.lock held, which is released while
+// we wait.
+func (c *Conn) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return timeoutError{}
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	c.lock.Unlock()
+	defer c.lock.Lock()
+
+	select {
+	case <-ch:
+	case <-c.done:
+	case <-timeout:
+		return timeoutError{}
+	}
+	return nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for {
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+		if c.readBuf.Len() > 0 {
+			return c.readBuf.Read(b)
+		}
+		if c.gotFin {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		err := c.wait(c.readable, c.readDeadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > MaxPayload {
+			n = MaxPayload
+		}
+		err := c.sendData(b[:n])
+		if err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+// Send one packet's worth of data, once there's room in the window
+func (c *Conn) sendData(chunk []byte) error {
+	c.lock.Lock()
+
+	for {
+		if c.closed {
+			c.lock.Unlock()
+			return net.ErrClosed
+		}
+		if c.err != nil {
+			c.lock.Unlock()
+			return c.err
+		}
+		if len(c.unacked) < sendWindow {
+			break
+		}
+
+		err := c.wait(c.writable, c.writeDeadline)
+		if err != nil {
+			c.lock.Unlock()
+			return err
+		}
+	}
+
+	data := c.queueLocked(kindData, chunk)
+	c.lock.Unlock()
+
+	c.send(data)
+	return nil
+}
+
+// Number a packet and remember it until it's acknowledged.  Returns
+// the datagram to send.  Should only be called when c.lock is held.
+func (c *Conn) queueLocked(kind uint8, payload []byte) []byte {
+	p := packet{kind: kind, id: c.id, seq: c.nextSeq, payload: payload}
+	c.nextSeq++
+
+	data := p.marshal()
+	c.unacked[p.seq] = &outPacket{
+		data:   data,
+		sentAt: time.Now(),
+		rto:    c.rto,
+	}
+
+	return data
+}
+
+// Handle a packet from the peer (called by the listener or dialer's
+// read loop)
+func (c *Conn) handlePacket(p packet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.finished {
+		// Like TCP's TIME_WAIT:  the peer might not have heard our ack
+		// for its Fin, in which case it keeps sending it until it does
+		if (p.kind == kindData || p.kind == kindFin) && p.seq < c.expected {
+			c.send(control(kindAck, c.id, p.seq))
+		}
+		return
+	}
+
+	switch p.kind {
+	case kindData, kindFin:
+		reply := c.receiveLocked(p)
+		if reply != 0 {
+			c.send(control(reply, c.id, p.seq))
+		}
+
+	case kindAck:
+		out, ok := c.unacked[p.seq]
+		if !ok {
+			return // Duplicate ack
+		}
+		// Karn's rule:  if we sent it more than once, we can't tell
+		// which one was acked, so it doesn't tell us the round trip time
+		if out.retries == 0 {
+			c.updateRTTLocked(time.Since(out.sentAt))
+		}
+		delete(c.unacked, p.seq)
+		poke(c.writable)
+		c.checkFinishedLocked()
+
+	case kindBusy:
+		out, ok := c.unacked[p.seq]
+		if !ok {
+			return
+		}
+		// Not lost, just not wanted yet, so try again soon
+		out.retries = 0
+		out.sentAt = time.Now()
+		out.rto = probeInterval
+
+	case kindRst:
+		c.failLocked(ErrReset)
+	}
+}
+
+// Take in a numbered packet.  Returns what to answer it with:  an Ack,
+// Busy if we had no room for it, or 0 if we dropped it without a word.
+func (c *Conn) receiveLocked(p packet) uint8 {
+	if p.seq < c.expected {
+		return kindAck // Duplicate:  already delivered, but our ack might have been lost
+	}
+	if p.seq >= c.expected+2*sendWindow {
+		return 0 // Way ahead of anything the peer should be sending
+	}
+	if c.readBuf.Len() > maxReadBuffer {
+		// The app isn't keeping up, so make the peer wait.  That goes
+		// for packets after a gap too:  if we took those, the peer's
+		// window would move on past the gap, and it'd send us packets
+		// even further ahead.
+		return kindBusy
+	}
+
+	c.early[p.seq] = p
+
+	// Deliver everything we can, in order
+	for {
+		next, ok := c.early[c.expected]
+		if !ok {
+			break
+		}
+		delete(c.early, c.expected)
+		c.expected++
+
+		if next.kind == kindFin {
+			c.gotFin = true
+		} else {
+			c.readBuf.Write(next.payload)
+		}
+		poke(c.readable)
+	}
+	c.checkFinishedLocked()
+
+	return kindAck
+}
+
+// RFC 6298:  smoothed round trip time, plus four times its variation
+func (c *Conn) updateRTTLocked(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		diff := c.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (3*c.rttvar + diff) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	} else if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+}
+
+// Resend anything that's taking too long to be acknowledged, until the
+// connection is finished
+func (c *Conn) retransmitLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		var resend [][]byte
+		now := time.Now()
+
+		c.lock.Lock()
+		for _, out := range c.unacked {
+			if now.Sub(out.sentAt) < out.rto {
+				continue
+			}
+			if out.retries >= maxRetries {
+				c.failLocked(ErrPeerGone)
+				resend = nil
+				break
+			}
+			out.retries++
+			out.sentAt = now
+			out.rto *= 2
+			if out.rto > maxRTO {
+				out.rto = maxRTO
+			}
+			resend = append(resend, out.data)
+		}
+		if c.closed && now.Sub(c.closedAt) > lingerTimeout {
+			c.finishLocked()
+		}
+		c.lock.Unlock()
+
+		for _, data := range resend {
+			c.send(data)
+		}
+	}
+}
+
+// The connection is done once both sides have closed, and everything
+// we sent has been acknowledged
+func (c *Conn) checkFinishedLocked() {
+	if c.closed && c.gotFin && len(c.unacked) == 0 {
+		c.finishLocked()
+	}
+}
+
+func (c *Conn) failLocked(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.finishLocked()
+}
+
+func (c *Conn) finishLocked() {
+	if c.finished {
+		return
+	}
+	c.finished = true
+	c.unacked = nil
+	close(c.done)
+
+	// Keep the socket (or the listener's session) around for a while
+	// after a clean finish, so handlePacket can answer stragglers
+	if c.onDone != nil {
+		if c.err == nil {
+			time.AfterFunc(timeWait, c.onDone)
+		} else {
+			go c.onDone()
+		}
+	}
+}
+
+// Close our side of the connection.  Data we've already written is
+// still delivered (the peer reads it, then EOF), but reads and writes
+// on this side return net.ErrClosed from now on.
+//
+// Unlike with TCP, there's no kernel to keep resending our data once
+// our process exits, so Close waits (up to closeTimeout) for the peer
+// to acknowledge everything, including the close.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+
+	if c.closed {
+		c.lock.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.closedAt = time.Now()
+	poke(c.readable)
+	poke(c.writable)
+
+	if c.finished {
+		c.lock.Unlock()
+		return nil
+	}
+
+	data := c.queueLocked(kindFin, nil)
+	c.lock.Unlock()
+
+	c.send(data)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	deadline := time.Now().Add(closeTimeout)
+	for len(c.unacked) > 0 && !c.finished {
+		if c.wait(c.writable, deadline) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.readDeadline = t
+	poke(c.readable) // So a blocked Read notices the new deadline
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.writeDeadline = t
+	poke(c.writable)
+	return nil
+}
@@ -0,0 +1,268 @@
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Carries datagrams between two Conns in the same process, losing,
+// duplicating and reordering them the way a bad network would.  Every
+// datagram is delivered in its own goroutine, since Conns send while
+// holding their lock.
+type network struct {
+	loss    float64       // Chance of losing each datagram
+	dup     float64       // Chance of delivering one twice
+	jitter  time.Duration // Datagrams are delayed by up to this long, so they arrive out of order
+	lock    sync.Mutex
+	rand    *rand.Rand
+	dropped int
+}
+
+func (n *network) carry(data []byte, to func() *Conn) {
+	n.lock.Lock()
+	copies := 1
+	if n.rand.Float64() < n.loss {
+		copies = 0
+		n.dropped++
+	} else if n.rand.Float64() < n.dup {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		if n.jitter > 0 {
+			delays[i] = time.Duration(n.rand.Int63n(int64(n.jitter)))
+		}
+	}
+	n.lock.Unlock()
+
+	p, err := unmarshalPacket(data)
+	if err != nil {
+		panic(err)
+	}
+	for _, d := range delays {
+		time.AfterFunc(d, func() { to().handlePacket(p) })
+	}
+}
+
+// Two ends of a connection over n
+func pipe(n *network) (a, b *Conn) {
+	n.rand = rand.New(rand.NewSource(1))
+	aAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	bAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	a = newConn(7, aAddr, bAddr, func(data []byte) { n.carry(data, func() *Conn { return b }) }, nil)
+	b = newConn(7, bAddr, aAddr, func(data []byte) { n.carry(data, func() *Conn { return a }) }, nil)
+
+	return a, b
+}
+
+// Wait for c to finish, which should be well before it would give up
+// on its peer (lingerTimeout).  Returns why it failed, if it did.
+func waitDone(t *testing.T, name string, c *Conn) error {
+	select {
+	case <-c.done:
+	case <-time.After(lingerTimeout / 2):
+		t.Errorf("%s never finished", name)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Both ends should finish without an error
+func waitClean(t *testing.T, a, b *Conn) {
+	for _, c := range []struct {
+		name string
+		conn *Conn
+	}{{"a", a}, {"b", b}} {
+		if err := waitDone(t, c.name, c.conn); err != nil {
+			t.Errorf("%s failed:  %v", c.name, err)
+		}
+	}
+}
+
+var badNetworks = []struct {
+	name string
+	net  network
+}{
+	{"perfect", network{}},
+	{"lossy", network{loss: 0.1}},
+	{"duplicating", network{dup: 0.2}},
+	{"reordering", network{jitter: 20 * time.Millisecond}},
+	{"everything", network{loss: 0.1, dup: 0.1, jitter: 20 * time.Millisecond}},
+}
+
+// Whatever the network does, what comes out is exactly what went in,
+// then EOF, and both ends finish once they've closed
+func TestStream(t *testing.T) {
+	for i := range badNetworks {
+		tc := &badNetworks[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := pipe(&tc.net)
+			sent := make([]byte, 64*1024)
+			rand.New(rand.NewSource(2)).Read(sent)
+
+			go func() {
+				// Writes of all sizes, including ones split across packets
+				rest := sent
+				for size := 1; len(rest) > 0; size = size*3 + 1 {
+					if size > len(rest) {
+						size = len(rest)
+					}
+					if _, err := a.Write(rest[:size]); err != nil {
+						t.Errorf("write:  %v", err)
+						return
+					}
+					rest = rest[size:]
+				}
+				a.Close()
+			}()
+
+			got, err := io.ReadAll(b)
+			if err != nil {
+				t.Fatalf("read:  %v", err)
+			}
+			if !bytes.Equal(got, sent) {
+				t.Fatalf("read %d bytes, which aren't the %d we sent", len(got), len(sent))
+			}
+
+			b.Close()
+			waitClean(t, a, b)
+
+			if tc.net.loss > 0 && tc.net.dropped == 0 {
+				t.Errorf("the network didn't lose anything")
+			}
+		})
+	}
+}
+
+// Closing sends a Fin, which reaches the peer after the data, and
+// both ends can talk until they've both closed
+func TestCloseHandshake(t *testing.T) {
+	n := &network{loss: 0.1, jitter: 10 * time.Millisecond}
+	a, b := pipe(n)
+
+	if _, err := a.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("more")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after close:  got %v, want net.ErrClosed", err)
+	}
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("read after close:  got %v, want net.ErrClosed", err)
+	}
+
+	got, err := io.ReadAll(b)
+	if err != nil || string(got) != "ping" {
+		t.Fatalf("got %q (%v), want ping", got, err)
+	}
+	if _, err := b.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after EOF:  got %v, want EOF again", err)
+	}
+
+	// a is only done once b has closed too
+	select {
+	case <-a.done:
+		t.Fatalf("a finished before b closed")
+	default:
+	}
+
+	// b can still write, but nobody's reading on a any more
+	if _, err := b.Write([]byte("pong")); err != nil {
+		t.Errorf("write after peer closed:  %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("second close:  got %v, want net.ErrClosed", err)
+	}
+
+	waitClean(t, a, b)
+}
+
+func TestReset(t *testing.T) {
+	a, b := pipe(&network{})
+	// b won't answer a's Fin any more, so there's no point closing a
+	defer a.handlePacket(packet{kind: kindRst, id: 7})
+
+	b.handlePacket(packet{kind: kindRst, id: 7})
+	if _, err := b.Read(make([]byte, 1)); !errors.Is(err, ErrReset) {
+		t.Errorf("read:  got %v, want ErrReset", err)
+	}
+	if _, err := b.Write([]byte("x")); !errors.Is(err, ErrReset) {
+		t.Errorf("write:  got %v, want ErrReset", err)
+	}
+	if err := waitDone(t, "b", b); !errors.Is(err, ErrReset) {
+		t.Errorf("finished with %v, want ErrReset", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	a, b := pipe(&network{})
+	defer a.Close()
+	defer b.Close()
+
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := b.Read(make([]byte, 1))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
+
+// A reader that falls behind makes the writer wait, but the writer
+// doesn't mistake it for one that's gone
+func TestSlowReader(t *testing.T) {
+	a, b := pipe(&network{})
+	sent := make([]byte, maxReadBuffer+256*1024)
+	rand.New(rand.NewSource(3)).Read(sent)
+
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := a.Write(sent)
+		a.Close()
+		wrote <- err
+	}()
+
+	// Long enough for a packet that was just being dropped to have
+	// used up most of its retries
+	time.Sleep(1500 * time.Millisecond)
+
+	a.lock.Lock()
+	for seq, out := range a.unacked {
+		if out.retries >= 3 {
+			t.Errorf("packet %d has been sent %d more times", seq, out.retries)
+		}
+	}
+	if a.err != nil {
+		t.Errorf("writer failed:  %v", a.err)
+	}
+	a.lock.Unlock()
+
+	got, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatalf("read:  %v", err)
+	}
+	if !bytes.Equal(got, sent) {
+		t.Fatalf("read %d bytes, which aren't the %d we sent", len(got), len(sent))
+	}
+	if err := <-wrote; err != nil {
+		t.Errorf("write:  %v", err)
+	}
+
+	b.Close()
+	waitClean(t, a, b)
+}
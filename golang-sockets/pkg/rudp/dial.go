@@ -0,0 +1,131 @@
+package rudp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// How long Dial waits for the server to answer
+	DefaultDialTimeout = 5 * time.Second
+
+	// How long to wait before sending another Syn
+	synInterval = 250 * time.Millisecond
+)
+
+// Connect to a Listener, like net.Dial.  network should be "udp",
+// "udp4" or "udp6".
+func Dial(network, address string) (net.Conn, error) {
+	return DialTimeout(network, address, DefaultDialTimeout)
+}
+
+func DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// A "connected" UDP socket only talks to (and hears from) the server
+	udpConn, err := net.DialUDP(network, nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newConnId()
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	err = handshake(udpConn, id, timeout)
+	if err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("dial %s %s:  %w", network, address, err)
+	}
+
+	c := newConn(id, udpConn.LocalAddr(), addr,
+		func(data []byte) { udpConn.Write(data) },
+		func() { udpConn.Close() })
+	go dialReadLoop(udpConn, c)
+
+	return c, nil
+}
+
+// Pick a random connection ID
+func newConnId() (uint32, error) {
+	buf := make([]byte, 4)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf), nil
+}
+
+// Send Syns until the server answers with a SynAck (or a Rst)
+func handshake(udpConn *net.UDPConn, id uint32, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 65536)
+	syn := control(kindSyn, id, 0)
+
+	for time.Now().Before(deadline) {
+		udpConn.Write(syn)
+
+		wait := time.Now().Add(synInterval)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		udpConn.SetReadDeadline(wait)
+
+		for {
+			n, err := udpConn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // Time to send another Syn
+				}
+				return err // Probably "connection refused":  nobody's listening
+			}
+
+			p, err := unmarshalPacket(buf[:n])
+			if err != nil || p.id != id {
+				continue
+			}
+			if p.kind == kindRst {
+				return ErrReset
+			}
+			if p.kind == kindSynAck {
+				udpConn.SetReadDeadline(time.Time{})
+				return nil
+			}
+		}
+	}
+
+	return timeoutError{}
+}
+
+func dialReadLoop(udpConn *net.UDPConn, c *Conn) {
+	buf := make([]byte, 65536)
+
+	for {
+		n, err := udpConn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			// On a connected socket, this means the server's port is
+			// closed (the server went away), so there's no point waiting
+			c.lock.Lock()
+			c.failLocked(err)
+			c.lock.Unlock()
+			return
+		}
+
+		p, err := unmarshalPacket(buf[:n])
+		if err != nil || p.id != c.id || p.kind == kindSynAck {
+			continue // Not for us, or a duplicate SynAck
+		}
+		c.handlePacket(p)
+	}
+}
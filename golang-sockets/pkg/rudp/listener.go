@@ -0,0 +1,195 @@
+package rudp
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// How many connections can be waiting for Accept
+const acceptBacklog = 64
+
+// Accepts reliable UDP connections on one UDP socket.  Every peer
+// (source address) gets a session in the listener's table, and each
+// datagram is handed to the session it came from.
+//
+// Like a TCP listener, closing a Listener stops new connections, but
+// doesn't affect the ones that were already accepted:  the socket stays
+// open until they're all finished.
+type Listener struct {
+	conn *net.UDPConn
+
+	lock     sync.Mutex
+	sessions map[string]*Conn // By peer address
+	closed   bool
+
+	acceptChan chan *Conn
+	done       chan struct{} // Closed when Close is called
+}
+
+// Start listening on a UDP address, like net.Listen.  network should be
+// "udp", "udp4" or "udp6".
+func Listen(network, address string) (*Listener, error) {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		conn:       conn,
+		sessions:   make(map[string]*Conn),
+		acceptChan: make(chan *Conn, acceptBacklog),
+		done:       make(chan struct{}),
+	}
+	go l.readLoop()
+
+	return l, nil
+}
+
+// Wait for the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.acceptChan:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Stop accepting connections
+func (l *Listener) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.closed = true
+	close(l.done)
+
+	// Connections nobody accepted yet won't ever be
+	for {
+		select {
+		case c := <-l.acceptChan:
+			c.lock.Lock()
+			c.failLocked(ErrReset)
+			c.lock.Unlock()
+			continue
+		default:
+		}
+		break
+	}
+
+	if len(l.sessions) == 0 {
+		l.conn.Close()
+	}
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, 65536)
+
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Printf("rudp:  read error:  %v\n", err)
+			continue
+		}
+
+		p, err := unmarshalPacket(buf[:n])
+		if err != nil {
+			continue // Not for us
+		}
+		l.dispatch(p, addr)
+	}
+}
+
+// Hand a packet to the session for its address, starting a new session
+// if it's a Syn
+func (l *Listener) dispatch(p packet, addr *net.UDPAddr) {
+	key := addr.String()
+
+	l.lock.Lock()
+	c := l.sessions[key]
+
+	if p.kind == kindSyn {
+		if c != nil && c.id == p.id {
+			// Our SynAck must have been lost
+			l.lock.Unlock()
+			l.sendTo(control(kindSynAck, p.id, 0), addr)
+			return
+		}
+		if c != nil {
+			// The peer started over (maybe it restarted, and got the
+			// same port), so the old connection is dead
+			delete(l.sessions, key)
+			c.lock.Lock()
+			c.failLocked(ErrReset)
+			c.lock.Unlock()
+		}
+		if l.closed || len(l.acceptChan) == cap(l.acceptChan) {
+			l.lock.Unlock()
+			l.sendTo(control(kindRst, p.id, 0), addr)
+			return
+		}
+
+		c = l.newSession(p.id, key, addr)
+		l.acceptChan <- c
+		l.lock.Unlock()
+
+		l.sendTo(control(kindSynAck, p.id, 0), addr)
+		return
+	}
+	l.lock.Unlock()
+
+	if c == nil || c.id != p.id {
+		// We don't know this connection (maybe we already finished
+		// it), so tell the peer to give up on it
+		if p.kind != kindRst {
+			l.sendTo(control(kindRst, p.id, 0), addr)
+		}
+		return
+	}
+
+	c.handlePacket(p)
+}
+
+// Should only be called when l.lock is held
+func (l *Listener) newSession(id uint32, key string, addr *net.UDPAddr) *Conn {
+	var c *Conn
+	c = newConn(id, l.conn.LocalAddr(), addr,
+		func(data []byte) { l.sendTo(data, addr) },
+		func() { l.removeSession(key, c) })
+	l.sessions[key] = c
+
+	return c
+}
+
+func (l *Listener) removeSession(key string, c *Conn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.sessions[key] == c {
+		delete(l.sessions, key)
+	}
+	if l.closed && len(l.sessions) == 0 {
+		l.conn.Close()
+	}
+}
+
+func (l *Listener) sendTo(data []byte, addr *net.UDPAddr) {
+	// Errors here are the same as a lost packet:  if it mattered,
+	// we'll send it again
+	l.conn.WriteToUDP(data, addr)
+}
@@ -0,0 +1,91 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Reliable UDP wire format
+//
+// Every datagram starts with the same 9-byte header:
+//
+//	 0        1        2        3        4
+//	+--------+--------+--------+--------+--------+
+//	|  kind  |          connection ID            |
+//	+--------+--------+--------+--------+--------+
+//	|          sequence number          |
+//	+--------+--------+--------+--------+
+//	|  payload (Data only, 0-MaxPayload bytes) ...
+//	+--------+--------+--------+--------+
+//
+// All multi-byte fields are big endian.  The connection ID is picked at
+// random by the client, so the server can tell a new connection from a
+// stray packet left over from an old one on the same address.
+//
+// Data and Fin packets are numbered (starting from 1) and acknowledged
+// one at a time:  an Ack carries the sequence number of the packet it
+// acknowledges.  A receiver with no room for a packet answers with Busy
+// (and the same sequence number) instead.  Syn, SynAck, Ack, Rst and
+// Busy packets aren't numbered.
+const (
+	kindSyn    = 1 // Client -> server:  start a connection
+	kindSynAck = 2 // Server -> client:  connection accepted
+	kindData   = 3 // Some bytes of the stream
+	kindAck    = 4 // Got the packet with this sequence number
+	kindFin    = 5 // No more data after this (numbered, like Data)
+	kindRst    = 6 // No such connection (or it's gone)
+	kindBusy   = 7 // Got the packet with this sequence number, but send it again later
+
+	headerSize = 9
+
+	// Largest payload we put in one datagram, small enough to avoid IP
+	// fragmentation on most networks
+	MaxPayload = 1200
+)
+
+type packet struct {
+	kind    uint8
+	id      uint32
+	seq     uint32
+	payload []byte
+}
+
+func (p *packet) marshal() []byte {
+	buf := make([]byte, headerSize+len(p.payload))
+	buf[0] = p.kind
+	binary.BigEndian.PutUint32(buf[1:], p.id)
+	binary.BigEndian.PutUint32(buf[5:], p.seq)
+	copy(buf[headerSize:], p.payload)
+
+	return buf
+}
+
+func unmarshalPacket(buf []byte) (packet, error) {
+	if len(buf) < headerSize {
+		return packet{}, fmt.Errorf("short packet (%d bytes)", len(buf))
+	}
+
+	p := packet{
+		kind: buf[0],
+		id:   binary.BigEndian.Uint32(buf[1:]),
+		seq:  binary.BigEndian.Uint32(buf[5:]),
+	}
+	if p.kind < kindSyn || p.kind > kindBusy {
+		return packet{}, fmt.Errorf("unknown packet kind %d", p.kind)
+	}
+	if len(buf) > headerSize {
+		if p.kind != kindData {
+			return packet{}, fmt.Errorf("unexpected payload in packet kind %d", p.kind)
+		}
+		// The caller reuses buf, so keep our own copy
+		p.payload = append([]byte{}, buf[headerSize:]...)
+	}
+
+	return p, nil
+}
+
+// Build an unnumbered control packet
+func control(kind uint8, id uint32, seq uint32) []byte {
+	p := packet{kind: kind, id: id, seq: seq}
+	return p.marshal()
+}
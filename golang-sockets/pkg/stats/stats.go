@@ -0,0 +1,227 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Statistics for one player, over every round they've played
+type PlayerStats struct {
+	Name           string
+	RoundsPlayed   int // Rounds where the player made at least one guess
+	RoundsWon      int
+	WinningGuesses int // Total guesses over all rounds won, for the average
+	BestRound      int // Fewest guesses in a winning round (0 if never won)
+}
+
+func (p *PlayerStats) AverageGuessesPerWin() float64 {
+	if p.RoundsWon == 0 {
+		return 0
+	}
+	return float64(p.WinningGuesses) / float64(p.RoundsWon)
+}
+
+// A Store keeps statistics for every player in memory, and writes them
+// out to a JSON file after every change so they survive restarts.
+// Players are identified only by their name--there's no
+// authentication, so this is just for fun!
+//
+// The file is written by a goroutine of its own, so recording a round
+// (which rooms do while holding their GameLock) never waits for the
+// disk.  If rounds end faster than we can write, the writes in between
+// are skipped:  each one has everything anyway.
+type Store struct {
+	path string
+
+	lock    sync.Mutex
+	players map[string]*PlayerStats
+	closed  bool
+	saveErr error // From the last write
+
+	dirty chan struct{} // Has something in it when there are changes to write
+	done  chan struct{} // Closed once the writer has stopped
+}
+
+// Load a store from a file, or start an empty one if the file doesn't
+// exist yet
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		players: make(map[string]*PlayerStats),
+		dirty:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var players []*PlayerStats
+		err = json.Unmarshal(data, &players)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range players {
+			s.players[p.Name] = p
+		}
+	}
+
+	go s.writer()
+	return s, nil
+}
+
+// Write out any changes that haven't been yet, and stop writing.
+// Rounds recorded after this are only kept in memory.  Returns the
+// error from the last write, if it failed.
+func (s *Store) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.dirty)
+	s.lock.Unlock()
+
+	<-s.done
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.saveErr
+}
+
+// Record the result of one round.  guesses maps each player who guessed
+// to how many guesses they made; winner is empty if nobody won.  The
+// file is written later (see Store), so this doesn't wait for it, and
+// write errors are logged instead of returned.
+func (s *Store) RecordRound(winner string, guesses map[string]int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for name, n := range guesses {
+		p := s.getLocked(name)
+		p.RoundsPlayed++
+
+		if name == winner {
+			p.RoundsWon++
+			p.WinningGuesses += n
+			if p.BestRound == 0 || n < p.BestRound {
+				p.BestRound = n
+			}
+		}
+	}
+
+	if !s.closed {
+		select {
+		case s.dirty <- struct{}{}:
+		default: // A write is already coming, and it'll have this round too
+		}
+	}
+}
+
+// Get a copy of one player's stats (all zero if we've never seen them)
+func (s *Store) Get(name string) PlayerStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, ok := s.players[name]
+	if !ok {
+		return PlayerStats{Name: name}
+	}
+	return *p
+}
+
+// Get the top n players:  most wins first, then the lowest average
+// number of guesses per win
+func (s *Store) Top(n int) []PlayerStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	players := s.sortedLocked()
+	if len(players) > n {
+		players = players[:n]
+	}
+
+	return players
+}
+
+func (s *Store) getLocked(name string) *PlayerStats {
+	p, ok := s.players[name]
+	if !ok {
+		p = &PlayerStats{Name: name}
+		s.players[name] = p
+	}
+	return p
+}
+
+func (s *Store) sortedLocked() []PlayerStats {
+	players := make([]PlayerStats, 0, len(s.players))
+	for _, p := range s.players {
+		players = append(players, *p)
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		a, b := &players[i], &players[j]
+		if a.RoundsWon != b.RoundsWon {
+			return a.RoundsWon > b.RoundsWon
+		}
+		if a.AverageGuessesPerWin() != b.AverageGuessesPerWin() {
+			return a.AverageGuessesPerWin() < b.AverageGuessesPerWin()
+		}
+		return a.Name < b.Name
+	})
+
+	return players
+}
+
+// Write out the stats whenever they change, until Close
+func (s *Store) writer() {
+	defer close(s.done)
+
+	for range s.dirty {
+		s.lock.Lock()
+		data, err := json.MarshalIndent(s.sortedLocked(), "", "  ")
+		s.lock.Unlock()
+
+		if err == nil {
+			err = s.save(data)
+		}
+		if err != nil {
+			log.Printf("stats:  could not save:  %v\n", err)
+		}
+
+		s.lock.Lock()
+		s.saveErr = err
+		s.lock.Unlock()
+	}
+}
+
+// Write the stats out to the file.  We write to a temporary file first
+// and then rename it, so a crash halfway through writing can't leave us
+// with a corrupted file.  Only the writer calls this, so there's only
+// ever one write going on.
+func (s *Store) save(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op if the rename worked
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
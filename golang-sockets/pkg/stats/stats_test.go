@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTemp(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestRecordRound(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+
+	s.RecordRound("al", map[string]int{"al": 5, "bob": 3})
+	s.RecordRound("al", map[string]int{"al": 2})
+	s.RecordRound("", map[string]int{"al": 4, "bob": 6})
+
+	want := PlayerStats{Name: "al", RoundsPlayed: 3, RoundsWon: 2, WinningGuesses: 7, BestRound: 2}
+	if got := s.Get("al"); got != want {
+		t.Errorf("al:  got %+v, want %+v", got, want)
+	}
+	want = PlayerStats{Name: "bob", RoundsPlayed: 2}
+	if got := s.Get("bob"); got != want {
+		t.Errorf("bob:  got %+v, want %+v", got, want)
+	}
+	if got := s.Get("cy"); got != (PlayerStats{Name: "cy"}) {
+		t.Errorf("cy never played, but got %+v", got)
+	}
+
+	al := s.Get("al")
+	if avg := al.AverageGuessesPerWin(); avg != 3.5 {
+		t.Errorf("al's average is %v, want 3.5", avg)
+	}
+}
+
+// Most wins first, then fewest guesses per win, then by name
+func TestTop(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+
+	s.RecordRound("al", map[string]int{"al": 10})
+	s.RecordRound("bob", map[string]int{"bob": 4})
+	s.RecordRound("bob", map[string]int{"bob": 4})
+	s.RecordRound("cy", map[string]int{"cy": 10})
+	s.RecordRound("", map[string]int{"dee": 1})
+
+	top := s.Top(10)
+	var names []string
+	for _, p := range top {
+		names = append(names, p.Name)
+	}
+	want := []string{"bob", "al", "cy", "dee"}
+	if len(names) != len(want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got %v, want %v", names, want)
+		}
+	}
+
+	if top := s.Top(2); len(top) != 2 || top[1].Name != "al" {
+		t.Errorf("top 2:  got %+v", top)
+	}
+}
+
+// Everything recorded is there again after a restart
+func TestReload(t *testing.T) {
+	s, path := openTemp(t)
+	s.RecordRound("al", map[string]int{"al": 3, "bob": 1})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rounds after Close are only kept in memory
+	s.RecordRound("bob", map[string]int{"bob": 1})
+
+	again, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+
+	want := PlayerStats{Name: "al", RoundsPlayed: 1, RoundsWon: 1, WinningGuesses: 3, BestRound: 3}
+	if got := again.Get("al"); got != want {
+		t.Errorf("al:  got %+v, want %+v", got, want)
+	}
+	if got := again.Get("bob"); got.RoundsWon != 0 || got.RoundsPlayed != 1 {
+		t.Errorf("bob:  got %+v, want the round from before Close only", got)
+	}
+}
+
+func TestOpenBadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Errorf("opened a file that isn't JSON")
+	}
+}